@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hegner123/repfor/pkg/repfor"
+)
+
+// applyPatchTool describes the apply_patch MCP tool.
+var applyPatchTool = Tool{
+	Name:        "apply_patch",
+	Description: "Apply a unified diff (as produced by git diff or diff -u) to existing files, writing each file atomically with its permissions kept. Hunks are located by their content, tolerating line offsets and up to 2 mismatched context lines at each end. Nothing is written unless every hunk applies; the result reports each hunk's outcome, offset and fuzz. Creating, deleting and renaming files is not supported.",
+	InputSchema: InputSchema{
+		Type: "object",
+		Properties: map[string]Property{
+			"patch": {
+				Type:        "string",
+				Description: "The unified diff. Names with git's a/ and b/ prefixes have them removed.",
+			},
+			"dir": {
+				Type:        "string",
+				Description: "Directory the file names in the patch are relative to. Optional, defaults to the current directory.",
+			},
+			"dry_run": {
+				Type:        "boolean",
+				Description: "Check that every hunk applies without modifying files. Optional, defaults to false.",
+				Default:     false,
+			},
+		},
+		Required: []string{"patch"},
+	},
+}
+
+func handleApplyPatchTool(ctx context.Context, sess *session, req JSONRPCRequest, args map[string]any) *JSONRPCResponse {
+	patch, ok := args["patch"].(string)
+	if !ok {
+		return newError(req.ID, -32602, "Missing or invalid 'patch' parameter")
+	}
+	dir := "."
+	if d, ok := args["dir"].(string); ok && d != "" {
+		dir = d
+	}
+	dryRun, _ := args["dry_run"].(bool)
+
+	sandbox, err := sess.sandbox()
+	if err != nil {
+		return newError(req.ID, -32603, err.Error())
+	}
+
+	config := Config{Options: repfor.Options{Dirs: []string{dir}, Sandbox: sandbox, DryRun: dryRun}}
+	run := sess.runs.start(applyPatchTool.Name, &config)
+	run.Config.Patch = patch
+	result, err := repfor.New(config.Options).ApplyPatch(ctx, dir, []byte(patch))
+	run.PatchResult = result
+	// A patch that does not apply is a failed run, though not a failed call
+	runErr := err
+	if err == nil && !result.Applied {
+		runErr = errors.New(result.Summary)
+	}
+	sess.finishRun(run, nil, runErr)
+	if err != nil {
+		return newError(req.ID, -32602, fmt.Sprintf("Cannot parse patch: %v", err))
+	}
+
+	jsonResult, err := json.Marshal(result)
+	if err != nil {
+		return newError(req.ID, -32603, "Failed to marshal result")
+	}
+	return newResponse(req.ID, ToolCallResult{Content: []ContentItem{{Type: "text", Text: string(jsonResult)}}})
+}
+
+// cmdApplyPatch applies a unified diff and records the change for undo. It
+// exits with ExitError when any hunk fails, after printing which.
+func cmdApplyPatch(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("apply-patch", stderr)
+	patchFile := fs.String("patch", "-", "Unified diff to apply (`file`, or - for stdin)")
+	dir := fs.String("dir", ".", "`Directory` the file names in the patch are relative to")
+	dryRun := fs.Bool("dry-run", false, "Check that every hunk applies without modifying files")
+	roots := fs.String("root", "", "Comma-separated directories that files may be written in (defaults to unrestricted)")
+	if ok, code := parseCommandFlags(fs, args); !ok {
+		return code
+	}
+	sandbox, err := sandboxFromFlag(*roots)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return ExitError
+	}
+
+	var patch []byte
+	if *patchFile == "-" {
+		patch, err = io.ReadAll(stdin)
+	} else {
+		patch, err = os.ReadFile(*patchFile)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: reading patch: %v\n", err)
+		return ExitError
+	}
+
+	var changes []repfor.FileChange
+	opts := repfor.Options{
+		Sandbox:  sandbox,
+		DryRun:   *dryRun,
+		OnChange: func(c repfor.FileChange) { changes = append(changes, c) },
+	}
+	result, err := repfor.New(opts).ApplyPatch(context.Background(), *dir, patch)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return ExitError
+	}
+
+	if !*dryRun && len(changes) > 0 {
+		config := Config{Options: repfor.Options{Dirs: []string{*dir}}}
+		entry, err := newJournalEntry(JournalApplied, config, result.Summary, changes)
+		if err == nil {
+			err = saveJournalEntry(entry)
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "Warning: applied patch but could not record it for undo: %v\n", err)
+		}
+	}
+
+	output, err := json.Marshal(result)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: marshaling JSON: %v\n", err)
+		return ExitError
+	}
+	fmt.Fprintln(stdout, string(output))
+	if !result.Applied {
+		return ExitError
+	}
+	return ExitSuccess
+}
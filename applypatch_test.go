@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hegner123/repfor/pkg/repfor"
+)
+
+const testPatch = "diff --git a/a.txt b/a.txt\n--- a/a.txt\n+++ b/a.txt\n@@ -1,2 +1,2 @@\n-foo\n+bar\n end\n"
+
+func TestCLI_ApplyPatch(t *testing.T) {
+	t.Setenv(stateDirEnv, t.TempDir())
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.txt", "foo\nend\n")
+
+	code, stdout, stderr := runCLIInput(t, testPatch, "apply-patch", "--dir", tmpDir, "--dry-run")
+	if code != ExitSuccess || !strings.Contains(stdout, `"summary":"Would apply 1 hunk to 1 file"`) {
+		t.Fatalf("dry run: code %d, stdout %s, stderr %s", code, stdout, stderr)
+	}
+	if content := readFileContent(t, path); content != "foo\nend\n" {
+		t.Errorf("dry run modified the file: %q", content)
+	}
+
+	code, stdout, stderr = runCLIInput(t, testPatch, "apply-patch", "--dir", tmpDir)
+	if code != ExitSuccess {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	var result repfor.PatchResult
+	if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+		t.Fatalf("Invalid JSON output: %v\n%s", err, stdout)
+	}
+	if !result.Applied || len(result.Files) != 1 || !result.Files[0].Hunks[0].Applied {
+		t.Errorf("result = %+v", result)
+	}
+	if content := readFileContent(t, path); content != "bar\nend\n" {
+		t.Errorf("patched file = %q", content)
+	}
+
+	// The patch is recorded like any other change
+	if code, _, stderr := runCLI(t, "undo"); code != ExitSuccess {
+		t.Fatalf("undo failed: %s", stderr)
+	}
+	if content := readFileContent(t, path); content != "foo\nend\n" {
+		t.Errorf("undo left %q", content)
+	}
+
+	createTestFile(t, tmpDir, "a.txt", "other\nend\n")
+	code, stdout, _ = runCLIInput(t, testPatch, "apply-patch", "--dir", tmpDir)
+	if code != ExitError || !strings.Contains(stdout, `"error":"context does not match"`) {
+		t.Errorf("failing hunk: code %d, stdout %s", code, stdout)
+	}
+
+	if code, _, stderr := runCLIInput(t, "not a patch\n", "apply-patch", "--dir", tmpDir); code != ExitError || !strings.Contains(stderr, "no file changes") {
+		t.Errorf("invalid patch: code %d, stderr %q", code, stderr)
+	}
+}
+
+func TestToolsCall_ApplyPatch(t *testing.T) {
+	root := setupTestDir(t)
+	defer cleanupTestDir(t, root)
+	outside := setupTestDir(t)
+	defer cleanupTestDir(t, outside)
+	path := createTestFile(t, root, "a.txt", "foo\nend\n")
+	outsidePath := createTestFile(t, outside, "a.txt", "foo\nend\n")
+
+	sandbox, err := repfor.NewSandbox([]string{root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess := newStdioSession(&bytes.Buffer{}, serverOptions{roots: sandbox})
+	call := func(args map[string]any) (*repfor.PatchResult, *Error) {
+		t.Helper()
+		params, _ := json.Marshal(map[string]any{"name": "apply_patch", "arguments": args})
+		resp := handleRequest(context.Background(), sess, JSONRPCRequest{JSONRPC: "2.0", ID: float64(1), Method: "tools/call", Params: params})
+		if resp.Error != nil {
+			return nil, resp.Error
+		}
+		var result repfor.PatchResult
+		if err := json.Unmarshal([]byte(resp.Result.(ToolCallResult).Content[0].Text), &result); err != nil {
+			t.Fatalf("Invalid result: %v", err)
+		}
+		return &result, nil
+	}
+
+	result, rpcErr := call(map[string]any{"patch": testPatch, "dir": root})
+	if rpcErr != nil || !result.Applied {
+		t.Fatalf("apply_patch = %+v, %v", result, rpcErr)
+	}
+	if content := readFileContent(t, path); content != "bar\nend\n" {
+		t.Errorf("patched file = %q", content)
+	}
+
+	result, rpcErr = call(map[string]any{"patch": testPatch, "dir": outside})
+	if rpcErr != nil || result.Applied || result.Files[0].Error == "" {
+		t.Errorf("patch outside roots = %+v, %v", result, rpcErr)
+	}
+	if content := readFileContent(t, outsidePath); content != "foo\nend\n" {
+		t.Errorf("file outside roots modified: %q", content)
+	}
+
+	if _, rpcErr = call(map[string]any{"dir": root}); rpcErr == nil {
+		t.Error("Expected error without a patch")
+	}
+
+	// Both calls are in the run history, the rejected one as failed
+	applied := decodeRun(t, readResource(t, sess, "repfor://runs/1"))
+	if applied.Tool != "apply_patch" || applied.Status != RunCompleted || applied.Config.Patch != testPatch {
+		t.Errorf("applied run = %+v", applied)
+	}
+	if applied.PatchResult == nil || len(applied.Files) != 1 || !strings.Contains(applied.Files[0].Diff, "-foo\n+bar\n") {
+		t.Errorf("applied run missing its outcome: %+v", applied)
+	}
+	if rejected := decodeRun(t, readResource(t, sess, latestRunURI)); rejected.ID != "2" || rejected.Status != RunFailed {
+		t.Errorf("rejected run = %+v", rejected)
+	}
+}
@@ -0,0 +1,842 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hegner123/repfor/pkg/repfor"
+)
+
+// command is one `repfor <name>` subcommand. run receives the arguments after
+// the command name and returns the process exit code.
+type command struct {
+	name    string
+	usage   string // argument synopsis shown after the command name
+	summary string
+	run     func(args []string, stdin io.Reader, stdout, stderr io.Writer) int
+}
+
+var commands []command
+
+func init() {
+	// Assigned in init because cmdHelp refers back to the table
+	commands = []command{
+		{"search", "--search <string> [options]", "List every match without modifying files", cmdSearch},
+		{"check", "--search <string> [--max-matches <n>] [options]", "Fail when a pattern has more matches than allowed, for CI", cmdCheck},
+		{"replace", "--search <string> --replace <string> [options]", "Replace matches in place and record the change for undo", cmdReplace},
+		{"diff", "--search <string> --replace <string> [options]", "Show the replacement as a unified diff and save it as a plan for apply", cmdDiff},
+		{"filter", "--search <string> --replace <string> [options] < input", "Replace in stdin and write the result to stdout", cmdFilter},
+		{"apply", "[--id <plan>]", "Apply the latest plan saved by diff or replace --dry-run", cmdApply},
+		{"apply-patch", "[--patch <file>] [--dir <dir>] [--dry-run]", "Apply a unified diff through the atomic writer and record it for undo", cmdApplyPatch},
+		{"undo", "[--id <entry>] [--force] [--list]", "Revert the latest change made by replace or apply", cmdUndo},
+		{"serve", "[--http <addr>] [options]", "Run the MCP server (the default when no command is given)", cmdServe},
+		{"help", "[command]", "Show help for a command", cmdHelp},
+	}
+}
+
+// runCommand dispatches os.Args[1:]. A bare invocation, or one starting with
+// a flag, is the MCP server as before subcommands existed; --cli among those
+// flags selects replace instead.
+func runCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	name, rest := "serve", args
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, rest = args[0], args[1:]
+	} else if len(args) > 0 && (args[0] == "-h" || args[0] == "--help") {
+		name, rest = "help", nil
+	} else if i := legacyCLIFlag(args); i >= 0 {
+		name, rest = "replace", append(args[:i:i], args[i+1:]...)
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd.run(rest, stdin, stdout, stderr)
+		}
+	}
+	fmt.Fprintf(stderr, "Error: unknown command %q\n\n", name)
+	printUsage(stderr)
+	return ExitError
+}
+
+// legacyCLIFlag returns the index of the --cli flag in args, or -1.
+func legacyCLIFlag(args []string) int {
+	for i, arg := range args {
+		switch arg {
+		case "-cli", "--cli", "-cli=true", "--cli=true":
+			return i
+		case "--":
+			return -1
+		}
+	}
+	return -1
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: repfor <command> [options]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'repfor help <command>' for the options of a command.")
+	fmt.Fprintln(w, "Without a command repfor runs the MCP server on stdin/stdout.")
+}
+
+func cmdHelp(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		printUsage(stdout)
+		return ExitSuccess
+	}
+	return runCommand([]string{args[0], "-h"}, stdin, stdout, stderr)
+}
+
+// newFlagSet creates the flag set of a command, with usage output that
+// includes the command's synopsis.
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		for _, cmd := range commands {
+			if cmd.name == name {
+				fmt.Fprintf(stderr, "Usage: repfor %s %s\n\n%s.\n\nOptions:\n", cmd.name, cmd.usage, cmd.summary)
+			}
+		}
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseCommandFlags parses args, reporting whether the command should go on
+// and the exit code to return if not.
+func parseCommandFlags(fs *flag.FlagSet, args []string) (bool, int) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return false, ExitSuccess
+		}
+		return false, ExitError
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(fs.Output(), "Error: unexpected argument %q\n", fs.Arg(0))
+		fs.Usage()
+		return false, ExitError
+	}
+	return true, ExitSuccess
+}
+
+// selectionFlags holds the flags shared by every command that runs a search:
+// which files to look at and what to match.
+type selectionFlags struct {
+	config       Config
+	dirs         string
+	files        string
+	filesFrom    string
+	nul          bool
+	excludeFiles string
+	excludeLines string
+	includeLines string
+	roots        string
+	scope        *scopeFlags
+}
+
+func addSelectionFlags(fs *flag.FlagSet) *selectionFlags {
+	s := &selectionFlags{}
+	fs.StringVar(&s.dirs, "dir", "", "Comma-separated list of directories to search (defaults to current directory)")
+	fs.StringVar(&s.files, "file", "", "Comma-separated list of files to process (takes precedence over --dir)")
+	fs.StringVar(&s.filesFrom, "files-from", "", "Read the files to process from `path`, one per line (\"-\" for stdin)")
+	fs.BoolVar(&s.nul, "0", false, "Paths in --files-from are NUL-terminated (find -print0, git ls-files -z)")
+	fs.StringVar(&s.config.Search, "search", "", "String to search for (required)")
+	fs.StringVar(&s.config.Ext, "ext", "", "File extension to filter (e.g., .go, .txt)")
+	fs.StringVar(&s.excludeFiles, "exclude-files", "", "Comma-separated filename patterns to skip (substring match against filename)")
+	fs.StringVar(&s.excludeLines, "exclude-lines", "", "Comma-separated strings to exclude from matched lines")
+	fs.StringVar(&s.includeLines, "include-lines", "", "Comma-separated strings; only lines containing one of them are changed")
+	fs.BoolVar(&s.config.IncludeRegexp, "include-regexp", false, "--include-lines is one regular expression (use | for alternatives)")
+	fs.BoolVar(&s.config.CaseInsensitive, "case-insensitive", false, "Perform case-insensitive search")
+	fs.BoolVar(&s.config.WholeWord, "whole-word", false, "Match whole words only")
+	addWhitespaceFlags(fs, &s.config.Options)
+	fs.BoolVar(&s.config.Recursive, "recursive", false, "Recursively search subdirectories")
+	fs.BoolVar(&s.config.GitTracked, "git-tracked", false, "Only process files tracked by git in each directory")
+	fs.StringVar(&s.config.GitChangedSince, "git-changed-since", "", "Only process files changed since git `ref` in each directory")
+	fs.BoolVar(&s.config.Verbose, "verbose", false, "Show progress on stderr")
+	fs.StringVar(&s.roots, "root", "", "Comma-separated directories that files may be read or written in (defaults to unrestricted)")
+	fs.StringVar(&s.config.Profile, "profile", "", "Profile from .repfor.json to take option defaults from")
+	s.scope = addScopeFlags(fs, &s.config.Options)
+	return s
+}
+
+// scopeFlags limits the replacement to part of each file.
+type scopeFlags struct {
+	lineRange string
+}
+
+func addScopeFlags(fs *flag.FlagSet, opts *repfor.Options) *scopeFlags {
+	s := &scopeFlags{}
+	fs.StringVar(&s.lineRange, "line-range", "", "Only change lines in `range` (e.g. 120-180, 120-); needs --file")
+	fs.StringVar(&opts.BetweenStart, "between-start", "", "Only change lines between a line containing `marker` and the next line containing --between-end")
+	fs.StringVar(&opts.BetweenEnd, "between-end", "", "End `marker` of the --between-start regions")
+	fs.BoolVar(&opts.Outside, "outside", false, "Only change lines outside the --between-start regions instead")
+	fs.StringVar(&opts.ContextBefore, "context-before", "", "Only change matches with a line containing `text` in the --context-window lines above")
+	fs.StringVar(&opts.ContextAfter, "context-after", "", "Only change matches with a line containing `text` in the --context-window lines below")
+	fs.StringVar(&opts.Scope, "scope", "", "Only change matches in `tokens`: code, comments, strings or all (default all)")
+	fs.StringVar(&opts.Language, "language", "", "`Language` of the files for --scope: go, js, python, c, shell, sql or yaml (default: by file extension)")
+	fs.IntVar(&opts.ContextWindow, "context-window", 0, "`Lines` searched for --context-before and --context-after (default 1)")
+	return s
+}
+
+// resolve sets the parsed line range in opts.
+func (s *scopeFlags) resolve(opts *repfor.Options) error {
+	if s.lineRange == "" {
+		return nil
+	}
+	r, err := repfor.ParseLineRange(s.lineRange)
+	if err != nil {
+		return err
+	}
+	opts.LineRange = r
+	return nil
+}
+
+// addWhitespaceFlags registers the flags making whitespace in the search
+// flexible.
+func addWhitespaceFlags(fs *flag.FlagSet, opts *repfor.Options) {
+	fs.BoolVar(&opts.IgnoreWhitespace, "ignore-whitespace", false, "Let any run of spaces and tabs in --search match any other, and indentation differ")
+	fs.BoolVar(&opts.IgnoreLineBreaks, "ignore-line-breaks", false, "With --ignore-whitespace, let line breaks match any whitespace too (re-wrapped text)")
+}
+
+const reindentUsage = "Indent a multi-line --replace like the line each match starts on, in the file's tabs or spaces"
+
+// addLimitFlags registers the flags choosing which matches of a file are
+// replaced.
+func addLimitFlags(fs *flag.FlagSet, opts *repfor.Options) {
+	fs.IntVar(&opts.Occurrence, "occurrence", 0, "Only replace the `N`th match in each file")
+	fs.BoolVar(&opts.FirstOnly, "first-only", false, "Only replace the first match in each file")
+	fs.IntVar(&opts.MaxReplacementsPerFile, "max-replacements-per-file", 0, "Replace at most `N` matches in each file")
+	fs.IntVar(&opts.MaxReplacements, "max-replacements", 0, "Replace at most `N` matches in total")
+}
+
+// addReplaceFlag registers --replace, tracking whether it was given so that an
+// explicit empty replacement (delete mode) can be told apart from a missing one.
+func (s *selectionFlags) addReplaceFlag(fs *flag.FlagSet) {
+	fs.Func("replace", "String to replace with (required, use empty `string` to delete)", func(v string) error {
+		s.config.Replace = v
+		s.config.ReplaceSet = true
+		return nil
+	})
+}
+
+// resolve validates the flags parsed by fs and returns the run configuration,
+// with defaults from the project config filled in for flags not given.
+func (s *selectionFlags) resolve(fs *flag.FlagSet, stdin io.Reader, needReplace bool) (Config, error) {
+	config := s.config
+	if config.Search == "" {
+		return config, errors.New("--search is required")
+	}
+	if needReplace && !config.ReplaceSet {
+		return config, errors.New("--replace is required (use empty string to delete matches)")
+	}
+
+	config.Files = splitList(s.files)
+	if s.filesFrom != "" {
+		paths, err := loadFileList(s.filesFrom, stdin, s.nul)
+		if err != nil {
+			return config, err
+		}
+		config.Files = append(config.Files, paths...)
+	}
+	config.Dirs = splitList(s.dirs)
+	if len(config.Dirs) == 0 {
+		config.Dirs = []string{"."}
+	}
+	config.ExcludeFiles = splitList(s.excludeFiles)
+	config.ExcludeLines = splitList(s.excludeLines)
+	config.IncludeLines = includeList(s.includeLines, config.IncludeRegexp)
+	config.Roots = splitList(s.roots)
+	if err := s.scope.resolve(&config.Options); err != nil {
+		return config, err
+	}
+
+	// Convert literal escape sequences from shell args to actual control characters.
+	// Shell passes \n as two characters (backslash + n); isMultiline() needs real newlines.
+	config.Search = repfor.UnescapeString(config.Search)
+	config.Replace = repfor.UnescapeString(config.Replace)
+
+	if len(config.Roots) > 0 {
+		sandbox, err := repfor.NewSandbox(config.Roots)
+		if err != nil {
+			return config, err
+		}
+		config.Sandbox = sandbox
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[strings.ReplaceAll(f.Name, "-", "_")] = true })
+	if err := applyProjectDefaults(&config, func(option string) bool { return set[option] }); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// splitList splits a comma-separated flag value, trimming each element.
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	list := strings.Split(s, ",")
+	for i := range list {
+		list[i] = strings.TrimSpace(list[i])
+	}
+	return list
+}
+
+// includeList splits an --include-lines value. A regular expression is
+// taken whole, since commas are part of its syntax.
+func includeList(s string, isRegexp bool) []string {
+	if isRegexp && s != "" {
+		return []string{s}
+	}
+	return splitList(s)
+}
+
+// totalReplacements sums the replacements (or matches) across a result.
+func totalReplacements(result *repfor.Result) int {
+	total := 0
+	for _, dir := range result.Directories {
+		total += dir.TotalReplacements
+	}
+	return total
+}
+
+// totalFiles counts the files modified (or matched) across a result.
+func totalFiles(result *repfor.Result) int {
+	total := 0
+	for _, dir := range result.Directories {
+		total += dir.FilesModified
+	}
+	return total
+}
+
+func writeResultJSON(w io.Writer, result *repfor.Result) error {
+	output, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("marshaling JSON: %w", err)
+	}
+	_, err = fmt.Fprintln(w, string(output))
+	return err
+}
+
+// runSearch runs config as a search: a dry run that deletes every match,
+// keeping where they were. In jsonl format each file is streamed as it is
+// done. The summary counts the matches.
+func runSearch(config Config, format string, stream *eventStream) (*repfor.Result, error) {
+	config.Replace = ""
+	config.DryRun = true
+	config.CollectMatches = true
+	if format == outputJSONL {
+		config.OnFile = func(e repfor.FileEvent) {
+			if e.Type == repfor.EventModified {
+				e.Type = "matched"
+			}
+			stream.write(e)
+		}
+	}
+
+	result, err := repfor.New(config.Options).Run(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	result.DryRun = false
+
+	matches, files := totalReplacements(result), totalFiles(result)
+	result.Summary = fmt.Sprintf("Found %d %s in %d %s", matches, plural(matches, "match"), files, plural(files, "file"))
+	return result, nil
+}
+
+// writeSearchResult writes the result of runSearch in format.
+func writeSearchResult(w io.Writer, format string, color bool, result *repfor.Result, search string, stream *eventStream) error {
+	switch format {
+	case outputText:
+		return writeResultText(w, result, false, color)
+	case outputJSONL:
+		return stream.finish(result)
+	case outputSARIF:
+		return writeResultSARIF(w, result, search, false)
+	}
+	return writeResultJSON(w, result)
+}
+
+func cmdSearch(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("search", stderr)
+	sel := addSelectionFlags(fs)
+	out := addOutputFlags(fs, outputAuto)
+	if ok, code := parseCommandFlags(fs, args); !ok {
+		return code
+	}
+	config, err := sel.resolve(fs, stdin, false)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return ExitError
+	}
+	format, color, err := out.resolve(stdout)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return ExitError
+	}
+
+	stream := &eventStream{w: stdout}
+	result, err := runSearch(config, format, stream)
+	if err == nil {
+		err = writeSearchResult(stdout, format, color, result, config.Search, stream)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return ExitError
+	}
+	if totalReplacements(result) == 0 {
+		return ExitNoChanges
+	}
+	return ExitSuccess
+}
+
+// cmdCheck is a search that fails when there are more matches than
+// --max-matches, as a guard against forbidden patterns in CI.
+func cmdCheck(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("check", stderr)
+	sel := addSelectionFlags(fs)
+	maxMatches := fs.Int("max-matches", 0, "Number of matches allowed before the check fails")
+	out := addOutputFlags(fs, outputText)
+	if ok, code := parseCommandFlags(fs, args); !ok {
+		return code
+	}
+	config, err := sel.resolve(fs, stdin, false)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return ExitError
+	}
+	if *maxMatches < 0 {
+		fmt.Fprintf(stderr, "Error: --max-matches must not be negative\n")
+		return ExitError
+	}
+	format, color, err := out.resolve(stdout)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return ExitError
+	}
+
+	stream := &eventStream{w: stdout}
+	result, err := runSearch(config, format, stream)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return ExitError
+	}
+	matches, files := totalReplacements(result), totalFiles(result)
+	failed := matches > *maxMatches
+	verdict := "passed"
+	if failed {
+		verdict = "failed"
+	}
+	result.Summary = fmt.Sprintf("Check %s: %d %s in %d %s, %d allowed",
+		verdict, matches, plural(matches, "match"), files, plural(files, "file"), *maxMatches)
+
+	if format == outputText {
+		err = writeCheckReport(stdout, result, failed, color)
+	} else {
+		err = writeSearchResult(stdout, format, color, result, config.Search, stream)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return ExitError
+	}
+	if failed {
+		return ExitCheckFailed
+	}
+	return ExitSuccess
+}
+
+// runRecorded runs config and records the files it changes (or would change)
+// as a journal entry of the given kind. The run has already happened when
+// the entry is saved, so failing to save it is only a warning on stderr, and
+// leaves the entry without an ID.
+func runRecorded(config Config, kind string, stderr io.Writer) (*repfor.Result, *JournalEntry, error) {
+	var changes []repfor.FileChange
+	config.OnChange = func(c repfor.FileChange) { changes = append(changes, c) }
+
+	result, err := repfor.New(config.Options).Run(context.Background())
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(changes) == 0 {
+		return result, nil, nil
+	}
+
+	entry, err := newJournalEntry(kind, config, result.Summary, changes)
+	if err == nil {
+		err = saveJournalEntry(entry)
+	}
+	if err != nil {
+		what := "record the change for undo"
+		if kind == JournalPlan {
+			what = "save the plan"
+		}
+		fmt.Fprintf(stderr, "Warning: could not %s: %v\n", what, err)
+		if entry != nil {
+			entry.ID = ""
+		}
+	}
+	return result, entry, nil
+}
+
+func cmdReplace(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("replace", stderr)
+	sel := addSelectionFlags(fs)
+	sel.addReplaceFlag(fs)
+	addLimitFlags(fs, &sel.config.Options)
+	fs.BoolVar(&sel.config.Reindent, "reindent", false, reindentUsage)
+	fs.BoolVar(&sel.config.DryRun, "dry-run", false, "Preview changes without modifying files (saved as a plan for apply)")
+	interactive := fs.Bool("interactive", false, "Ask before replacing each match (needs a terminal)")
+	patchOut := fs.String("patch-out", "", "Write the changes to `file` as a git patch instead of modifying files")
+	patchRoot := fs.String("patch-root", ".", "`Directory` the paths in the --patch-out patch are relative to")
+	out := addOutputFlags(fs, outputAuto)
+	if ok, code := parseCommandFlags(fs, args); !ok {
+		return code
+	}
+	config, err := sel.resolve(fs, stdin, true)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		fs.Usage()
+		return ExitError
+	}
+	// A patch stands in for the writes, so nothing is modified or journaled
+	var patchChanges []repfor.FileChange
+	if *patchOut != "" {
+		config.DryRun = true
+		config.OnChange = func(c repfor.FileChange) { patchChanges = append(patchChanges, c) }
+	}
+	format, color, err := out.resolve(stdout)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return ExitError
+	}
+	// Text output shows the replaced lines, SARIF every match
+	config.CollectMatches = format == outputText || format == outputSARIF
+	stream := &eventStream{w: stdout}
+	if format == outputJSONL {
+		config.OnFile = func(e repfor.FileEvent) { stream.write(e) }
+	}
+
+	if *interactive {
+		if !isTerminal(stdin) {
+			fmt.Fprintln(stderr, "Error: --interactive needs a terminal on stdin")
+			return ExitError
+		}
+		promptColor, _ := out.colored(stderr)
+		config.Confirm = newPrompter(stdin, stderr, promptColor).confirm
+	}
+
+	// Warn if search equals replace (no-op)
+	if config.Search == config.Replace {
+		fmt.Fprintln(stderr, "Warning: search and replace are identical, no changes will be made")
+	}
+
+	var result *repfor.Result
+	if *patchOut != "" {
+		result, err = repfor.New(config.Options).Run(context.Background())
+		if err == nil {
+			err = writePatch(*patchOut, *patchRoot, patchChanges)
+		}
+	} else {
+		kind := JournalApplied
+		if config.DryRun {
+			kind = JournalPlan
+		}
+		result, _, err = runRecorded(config, kind, stderr)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return ExitError
+	}
+
+	switch format {
+	case outputText:
+		err = writeResultText(stdout, result, true, color)
+	case outputJSONL:
+		err = stream.finish(result)
+	case outputSARIF:
+		err = writeResultSARIF(stdout, result, config.Search, true)
+	default:
+		err = writeResultJSON(stdout, result)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return ExitError
+	}
+	if totalReplacements(result) == 0 {
+		return ExitNoChanges
+	}
+	return ExitSuccess
+}
+
+func cmdDiff(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("diff", stderr)
+	sel := addSelectionFlags(fs)
+	sel.addReplaceFlag(fs)
+	addLimitFlags(fs, &sel.config.Options)
+	fs.BoolVar(&sel.config.Reindent, "reindent", false, reindentUsage)
+	if ok, code := parseCommandFlags(fs, args); !ok {
+		return code
+	}
+	config, err := sel.resolve(fs, stdin, true)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		fs.Usage()
+		return ExitError
+	}
+	config.DryRun = true
+
+	_, entry, err := runRecorded(config, JournalPlan, stderr)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return ExitError
+	}
+	if entry == nil {
+		return ExitNoChanges
+	}
+
+	for _, f := range entry.Files {
+		name := displayPath(f.Path)
+		fmt.Fprint(stdout, repfor.UnifiedDiff("a/"+name, "b/"+name, f.Before, f.After))
+	}
+	if entry.ID != "" {
+		fmt.Fprintf(stderr, "Saved plan %s; run 'repfor apply' to write it\n", entry.ID)
+	}
+	return ExitSuccess
+}
+
+// writePatch writes changes to name as a git patch with paths relative to
+// root.
+func writePatch(name, root string, changes []repfor.FileChange) error {
+	patch, err := repfor.GitPatch(root, changes)
+	if err != nil {
+		return err
+	}
+	if err := repfor.WriteFile(name, []byte(patch)); err != nil {
+		return fmt.Errorf("writing patch: %w", err)
+	}
+	return nil
+}
+
+// displayPath shows an absolute path relative to the working directory when
+// it lies beneath it.
+func displayPath(path string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(wd, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
+// cmdFilter replaces in stdin for use in a pipeline. The summary goes to
+// stderr (or --summary-file) so that stdout carries only the content. It
+// exits 0 whether or not anything matched, to keep pipelines going.
+func cmdFilter(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("filter", stderr)
+	var opts repfor.Options
+	var replaceSet bool
+	var excludeLines, includeLines, summaryFile string
+	fs.StringVar(&opts.Search, "search", "", "String to search for (required)")
+	fs.Func("replace", "String to replace with (required, use empty `string` to delete)", func(v string) error {
+		opts.Replace = v
+		replaceSet = true
+		return nil
+	})
+	fs.StringVar(&excludeLines, "exclude-lines", "", "Comma-separated strings to exclude from matched lines")
+	fs.StringVar(&includeLines, "include-lines", "", "Comma-separated strings; only lines containing one of them are changed")
+	fs.BoolVar(&opts.IncludeRegexp, "include-regexp", false, "--include-lines is one regular expression (use | for alternatives)")
+	fs.BoolVar(&opts.CaseInsensitive, "case-insensitive", false, "Perform case-insensitive search")
+	fs.BoolVar(&opts.WholeWord, "whole-word", false, "Match whole words only")
+	addWhitespaceFlags(fs, &opts)
+	scope := addScopeFlags(fs, &opts)
+	addLimitFlags(fs, &opts)
+	fs.BoolVar(&opts.Reindent, "reindent", false, reindentUsage)
+	fs.StringVar(&summaryFile, "summary-file", "", "Write the summary JSON to `path` instead of stderr")
+	if ok, code := parseCommandFlags(fs, args); !ok {
+		return code
+	}
+	if opts.Search == "" || !replaceSet {
+		fmt.Fprintln(stderr, "Error: --search and --replace are required")
+		fs.Usage()
+		return ExitError
+	}
+	opts.ExcludeLines = splitList(excludeLines)
+	opts.IncludeLines = includeList(includeLines, opts.IncludeRegexp)
+	opts.Search = repfor.UnescapeString(opts.Search)
+	opts.Replace = repfor.UnescapeString(opts.Replace)
+	if err := scope.resolve(&opts); err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return ExitError
+	}
+
+	result, err := repfor.New(opts).Filter(stdin, stdout)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return ExitError
+	}
+
+	if summaryFile == "" {
+		err = writeResultJSON(stderr, result)
+	} else {
+		var buf bytes.Buffer
+		if err = writeResultJSON(&buf, result); err == nil {
+			err = os.WriteFile(summaryFile, buf.Bytes(), 0644)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: writing summary: %v\n", err)
+		return ExitError
+	}
+	return ExitSuccess
+}
+
+func cmdApply(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("apply", stderr)
+	id := fs.String("id", "", "Plan to apply (defaults to the latest)")
+	roots := fs.String("root", "", "Comma-separated directories that files may be written in (defaults to unrestricted)")
+	if ok, code := parseCommandFlags(fs, args); !ok {
+		return code
+	}
+	sandbox, err := sandboxFromFlag(*roots)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return ExitError
+	}
+
+	plan, err := findJournalEntry(*id, func(e *JournalEntry) bool { return e.Kind == JournalPlan })
+	if errors.Is(err, errNoJournalEntry) {
+		fmt.Fprintln(stderr, "Error: no plan to apply; create one with 'repfor diff'")
+		return ExitError
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return ExitError
+	}
+
+	before := func(f JournalFile) []byte { return f.Before }
+	after := func(f JournalFile) []byte { return f.After }
+	if err := restoreFiles(plan, before, after, sandbox, false); err != nil {
+		fmt.Fprintf(stderr, "Error: %v; run 'repfor diff' again\n", err)
+		return ExitError
+	}
+
+	// The plan becomes an applied entry that undo can revert
+	applied := *plan
+	applied.ID = ""
+	applied.Kind = JournalApplied
+	applied.Config.DryRun = false
+	applied.Summary = strings.Replace(applied.Summary, "Would modify", "Modified", 1)
+	if err := saveJournalEntry(&applied); err != nil {
+		fmt.Fprintf(stderr, "Warning: applied plan but could not record it for undo: %v\n", err)
+	}
+	if err := removeJournalEntry(plan.ID); err != nil {
+		fmt.Fprintf(stderr, "Warning: failed to remove plan %s: %v\n", plan.ID, err)
+	}
+
+	fmt.Fprintf(stdout, "Applied plan %s to %d %s\n", plan.ID, len(plan.Files), plural(len(plan.Files), "file"))
+	return ExitSuccess
+}
+
+func cmdUndo(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("undo", stderr)
+	id := fs.String("id", "", "Entry to undo (defaults to the latest change)")
+	force := fs.Bool("force", false, "Revert even if files were edited after the change")
+	list := fs.Bool("list", false, "List journal entries instead of undoing")
+	roots := fs.String("root", "", "Comma-separated directories that files may be written in (defaults to unrestricted)")
+	if ok, code := parseCommandFlags(fs, args); !ok {
+		return code
+	}
+
+	if *list {
+		entries, err := loadJournal()
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			return ExitError
+		}
+		for _, e := range entries {
+			status := e.Kind
+			if e.Undone {
+				status = "undone"
+			}
+			fmt.Fprintf(stdout, "%s  %-7s  %s  %q -> %q  %s\n",
+				e.ID, status, e.CreatedAt.Local().Format("2006-01-02 15:04:05"), e.Config.Search, e.Config.Replace, e.Summary)
+		}
+		return ExitSuccess
+	}
+
+	sandbox, err := sandboxFromFlag(*roots)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return ExitError
+	}
+
+	entry, err := findJournalEntry(*id, func(e *JournalEntry) bool { return e.Kind == JournalApplied && !e.Undone })
+	if errors.Is(err, errNoJournalEntry) {
+		fmt.Fprintln(stderr, "Error: nothing to undo")
+		return ExitError
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return ExitError
+	}
+
+	before := func(f JournalFile) []byte { return f.Before }
+	after := func(f JournalFile) []byte { return f.After }
+	if err := restoreFiles(entry, after, before, sandbox, *force); err != nil {
+		fmt.Fprintf(stderr, "Error: %v (use --force to revert anyway)\n", err)
+		return ExitError
+	}
+
+	entry.Undone = true
+	if err := saveJournalEntry(entry); err != nil {
+		fmt.Fprintf(stderr, "Warning: reverted but could not update journal: %v\n", err)
+	}
+
+	fmt.Fprintf(stdout, "Reverted %s in %d %s\n", entry.ID, len(entry.Files), plural(len(entry.Files), "file"))
+	return ExitSuccess
+}
+
+func sandboxFromFlag(roots string) (*repfor.Sandbox, error) {
+	if roots == "" {
+		return nil, nil
+	}
+	return repfor.NewSandbox(splitList(roots))
+}
+
+func cmdServe(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("serve", stderr)
+	var config Config
+	var roots string
+	fs.StringVar(&config.HTTPAddr, "http", "", "Serve MCP over Streamable HTTP on this address (e.g. :8080, binds to localhost unless a host is given)")
+	fs.StringVar(&config.HTTPToken, "http-token", os.Getenv("REPFOR_HTTP_TOKEN"), "Bearer token required for HTTP requests (defaults to $REPFOR_HTTP_TOKEN)")
+	fs.StringVar(&roots, "root", "", "Comma-separated directories that files may be read or written in (defaults to unrestricted)")
+	fs.IntVar(&config.HistorySize, "history", defaultRunsLimit, "Number of recent runs exposed as MCP resources per session")
+	if ok, code := parseCommandFlags(fs, args); !ok {
+		return code
+	}
+	config.Roots = splitList(roots)
+
+	if config.HTTPAddr != "" {
+		runHTTPServer(config)
+	} else {
+		runMCPServer(config)
+	}
+	return ExitSuccess
+}
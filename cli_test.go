@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hegner123/repfor/pkg/repfor"
+)
+
+// runCLI runs a repfor command line as main would and returns the exit code
+// and output. Callers point the journal at a temp dir with stateDirEnv.
+func runCLI(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	return runCLIInput(t, "", args...)
+}
+
+// runCLIInput is runCLI with input on stdin.
+func runCLIInput(t *testing.T, input string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := runCommand(args, strings.NewReader(input), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCLI_SearchReportsMatches(t *testing.T) {
+	t.Setenv(stateDirEnv, t.TempDir())
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.txt", "héllo world\nsay world world\n")
+
+	code, stdout, stderr := runCLI(t, "search", "--dir", tmpDir, "--search", "world")
+	if code != ExitSuccess {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+
+	var result repfor.Result
+	if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+		t.Fatalf("Invalid JSON output: %v\n%s", err, stdout)
+	}
+	if result.Summary != "Found 3 matches in 1 file" {
+		t.Errorf("summary = %q", result.Summary)
+	}
+	matches := result.Directories[0].Files[0].Matches
+	want := []repfor.Match{
+		{Line: 1, Column: 7, Match: "world", Text: "héllo world"},
+		{Line: 2, Column: 5, Match: "world", Text: "say world world"},
+		{Line: 2, Column: 11, Match: "world", Text: "say world world"},
+	}
+	if len(matches) != len(want) {
+		t.Fatalf("matches = %+v, want %+v", matches, want)
+	}
+	for i := range want {
+		if matches[i] != want[i] {
+			t.Errorf("match %d = %+v, want %+v", i, matches[i], want[i])
+		}
+	}
+	if content := readFileContent(t, path); content != "héllo world\nsay world world\n" {
+		t.Errorf("search modified file: %q", content)
+	}
+
+	if code, _, _ := runCLI(t, "search", "--dir", tmpDir, "--search", "absent"); code != ExitNoChanges {
+		t.Errorf("exit code without matches = %d, want %d", code, ExitNoChanges)
+	}
+}
+
+func TestCLI_DiffThenApply(t *testing.T) {
+	t.Setenv(stateDirEnv, t.TempDir())
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.txt", "old value\n")
+
+	code, stdout, stderr := runCLI(t, "diff", "--file", path, "--search", "old", "--replace", "new")
+	if code != ExitSuccess {
+		t.Fatalf("diff exit code = %d, stderr: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "-old value\n+new value\n") {
+		t.Errorf("diff output missing change:\n%s", stdout)
+	}
+	if content := readFileContent(t, path); content != "old value\n" {
+		t.Fatalf("diff modified file: %q", content)
+	}
+
+	if code, _, stderr := runCLI(t, "apply"); code != ExitSuccess {
+		t.Fatalf("apply exit code = %d, stderr: %s", code, stderr)
+	}
+	if content := readFileContent(t, path); content != "new value\n" {
+		t.Errorf("after apply = %q", content)
+	}
+
+	// The plan is consumed; the applied change can be undone
+	if code, _, _ := runCLI(t, "apply"); code != ExitError {
+		t.Errorf("second apply exit code = %d, want %d", code, ExitError)
+	}
+	if code, _, stderr := runCLI(t, "undo"); code != ExitSuccess {
+		t.Fatalf("undo exit code = %d, stderr: %s", code, stderr)
+	}
+	if content := readFileContent(t, path); content != "old value\n" {
+		t.Errorf("after undo = %q", content)
+	}
+}
+
+func TestCLI_ApplyRefusesStalePlan(t *testing.T) {
+	t.Setenv(stateDirEnv, t.TempDir())
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.txt", "old value\n")
+
+	runCLI(t, "diff", "--file", path, "--search", "old", "--replace", "new")
+	createTestFile(t, tmpDir, "a.txt", "old value edited\n")
+
+	code, _, stderr := runCLI(t, "apply")
+	if code != ExitError || !strings.Contains(stderr, "has changed") {
+		t.Errorf("apply of stale plan: code %d, stderr %q", code, stderr)
+	}
+	if content := readFileContent(t, path); content != "old value edited\n" {
+		t.Errorf("stale plan was written: %q", content)
+	}
+}
+
+func TestCLI_UndoRefusesEditedFile(t *testing.T) {
+	t.Setenv(stateDirEnv, t.TempDir())
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.txt", "foo\n")
+
+	if code, _, stderr := runCLI(t, "replace", "--file", path, "--search", "foo", "--replace", "bar"); code != ExitSuccess {
+		t.Fatalf("replace exit code = %d, stderr: %s", code, stderr)
+	}
+	createTestFile(t, tmpDir, "a.txt", "bar edited\n")
+
+	if code, _, _ := runCLI(t, "undo"); code != ExitError {
+		t.Errorf("undo over edited file exit code = %d, want %d", code, ExitError)
+	}
+	if code, _, stderr := runCLI(t, "undo", "--force"); code != ExitSuccess {
+		t.Fatalf("undo --force exit code = %d, stderr: %s", code, stderr)
+	}
+	if content := readFileContent(t, path); content != "foo\n" {
+		t.Errorf("after forced undo = %q", content)
+	}
+	if code, _, _ := runCLI(t, "undo"); code != ExitError {
+		t.Errorf("undo with nothing left exit code = %d, want %d", code, ExitError)
+	}
+}
+
+func TestCLI_LegacyFlagIsReplace(t *testing.T) {
+	t.Setenv(stateDirEnv, t.TempDir())
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.txt", "foo\n")
+
+	code, stdout, stderr := runCLI(t, "--search", "foo", "--cli", "--replace", "", "--file", path)
+	if code != ExitSuccess {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, `"total_replacements":1`) {
+		t.Errorf("unexpected output: %s", stdout)
+	}
+	if content := readFileContent(t, path); content != "\n" {
+		t.Errorf("delete via --cli = %q", content)
+	}
+}
+
+func TestCLI_JournalFailureIsWarning(t *testing.T) {
+	// A state dir beneath a regular file cannot be created
+	blocker := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(stateDirEnv, filepath.Join(blocker, "state"))
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.txt", "foo\n")
+
+	for _, args := range [][]string{
+		{"replace", "--file", path, "--search", "foo", "--replace", "bar"},
+		{"--cli", "--file", path, "--search", "bar", "--replace", "baz"},
+	} {
+		code, stdout, stderr := runCLI(t, args...)
+		if code != ExitSuccess || !strings.Contains(stdout, `"total_replacements":1`) {
+			t.Errorf("%v: exit code = %d, stdout: %s", args, code, stdout)
+		}
+		if !strings.Contains(stderr, "Warning: could not record") {
+			t.Errorf("%v: missing warning, stderr: %s", args, stderr)
+		}
+	}
+	if content := readFileContent(t, path); content != "baz\n" {
+		t.Errorf("content = %q", content)
+	}
+
+	code, stdout, stderr := runCLI(t, "diff", "--file", path, "--search", "baz", "--replace", "qux")
+	if code != ExitSuccess || !strings.Contains(stdout, "+qux") || !strings.Contains(stderr, "Warning: could not save the plan") {
+		t.Errorf("diff exit code = %d, stdout: %s, stderr: %s", code, stdout, stderr)
+	}
+}
+
+func TestCLI_Filter(t *testing.T) {
+	code, stdout, stderr := runCLIInput(t, "foo = 1\r\nfoo_bar = 2\r\n", "filter", "--search", "foo", "--replace", "baz", "--whole-word")
+	if code != ExitSuccess {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if stdout != "baz = 1\r\nfoo_bar = 2\r\n" {
+		t.Errorf("stdout = %q", stdout)
+	}
+	if !strings.Contains(stderr, `"summary":"Filtered input: 1 replacement in 1 line"`) {
+		t.Errorf("summary missing from stderr: %s", stderr)
+	}
+
+	summary := filepath.Join(t.TempDir(), "summary.json")
+	code, stdout, stderr = runCLIInput(t, "nothing here", "filter", "--search", "foo", "--replace", "baz", "--summary-file", summary)
+	if code != ExitSuccess || stdout != "nothing here" || stderr != "" {
+		t.Errorf("no-match filter: code %d, stdout %q, stderr %q", code, stdout, stderr)
+	}
+	if content := readFileContent(t, summary); !strings.Contains(content, "0 replacements") {
+		t.Errorf("summary file = %q", content)
+	}
+}
+
+func TestCLI_PatchOut(t *testing.T) {
+	t.Setenv(stateDirEnv, t.TempDir())
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.txt", "foo\n")
+	patchFile := filepath.Join(t.TempDir(), "out.patch")
+
+	code, stdout, stderr := runCLI(t, "replace", "--dir", tmpDir, "--search", "foo", "--replace", "bar", "--patch-out", patchFile, "--patch-root", tmpDir)
+	if code != ExitSuccess {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, `"dry_run":true`) {
+		t.Errorf("result should be a dry run: %s", stdout)
+	}
+	if content := readFileContent(t, path); content != "foo\n" {
+		t.Errorf("file modified despite --patch-out: %q", content)
+	}
+	want := "diff --git a/a.txt b/a.txt\n--- a/a.txt\n+++ b/a.txt\n@@ -1 +1 @@\n-foo\n+bar\n"
+	if patch := readFileContent(t, patchFile); patch != want {
+		t.Errorf("patch = %q, want %q", patch, want)
+	}
+	// Exporting a patch saves no plan for apply
+	if code, _, _ := runCLI(t, "apply"); code != ExitError {
+		t.Errorf("apply after --patch-out: exit code = %d, want %d", code, ExitError)
+	}
+
+	code, _, stderr = runCLI(t, "replace", "--dir", tmpDir, "--search", "foo", "--replace", "bar", "--patch-out", patchFile, "--patch-root", t.TempDir())
+	if code != ExitError || !strings.Contains(stderr, "outside the patch root") {
+		t.Errorf("file outside root: code %d, stderr %q", code, stderr)
+	}
+}
+
+func TestCLI_Check(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.go", "x := oldClient.Do(req)\nok()\ny := oldClient.Do(req)\n")
+
+	code, stdout, stderr := runCLI(t, "check", "--dir", tmpDir, "--search", "oldClient.Do")
+	if code != ExitCheckFailed {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	want := path + ":1:6: x := oldClient.Do(req)\n" +
+		path + ":3:6: y := oldClient.Do(req)\n" +
+		"Check failed: 2 matches in 1 file, 0 allowed\n"
+	if stdout != want {
+		t.Errorf("report:\n%s\nwant:\n%s", stdout, want)
+	}
+	if content := readFileContent(t, path); !strings.Contains(content, "oldClient") {
+		t.Errorf("check modified the file: %q", content)
+	}
+
+	code, stdout, _ = runCLI(t, "check", "--dir", tmpDir, "--search", "oldClient.Do", "--max-matches", "2", "--output", "json")
+	if code != ExitSuccess || !strings.Contains(stdout, `"summary":"Check passed: 2 matches in 1 file, 2 allowed"`) {
+		t.Errorf("within budget: code %d, stdout %s", code, stdout)
+	}
+
+	code, _, _ = runCLI(t, "check", "--dir", tmpDir, "--search", "absent")
+	if code != ExitSuccess {
+		t.Errorf("no matches: exit code = %d", code)
+	}
+
+	code, _, stderr = runCLI(t, "check", "--dir", tmpDir, "--search", "x", "--max-matches", "-1")
+	if code != ExitError || !strings.Contains(stderr, "--max-matches") {
+		t.Errorf("negative budget: code %d, stderr %q", code, stderr)
+	}
+}
+
+func TestCLI_Scope(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.go", "foo\n// BEGIN\nfoo\n// END\nfoo\n")
+
+	code, _, stderr := runCLI(t, "replace", "--file", path, "--search", "foo", "--replace", "bar", "--line-range", "3-")
+	if code != ExitSuccess {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if got, want := readFileContent(t, path), "foo\n// BEGIN\nbar\n// END\nbar\n"; got != want {
+		t.Errorf("line range: got %q, want %q", got, want)
+	}
+
+	code, _, stderr = runCLI(t, "replace", "--file", path, "--search", "bar", "--replace", "baz",
+		"--between-start", "BEGIN", "--between-end", "END", "--outside")
+	if code != ExitSuccess {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if got, want := readFileContent(t, path), "foo\n// BEGIN\nbar\n// END\nbaz\n"; got != want {
+		t.Errorf("outside markers: got %q, want %q", got, want)
+	}
+
+	code, _, stderr = runCLI(t, "replace", "--file", path, "--search", "x", "--replace", "y", "--line-range", "9-3")
+	if code != ExitError || !strings.Contains(stderr, "9-3") {
+		t.Errorf("bad range: code %d, stderr %q", code, stderr)
+	}
+}
+
+func TestCLI_Limits(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.go", "foo foo\nfoo\n")
+
+	code, stdout, stderr := runCLI(t, "replace", "--file", path, "--search", "foo", "--replace", "bar", "--occurrence", "2", "--output", "json")
+	if code != ExitSuccess || strings.Contains(stdout, "truncated") {
+		t.Fatalf("occurrence: code %d, stdout %s, stderr %s", code, stdout, stderr)
+	}
+	if got := readFileContent(t, path); got != "foo bar\nfoo\n" {
+		t.Errorf("occurrence: got %q", got)
+	}
+
+	code, stdout, _ = runCLI(t, "replace", "--file", path, "--search", "foo", "--replace", "bar", "--first-only", "--output", "json")
+	if code != ExitSuccess || !strings.Contains(stdout, `"truncated":true`) {
+		t.Errorf("first only: code %d, stdout %s", code, stdout)
+	}
+	if got := readFileContent(t, path); got != "bar bar\nfoo\n" {
+		t.Errorf("first only: got %q", got)
+	}
+
+	code, _, stderr = runCLI(t, "replace", "--file", path, "--search", "foo", "--replace", "bar", "--max-replacements", "-1")
+	if code != ExitError || !strings.Contains(stderr, "negative") {
+		t.Errorf("negative limit: code %d, stderr %q", code, stderr)
+	}
+}
+
+func TestCLI_IncludeLines(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.go", "import \"old\"\nfunc old() {}\nvar x = old{1,2}\n")
+
+	code, _, stderr := runCLI(t, "replace", "--file", path, "--search", "old", "--replace", "new", "--include-lines", "import,var")
+	if code != ExitSuccess {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if got, want := readFileContent(t, path), "import \"new\"\nfunc old() {}\nvar x = new{1,2}\n"; got != want {
+		t.Errorf("substrings: got %q, want %q", got, want)
+	}
+
+	// A regular expression is not split at its commas
+	code, _, stderr = runCLI(t, "replace", "--file", path, "--search", "new", "--replace", "old", "--include-lines", `\{\d,\d\}$|^func`, "--include-regexp")
+	if code != ExitSuccess {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if got, want := readFileContent(t, path), "import \"new\"\nfunc old() {}\nvar x = old{1,2}\n"; got != want {
+		t.Errorf("regexp: got %q, want %q", got, want)
+	}
+}
+
+func TestCLI_Context(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.yaml", "service: api\n  port: 80\n  timeout: 30\nservice: web\n  timeout: 30\n")
+
+	code, _, stderr := runCLI(t, "replace", "--file", path, "--search", "timeout: 30", "--replace", "timeout: 60",
+		"--context-before", "service: api", "--context-window", "2")
+	if code != ExitSuccess {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if got, want := readFileContent(t, path), "service: api\n  port: 80\n  timeout: 60\nservice: web\n  timeout: 30\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	code, _, _ = runCLI(t, "replace", "--file", path, "--search", "timeout: 30", "--replace", "timeout: 60", "--context-after", "service")
+	if code != ExitNoChanges {
+		t.Errorf("nothing below the last match: exit code = %d", code)
+	}
+}
+
+func TestCLI_TokenScope(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.go", "// log it\nlog(\"log\")\n")
+
+	code, stdout, stderr := runCLI(t, "search", "--file", path, "--search", "log", "--scope", "strings", "--output", "json")
+	if code != ExitSuccess || !strings.Contains(stdout, `"summary":"Found 1 match in 1 file"`) {
+		t.Fatalf("search: code %d, stdout %s, stderr %s", code, stdout, stderr)
+	}
+
+	code, _, stderr = runCLI(t, "replace", "--file", path, "--search", "log", "--replace", "logf", "--whole-word", "--scope", "code")
+	if code != ExitSuccess {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if got, want := readFileContent(t, path), "// log it\nlogf(\"log\")\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	code, _, stderr = runCLI(t, "replace", "--file", path, "--search", "log", "--replace", "x", "--scope", "docs")
+	if code != ExitError || !strings.Contains(stderr, "invalid scope") {
+		t.Errorf("bad scope: code %d, stderr %q", code, stderr)
+	}
+}
+
+func TestCLI_IgnoreWhitespace(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.py", "def f():\n\tif x:\n\t\treturn 1\n")
+
+	code, stdout, stderr := runCLI(t, "replace", "--file", path, "--search", `if x:\n    return 1`, "--replace", "return 1 if x else None",
+		"--ignore-whitespace", "--output", "json")
+	if code != ExitSuccess {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if got, want := readFileContent(t, path), "def f():\n\treturn 1 if x else None\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if !strings.Contains(stdout, `"match":"if x:\n\t\treturn 1"`) {
+		t.Errorf("matched span not reported: %s", stdout)
+	}
+
+	code, _, stderr = runCLI(t, "replace", "--file", path, "--search", "x", "--replace", "y", "--ignore-line-breaks")
+	if code != ExitError || !strings.Contains(stderr, "ignore whitespace") {
+		t.Errorf("line breaks alone: code %d, stderr %q", code, stderr)
+	}
+}
+
+func TestCLI_Reindent(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.go", "func f() {\n\tfor {\n\t\tg()\n\t}\n}\n")
+
+	code, _, stderr := runCLI(t, "replace", "--file", path, "--search", "g()", "--replace", `if ok {\n    g()\n}`, "--reindent")
+	if code != ExitSuccess {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if got, want := readFileContent(t, path), "func f() {\n\tfor {\n\t\tif ok {\n\t\t\tg()\n\t\t}\n\t}\n}\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCLI_UsageErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"unknown command", []string{"frobnicate"}, ExitError},
+		{"missing search", []string{"search"}, ExitError},
+		{"missing replace", []string{"replace", "--search", "x"}, ExitError},
+		{"filter without replace", []string{"filter", "--search", "x"}, ExitError},
+		{"unknown flag", []string{"search", "--search", "x", "--http", ":1"}, ExitError},
+		{"stray argument", []string{"search", "--search", "x", "extra"}, ExitError},
+		{"command help", []string{"help", "diff"}, ExitSuccess},
+		{"top-level help", []string{"--help"}, ExitSuccess},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, _, _ := runCLI(t, tt.args...); code != tt.code {
+				t.Errorf("exit code = %d, want %d", code, tt.code)
+			}
+		})
+	}
+}
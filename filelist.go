@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// maxFileListSize bounds a --files-from / files_from list (64MB)
+const maxFileListSize = 64 * 1024 * 1024
+
+// readFileList parses a list of paths, one per line, or NUL-terminated when
+// nul is set (as written by `find -print0` and `git ls-files -z`). Empty
+// entries are skipped; otherwise paths are taken verbatim, so they may
+// contain commas and spaces.
+func readFileList(r io.Reader, nul bool) ([]string, error) {
+	sep := byte('\n')
+	if nul {
+		sep = 0
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxFileListSize)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexByte(data, sep); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+
+	var paths []string
+	for scanner.Scan() {
+		path := scanner.Text()
+		if !nul {
+			path = strings.TrimSuffix(path, "\r")
+		}
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths, scanner.Err()
+}
+
+// loadFileList reads a path list from the file name, or from stdin when name
+// is "-". A list without any paths is an error: falling back to the
+// directory scan would touch far more than the caller selected.
+func loadFileList(name string, stdin io.Reader, nul bool) ([]string, error) {
+	r := stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	paths, err := readFileList(r, nul)
+	if err != nil {
+		return nil, fmt.Errorf("reading file list %s: %w", name, err)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("file list %s is empty", name)
+	}
+	return paths, nil
+}
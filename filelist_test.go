@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hegner123/repfor/pkg/repfor"
+)
+
+func TestReadFileList(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		nul   bool
+		want  []string
+	}{
+		{"lines", "a.go\nb, c.go\n\nd e.go", false, []string{"a.go", "b, c.go", "d e.go"}},
+		{"crlf", "a.go\r\nb.go\r\n", false, []string{"a.go", "b.go"}},
+		{"nul", "a.go\x00new\nline.go\x00\x00", true, []string{"a.go", "new\nline.go"}},
+		{"empty", "", false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readFileList(strings.NewReader(tt.input), tt.nul)
+			if err != nil {
+				t.Fatalf("readFileList failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCLI_FilesFromStdin(t *testing.T) {
+	t.Setenv(stateDirEnv, t.TempDir())
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	commaPath := createTestFile(t, tmpDir, "a,b.txt", "foo\n")
+	untouched := createTestFile(t, tmpDir, "c.txt", "foo\n")
+	missing := filepath.Join(tmpDir, "missing.txt")
+
+	input := commaPath + "\x00" + missing + "\x00"
+	code, stdout, stderr := runCLIInput(t, input, "replace", "--files-from", "-", "-0", "--search", "foo", "--replace", "bar")
+	if code != ExitSuccess {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if got := readFileContent(t, commaPath); got != "bar\n" {
+		t.Errorf("listed file = %q", got)
+	}
+	if got := readFileContent(t, untouched); got != "foo\n" {
+		t.Errorf("unlisted file was modified: %q", got)
+	}
+
+	var result repfor.Result
+	if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+		t.Fatalf("Invalid JSON output: %v\n%s", err, stdout)
+	}
+	if len(result.Warnings) != 1 || result.Warnings[0].Path != missing {
+		t.Errorf("warnings = %+v, want one for %s", result.Warnings, missing)
+	}
+
+	// An empty list must not fall back to scanning the current directory
+	if code, _, stderr := runCLIInput(t, "\n", "replace", "--files-from", "-", "--search", "foo", "--replace", "bar"); code != ExitError || !strings.Contains(stderr, "empty") {
+		t.Errorf("empty list: code %d, stderr %q", code, stderr)
+	}
+}
+
+func TestToolsCall_FilesFrom(t *testing.T) {
+	root := setupTestDir(t)
+	defer cleanupTestDir(t, root)
+	a := createTestFile(t, root, "a.txt", "foo\n")
+	list := createTestFile(t, root, "list.txt", a+"\n"+filepath.Join(root, "missing.txt")+"\n")
+
+	var buf bytes.Buffer
+	sess := newStdioSession(&buf, serverOptions{})
+	resp := handleRequest(context.Background(), sess, toolsCallRequest(t, map[string]any{"files_from": list, "search": "foo", "replace": "bar"}))
+	if resp.Error != nil {
+		t.Fatalf("tools/call failed: %s", resp.Error.Message)
+	}
+	if got := readFileContent(t, a); got != "bar\n" {
+		t.Errorf("listed file = %q", got)
+	}
+
+	var result repfor.Result
+	text := resp.Result.(ToolCallResult).Content[0].Text
+	if err := json.Unmarshal([]byte(text), &result); err != nil {
+		t.Fatalf("Invalid result: %v", err)
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0].Message, "failed to stat") {
+		t.Errorf("warnings = %+v", result.Warnings)
+	}
+
+	empty := createTestFile(t, root, "empty.txt", "")
+	resp = handleRequest(context.Background(), sess, toolsCallRequest(t, map[string]any{"files_from": empty, "search": "foo", "replace": "bar"}))
+	if resp.Error == nil {
+		t.Error("Expected error for empty files_from")
+	}
+}
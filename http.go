@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MCP Streamable HTTP transport (protocol revision 2025-03-26).
+//
+// A single endpoint accepts:
+//   - POST: one JSON-RPC message or a batch. Responses are returned in the HTTP
+//     response body as application/json; notification-only bodies get 202.
+//   - GET:  an SSE stream carrying server-initiated messages for the session.
+//   - DELETE: terminates the session.
+//
+// Sessions are created by the initialize request and identified by the
+// Mcp-Session-Id header on every subsequent request. A session with no
+// request and no open stream for sessionIdleTimeout is dropped, and at most
+// maxSessions live at once.
+
+const (
+	mcpEndpoint         = "/mcp"
+	sessionHeader       = "Mcp-Session-Id"
+	sseKeepAlive        = 25 * time.Second
+	sessionQueueSize    = 64
+	httpShutdownTimeout = 5 * time.Second
+	maxMessageSize      = 10 * 1024 * 1024 // largest accepted POST body
+	sessionIdleTimeout  = 30 * time.Minute
+	maxSessions         = 256
+)
+
+type httpServer struct {
+	token string
+	opts  serverOptions
+	// checkOrigin rejects browser requests from non-loopback origins to
+	// prevent DNS rebinding. Disabled when a bearer token is configured.
+	checkOrigin bool
+	idleTimeout time.Duration
+	maxSessions int
+
+	mu       sync.Mutex
+	sessions map[string]*httpSession
+}
+
+// httpSession pairs the transport-independent session with the queue feeding
+// its SSE stream.
+type httpSession struct {
+	*session
+	events   chan []byte
+	done     chan struct{}
+	lastUsed time.Time // guarded by httpServer.mu
+	active   int       // POSTs being handled, guarded by httpServer.mu
+
+	streamMu   sync.Mutex
+	streamOpen bool
+	closeOnce  sync.Once
+}
+
+func newHTTPServer(token string, opts serverOptions) *httpServer {
+	return &httpServer{
+		token:       token,
+		opts:        opts,
+		checkOrigin: token == "",
+		idleTimeout: sessionIdleTimeout,
+		maxSessions: maxSessions,
+		sessions:    make(map[string]*httpSession),
+	}
+}
+
+func runHTTPServer(config Config) {
+	addr, err := listenAddr(config.HTTPAddr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid --http address: %v\n", err)
+		os.Exit(ExitError)
+	}
+
+	if !isLoopbackHost(addr) && config.HTTPToken == "" {
+		fmt.Fprintf(os.Stderr, "Warning: listening on %s without --http-token; any client that can reach it may modify files\n", addr)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(mcpEndpoint, newHTTPServer(config.HTTPToken, newServerOptions(config)))
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		<-sigChan
+		fmt.Fprintln(os.Stderr, "Received shutdown signal, exiting gracefully...")
+		ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: HTTP shutdown: %v\n", err)
+		}
+	}()
+
+	fmt.Fprintf(os.Stderr, "repfor MCP server listening on http://%s%s\n", addr, mcpEndpoint)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+}
+
+// listenAddr fills in the loopback host when addr only names a port, so
+// "--http :8080" never exposes the server beyond the local machine.
+func listenAddr(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+func isLoopbackHost(hostport string) bool {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (h *httpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="repfor"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if h.checkOrigin && !allowedOrigin(r.Header.Get("Origin")) {
+		http.Error(w, "forbidden origin", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		h.handlePost(w, r)
+	case http.MethodGet:
+		h.handleStream(w, r)
+	case http.MethodDelete:
+		h.handleDelete(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *httpServer) authorized(r *http.Request) bool {
+	if h.token == "" {
+		return true
+	}
+	auth := r.Header.Get("Authorization")
+	given, ok := strings.CutPrefix(auth, "Bearer ")
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(h.token)) == 1
+}
+
+func allowedOrigin(origin string) bool {
+	if origin == "" {
+		return true // non-browser clients do not send Origin
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return isLoopbackHost(u.Hostname())
+}
+
+func (h *httpServer) handlePost(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMessageSize))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	msgs, batch, err := decodeMessages(body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, newError(nil, -32700, "Parse error"))
+		return
+	}
+
+	var sess *httpSession
+	if hasInitialize(msgs) {
+		sess = h.newSession()
+		if sess == nil {
+			http.Error(w, "too many sessions", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set(sessionHeader, sess.id)
+	} else {
+		var status int
+		sess, status = h.lookupSession(r)
+		if sess == nil {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+	}
+
+	// A long tool call keeps the session from being dropped as idle
+	h.mu.Lock()
+	sess.active++
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		sess.active--
+		sess.lastUsed = time.Now()
+		h.mu.Unlock()
+	}()
+
+	// A client that disconnects cancels its tool calls
+	responses := make([]*JSONRPCResponse, 0, len(msgs))
+	for _, msg := range msgs {
+		if resp := handleRequest(r.Context(), sess.session, msg); resp != nil {
+			responses = append(responses, resp)
+		}
+	}
+
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if batch {
+		writeJSON(w, http.StatusOK, responses)
+		return
+	}
+	writeJSON(w, http.StatusOK, responses[0])
+}
+
+// decodeMessages parses a POST body holding either a single JSON-RPC message
+// or a batch array. The second return reports whether it was a batch.
+func decodeMessages(body []byte) ([]JSONRPCRequest, bool, error) {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var msgs []JSONRPCRequest
+		if err := json.Unmarshal(trimmed, &msgs); err != nil {
+			return nil, true, err
+		}
+		if len(msgs) == 0 {
+			return nil, true, errors.New("empty batch")
+		}
+		return msgs, true, nil
+	}
+
+	var msg JSONRPCRequest
+	if err := json.Unmarshal(trimmed, &msg); err != nil {
+		return nil, false, err
+	}
+	return []JSONRPCRequest{msg}, false, nil
+}
+
+func hasInitialize(msgs []JSONRPCRequest) bool {
+	for _, msg := range msgs {
+		if msg.Method == "initialize" {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *httpServer) handleStream(w http.ResponseWriter, r *http.Request) {
+	if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		http.Error(w, "GET requires Accept: text/event-stream", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sess, status := h.lookupSession(r)
+	if sess == nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	sess.streamMu.Lock()
+	if sess.streamOpen {
+		sess.streamMu.Unlock()
+		http.Error(w, "stream already open for session", http.StatusConflict)
+		return
+	}
+	sess.streamOpen = true
+	sess.streamMu.Unlock()
+	defer func() {
+		sess.streamMu.Lock()
+		sess.streamOpen = false
+		sess.streamMu.Unlock()
+		h.mu.Lock()
+		sess.lastUsed = time.Now()
+		h.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sess.done:
+			return
+		case <-ticker.C:
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case data := <-sess.events:
+			if _, err := fmt.Fprintf(w, "event: message\ndata: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func (h *httpServer) handleDelete(w http.ResponseWriter, r *http.Request) {
+	sess, status := h.lookupSession(r)
+	if sess == nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	h.mu.Lock()
+	delete(h.sessions, sess.id)
+	h.mu.Unlock()
+	sess.close()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newSession registers a new session, or returns nil when maxSessions are
+// live even after idle ones are dropped.
+func (h *httpServer) newSession() *httpSession {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.dropIdleSessions()
+	if h.maxSessions > 0 && len(h.sessions) >= h.maxSessions {
+		return nil
+	}
+
+	hs := &httpSession{
+		events:   make(chan []byte, sessionQueueSize),
+		done:     make(chan struct{}),
+		lastUsed: time.Now(),
+	}
+	hs.session = newSession(newSessionID(), h.opts, func(data []byte) error {
+		select {
+		case hs.events <- data:
+			return nil
+		default:
+			return errors.New("session event queue full")
+		}
+	})
+	hs.streaming = func() bool {
+		hs.streamMu.Lock()
+		defer hs.streamMu.Unlock()
+		return hs.streamOpen
+	}
+	h.sessions[hs.id] = hs
+	return hs
+}
+
+// dropIdleSessions closes and forgets the sessions idle for longer than
+// idleTimeout. A session with an open stream or a request in progress is
+// never idle. The caller holds h.mu.
+func (h *httpServer) dropIdleSessions() {
+	if h.idleTimeout <= 0 {
+		return
+	}
+	for id, hs := range h.sessions {
+		hs.streamMu.Lock()
+		streaming := hs.streamOpen
+		hs.streamMu.Unlock()
+		if !streaming && hs.active == 0 && time.Since(hs.lastUsed) > h.idleTimeout {
+			delete(h.sessions, id)
+			hs.close()
+		}
+	}
+}
+
+// lookupSession returns the session named by the request header. When it
+// cannot, the second return is the HTTP status to reply with: 400 when the
+// header is missing and 404 when the session is unknown or terminated.
+func (h *httpServer) lookupSession(r *http.Request) (*httpSession, int) {
+	id := r.Header.Get(sessionHeader)
+	if id == "" {
+		return nil, http.StatusBadRequest
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.dropIdleSessions()
+	sess, ok := h.sessions[id]
+	if !ok {
+		return nil, http.StatusNotFound
+	}
+	sess.lastUsed = time.Now()
+	return sess, 0
+}
+
+func (hs *httpSession) close() {
+	hs.closeOnce.Do(func() { close(hs.done) })
+}
+
+func newSessionID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "failed to marshal response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(data); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to write HTTP response: %v\n", err)
+	}
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test helper: POST a JSON-RPC body to the server, optionally within a session
+func postMCP(t *testing.T, srv *httptest.Server, sessionID, body string, header http.Header) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	if sessionID != "" {
+		req.Header.Set(sessionHeader, sessionID)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	return resp
+}
+
+func initializeSession(t *testing.T, srv *httptest.Server) string {
+	t.Helper()
+	resp := postMCP(t, srv, "", `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26"}}`, nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("initialize status = %d, want 200", resp.StatusCode)
+	}
+	id := resp.Header.Get(sessionHeader)
+	if id == "" {
+		t.Fatal("initialize response missing session header")
+	}
+	return id
+}
+
+func decodeResponse(t *testing.T, r io.Reader) JSONRPCResponse {
+	t.Helper()
+	var resp JSONRPCResponse
+	if err := json.NewDecoder(r).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return resp
+}
+
+func TestListenAddr(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{":8080", "127.0.0.1:8080"},
+		{"localhost:9000", "localhost:9000"},
+		{"0.0.0.0:80", "0.0.0.0:80"},
+	}
+	for _, tt := range tests {
+		got, err := listenAddr(tt.in)
+		if err != nil {
+			t.Fatalf("listenAddr(%q) error: %v", tt.in, err)
+		}
+		if got != tt.want {
+			t.Errorf("listenAddr(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	if _, err := listenAddr("8080"); err == nil {
+		t.Error("Expected error for address without port separator")
+	}
+}
+
+func TestHTTP_InitializeNegotiatesVersion(t *testing.T) {
+	srv := httptest.NewServer(newHTTPServer("", serverOptions{}))
+	defer srv.Close()
+
+	resp := postMCP(t, srv, "", `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05"}}`, nil)
+	defer resp.Body.Close()
+
+	rpc := decodeResponse(t, resp.Body)
+	result, _ := rpc.Result.(map[string]any)
+	if result["protocolVersion"] != "2024-11-05" {
+		t.Errorf("protocolVersion = %v, want 2024-11-05", result["protocolVersion"])
+	}
+}
+
+func TestHTTP_SessionRequired(t *testing.T) {
+	srv := httptest.NewServer(newHTTPServer("", serverOptions{}))
+	defer srv.Close()
+
+	resp := postMCP(t, srv, "", `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("missing session: status = %d, want 400", resp.StatusCode)
+	}
+
+	resp = postMCP(t, srv, "deadbeef", `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown session: status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestHTTP_NotificationAccepted(t *testing.T) {
+	srv := httptest.NewServer(newHTTPServer("", serverOptions{}))
+	defer srv.Close()
+	id := initializeSession(t, srv)
+
+	resp := postMCP(t, srv, id, `{"jsonrpc":"2.0","method":"notifications/initialized"}`, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("status = %d, want 202", resp.StatusCode)
+	}
+}
+
+func TestHTTP_ToolsCall(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.txt", "hello world\n")
+
+	srv := httptest.NewServer(newHTTPServer("", serverOptions{}))
+	defer srv.Close()
+	id := initializeSession(t, srv)
+
+	args, _ := json.Marshal(map[string]any{
+		"name":      "repfor",
+		"arguments": map[string]any{"file": path, "search": "hello", "replace": "goodbye"},
+	})
+	resp := postMCP(t, srv, id, `{"jsonrpc":"2.0","id":3,"method":"tools/call","params":`+string(args)+`}`, nil)
+	defer resp.Body.Close()
+
+	rpc := decodeResponse(t, resp.Body)
+	if rpc.Error != nil {
+		t.Fatalf("tools/call error: %v", rpc.Error.Message)
+	}
+	if got := readFileContent(t, path); got != "goodbye world\n" {
+		t.Errorf("content = %q, want %q", got, "goodbye world\n")
+	}
+}
+
+func TestHTTP_Batch(t *testing.T) {
+	srv := httptest.NewServer(newHTTPServer("", serverOptions{}))
+	defer srv.Close()
+	id := initializeSession(t, srv)
+
+	body := `[{"jsonrpc":"2.0","id":1,"method":"tools/list"},{"jsonrpc":"2.0","method":"notifications/initialized"},{"jsonrpc":"2.0","id":2,"method":"nope"}]`
+	resp := postMCP(t, srv, id, body, nil)
+	defer resp.Body.Close()
+
+	var rpcs []JSONRPCResponse
+	if err := json.NewDecoder(resp.Body).Decode(&rpcs); err != nil {
+		t.Fatalf("Failed to decode batch: %v", err)
+	}
+	if len(rpcs) != 2 {
+		t.Fatalf("Expected 2 responses (notification has none), got %d", len(rpcs))
+	}
+	if rpcs[1].Error == nil || rpcs[1].Error.Code != -32601 {
+		t.Errorf("Expected method-not-found for second response, got %+v", rpcs[1])
+	}
+}
+
+func TestHTTP_BearerToken(t *testing.T) {
+	srv := httptest.NewServer(newHTTPServer("s3cret", serverOptions{}))
+	defer srv.Close()
+
+	body := `{"jsonrpc":"2.0","id":1,"method":"initialize"}`
+	resp := postMCP(t, srv, "", body, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("no token: status = %d, want 401", resp.StatusCode)
+	}
+
+	resp = postMCP(t, srv, "", body, http.Header{"Authorization": {"Bearer wrong"}})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("wrong token: status = %d, want 401", resp.StatusCode)
+	}
+
+	resp = postMCP(t, srv, "", body, http.Header{"Authorization": {"Bearer s3cret"}})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("valid token: status = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestHTTP_RejectsForeignOrigin(t *testing.T) {
+	srv := httptest.NewServer(newHTTPServer("", serverOptions{}))
+	defer srv.Close()
+
+	body := `{"jsonrpc":"2.0","id":1,"method":"initialize"}`
+	resp := postMCP(t, srv, "", body, http.Header{"Origin": {"https://evil.example"}})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("foreign origin: status = %d, want 403", resp.StatusCode)
+	}
+
+	resp = postMCP(t, srv, "", body, http.Header{"Origin": {"http://localhost:3000"}})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("loopback origin: status = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestHTTP_StreamDeliversServerMessages(t *testing.T) {
+	h := newHTTPServer("", serverOptions{})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	id := initializeSession(t, srv)
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set(sessionHeader, id)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	h.mu.Lock()
+	sess := h.sessions[id]
+	h.mu.Unlock()
+	sess.send(map[string]string{"jsonrpc": "2.0", "method": "notifications/test"})
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("stream closed before message arrived")
+			}
+			if strings.HasPrefix(line, "data: ") {
+				if !strings.Contains(line, "notifications/test") {
+					t.Errorf("unexpected event data: %s", line)
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for SSE message")
+		}
+	}
+}
+
+func TestHTTP_DeleteTerminatesSession(t *testing.T) {
+	srv := httptest.NewServer(newHTTPServer("", serverOptions{}))
+	defer srv.Close()
+	id := initializeSession(t, srv)
+
+	req, _ := http.NewRequest(http.MethodDelete, srv.URL, nil)
+	req.Header.Set(sessionHeader, id)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("DELETE failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE status = %d, want 204", resp.StatusCode)
+	}
+
+	resp = postMCP(t, srv, id, `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("terminated session: status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestHTTP_SessionLimits(t *testing.T) {
+	h := newHTTPServer("", serverOptions{})
+	h.maxSessions = 2
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	first := initializeSession(t, srv)
+	initializeSession(t, srv)
+
+	init := `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26"}}`
+	resp := postMCP(t, srv, "", init, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("session over the limit: status = %d, want 503", resp.StatusCode)
+	}
+
+	// An idle session is dropped and makes room for a new one
+	h.mu.Lock()
+	h.sessions[first].lastUsed = time.Now().Add(-2 * h.idleTimeout)
+	h.mu.Unlock()
+	initializeSession(t, srv)
+	resp = postMCP(t, srv, first, `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("idle session: status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestStdioSession_SharesHandleRequest(t *testing.T) {
+	var buf bytes.Buffer
+	sess := newStdioSession(&buf, serverOptions{})
+
+	if resp := handleRequest(context.Background(), sess, JSONRPCRequest{JSONRPC: "2.0", Method: "notifications/initialized"}); resp != nil {
+		t.Errorf("Expected no response for notification, got %+v", resp)
+	}
+
+	resp := handleRequest(context.Background(), sess, JSONRPCRequest{JSONRPC: "2.0", ID: float64(1), Method: "tools/list"})
+	if resp == nil || resp.Error != nil {
+		t.Fatalf("tools/list failed: %+v", resp)
+	}
+	sess.send(resp)
+	if !strings.HasSuffix(buf.String(), "\n") || !strings.Contains(buf.String(), `"tools"`) {
+		t.Errorf("Expected newline-delimited response on stdout, got %q", buf.String())
+	}
+}
+
+func TestHTTP_NotificationsWithoutStream(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.txt", "x\n")
+
+	h := newHTTPServer("", serverOptions{})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	id := initializeSession(t, srv)
+	h.mu.Lock()
+	sess := h.sessions[id]
+	h.mu.Unlock()
+
+	call := func(search, replace string) {
+		t.Helper()
+		params, _ := json.Marshal(map[string]any{"name": "repfor", "arguments": map[string]any{"file": path, "search": search, "replace": replace}})
+		body, _ := json.Marshal(JSONRPCRequest{JSONRPC: "2.0", ID: float64(2), Method: "tools/call", Params: params})
+		resp := postMCP(t, srv, id, string(body), nil)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("tools/call status = %d, want 200", resp.StatusCode)
+		}
+	}
+
+	// Nothing is queued for a client with no stream and no subscription
+	call("x", "y")
+	if n := len(sess.events); n != 0 {
+		t.Errorf("Expected no queued events without a stream, got %d", n)
+	}
+
+	// Updates to a subscribed resource wait for the stream to open
+	resp := postMCP(t, srv, id, `{"jsonrpc":"2.0","id":3,"method":"resources/subscribe","params":{"uri":"repfor://runs"}}`, nil)
+	resp.Body.Close()
+	call("y", "x")
+	if n := len(sess.events); n != 1 {
+		t.Fatalf("Expected 1 queued event after subscribing, got %d", n)
+	}
+	if data := <-sess.events; !strings.Contains(string(data), "notifications/resources/updated") {
+		t.Errorf("Expected resources/updated, got %s", data)
+	}
+}
+
+func TestHTTP_ActiveSessionNotIdle(t *testing.T) {
+	h := newHTTPServer("", serverOptions{})
+	sess := h.newSession()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sess.active = 1
+	sess.lastUsed = time.Now().Add(-2 * h.idleTimeout)
+	h.dropIdleSessions()
+	if _, ok := h.sessions[sess.id]; !ok {
+		t.Fatal("Session with a request in progress was dropped as idle")
+	}
+
+	sess.active = 0
+	h.dropIdleSessions()
+	if _, ok := h.sessions[sess.id]; ok {
+		t.Error("Expected idle session to be dropped once the request ended")
+	}
+}
+
+func TestSession_CancelledToolCall(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.txt", "x\n")
+
+	var buf bytes.Buffer
+	sess := newStdioSession(&buf, serverOptions{})
+
+	// A disconnected client's context stops the run before any file is written
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	resp := handleRequest(ctx, sess, toolsCallRequest(t, map[string]any{"file": path, "search": "x", "replace": "y"}))
+	if resp.Error == nil || !strings.Contains(resp.Error.Message, "context canceled") {
+		t.Errorf("Expected cancelled call to fail, got %+v", resp)
+	}
+	if got := readFileContent(t, path); got != "x\n" {
+		t.Errorf("Cancelled call modified the file: %q", got)
+	}
+
+	// notifications/cancelled cancels the call with that request id
+	callCtx, done := sess.trackCall(context.Background(), float64(7))
+	defer done()
+	handleRequest(context.Background(), sess, JSONRPCRequest{
+		JSONRPC: "2.0", Method: "notifications/cancelled",
+		Params: json.RawMessage(`{"requestId":7,"reason":"user aborted"}`),
+	})
+	if callCtx.Err() == nil {
+		t.Error("Expected notifications/cancelled to cancel the call")
+	}
+}
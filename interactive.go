@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/hegner123/repfor/pkg/repfor"
+)
+
+// contextLines is how many lines are shown above and below a match when
+// asking about it.
+const contextLines = 2
+
+const promptHelp = `y - replace this match
+n - keep this match
+a - replace this and every remaining match in the file
+q - keep this and every remaining match, in all files
+e - edit the replacement for this match
+`
+
+// prompter asks about every match on a terminal, in the manner of
+// `git add -p`. Its confirm method is an Options.Confirm.
+type prompter struct {
+	in    *bufio.Reader
+	out   io.Writer
+	color bool
+
+	path      string   // file whose content is in lines
+	lines     []string // content of path, for context
+	allInFile string   // file whose remaining matches are approved
+	quit      bool     // every remaining match is declined
+}
+
+func newPrompter(in io.Reader, out io.Writer, color bool) *prompter {
+	return &prompter{in: bufio.NewReader(in), out: out, color: color}
+}
+
+func (p *prompter) confirm(prop repfor.Proposal) (string, bool) {
+	if p.quit {
+		return "", false
+	}
+	if p.allInFile == prop.Path {
+		return prop.Replacement, true
+	}
+
+	p.show(prop)
+	for {
+		fmt.Fprint(p.out, p.paint(ansiBlue+ansiBold, "Replace this match [y,n,a,q,e,?]? "))
+		answer, ok := p.readLine()
+		if !ok {
+			p.quit = true
+			return "", false
+		}
+		switch strings.TrimSpace(answer) {
+		case "y":
+			return prop.Replacement, true
+		case "n":
+			return "", false
+		case "a":
+			p.allInFile = prop.Path
+			return prop.Replacement, true
+		case "q":
+			p.quit = true
+			return "", false
+		case "e":
+			fmt.Fprint(p.out, "Replacement: ")
+			text, ok := p.readLine()
+			if !ok {
+				p.quit = true
+				return "", false
+			}
+			return repfor.UnescapeString(text), true
+		default:
+			fmt.Fprint(p.out, p.paint(ansiRed, promptHelp))
+		}
+	}
+}
+
+// readLine reads one answer without its line ending. End of input reads as
+// no answer.
+func (p *prompter) readLine() (string, bool) {
+	line, err := p.in.ReadString('\n')
+	if err != nil && line == "" {
+		fmt.Fprintln(p.out)
+		return "", false
+	}
+	return strings.TrimRight(line, "\r\n"), true
+}
+
+// show prints the match with its surrounding lines, as the line before
+// (match in red) and after (replacement in green).
+func (p *prompter) show(prop repfor.Proposal) {
+	if p.path != prop.Path {
+		p.path = prop.Path
+		p.lines = nil
+		if data, err := os.ReadFile(prop.Path); err == nil {
+			p.lines = strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+		}
+	}
+
+	m := prop.Match
+	match := strings.ReplaceAll(m.Match, "\r", "")
+	replacement := strings.ReplaceAll(prop.Replacement, "\r", "")
+	prefix, suffix := splitAtColumn(m.Text, m.Column, len(m.Match))
+	last := m.Line + strings.Count(match, "\n") // last line the match spans
+
+	fmt.Fprintf(p.out, "\n%s\n", p.paint(ansiBold, fmt.Sprintf("%s:%d:%d", prop.Path, m.Line, m.Column)))
+	for n := max(1, m.Line-contextLines); n < m.Line; n++ {
+		p.contextLine(n)
+	}
+	fmt.Fprintf(p.out, "-%5d  %s%s%s\n", m.Line, prefix, p.paint(ansiRed, match), suffix)
+	fmt.Fprintf(p.out, "+%5d  %s%s%s\n", m.Line, prefix, p.paint(ansiGreen, replacement), suffix)
+	for n := last + 1; n <= last+contextLines; n++ {
+		p.contextLine(n)
+	}
+}
+
+// contextLine prints line n (1-based) of the current file, if it exists.
+func (p *prompter) contextLine(n int) {
+	// The split leaves an empty element after a final newline
+	if n-1 < len(p.lines) && !(n == len(p.lines) && p.lines[n-1] == "") {
+		fmt.Fprintf(p.out, " %5d  %s\n", n, p.paint(ansiDim, p.lines[n-1]))
+	}
+}
+
+// splitAtColumn splits line around the n-byte match starting at the 1-based
+// character column. A match running past the end of the line leaves no
+// suffix.
+func splitAtColumn(line string, column, n int) (string, string) {
+	off := columnOffset(line, column)
+	if off+n > len(line) {
+		return line[:off], ""
+	}
+	return line[:off], line[off+n:]
+}
+
+func (p *prompter) paint(code, s string) string {
+	return paint(p.color, code, s)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hegner123/repfor/pkg/repfor"
+)
+
+func TestPrompter_Answers(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	a := createTestFile(t, tmpDir, "a.txt", "foo foo\nfoo\nfoo\n")
+	b := createTestFile(t, tmpDir, "b.txt", "foo\n")
+
+	// a.txt: help, keep, edit, then all remaining; b.txt: quit
+	input := "?\nn\ne\nbaz\na\nq\n"
+	var out strings.Builder
+	p := newPrompter(strings.NewReader(input), &out, false)
+
+	_, err := repfor.New(repfor.Options{Files: []string{a, b}, Search: "foo", Replace: "bar", Confirm: p.confirm}).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if got := readFileContent(t, a); got != "foo baz\nbar\nbar\n" {
+		t.Errorf("a.txt = %q", got)
+	}
+	if got := readFileContent(t, b); got != "foo\n" {
+		t.Errorf("b.txt should be untouched after q: %q", got)
+	}
+
+	for _, want := range []string{
+		a + ":1:5",
+		"-    1  foo foo\n+    1  foo bar\n     2  foo\n     3  foo\n",
+		"e - edit the replacement",
+		b + ":1:1",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("prompt output missing %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Count(out.String(), "Replace this match") != 5 {
+		t.Errorf("expected 5 prompts (the a answer covers the last match):\n%s", out.String())
+	}
+}
+
+func TestCLI_InteractiveNeedsTerminal(t *testing.T) {
+	t.Setenv(stateDirEnv, t.TempDir())
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.txt", "foo\n")
+
+	code, _, stderr := runCLIInput(t, "y\n", "replace", "--interactive", "--file", path, "--search", "foo", "--replace", "bar")
+	if code != ExitError || !strings.Contains(stderr, "needs a terminal") {
+		t.Errorf("code %d, stderr %q", code, stderr)
+	}
+	if got := readFileContent(t, path); got != "foo\n" {
+		t.Errorf("file modified without confirmation: %q", got)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hegner123/repfor/pkg/repfor"
+)
+
+// The CLI journal records what `repfor diff` planned and what `repfor replace`
+// and `repfor apply` changed, so a plan can be applied later and an applied
+// change can be undone. Each entry keeps the full content of every file
+// before and after, and is stored as one JSON file in the state directory.
+
+const (
+	stateDirEnv  = "REPFOR_STATE_DIR"
+	journalLimit = 20 // entries kept; older ones are pruned
+)
+
+// Journal entry kinds
+const (
+	JournalPlan    = "plan"    // computed but not written (diff, replace --dry-run)
+	JournalApplied = "applied" // written to disk (replace, apply)
+)
+
+type JournalEntry struct {
+	ID        string        `json:"id"`
+	Kind      string        `json:"kind"`
+	CreatedAt time.Time     `json:"created_at"`
+	Config    RunConfig     `json:"config"`
+	Summary   string        `json:"summary"`
+	Files     []JournalFile `json:"files"`
+	Undone    bool          `json:"undone,omitempty"`
+}
+
+// JournalFile is one file of an entry. Path is absolute.
+type JournalFile struct {
+	Path   string `json:"path"`
+	Before []byte `json:"before"`
+	After  []byte `json:"after"`
+}
+
+var errNoJournalEntry = errors.New("no matching journal entry")
+
+// journalDir returns the directory entries are stored in: $REPFOR_STATE_DIR,
+// else "repfor" under the user cache directory.
+func journalDir() (string, error) {
+	if dir := os.Getenv(stateDirEnv); dir != "" {
+		return dir, nil
+	}
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot locate state directory (set %s): %w", stateDirEnv, err)
+	}
+	return filepath.Join(cache, "repfor"), nil
+}
+
+// newJournalEntry builds an entry from the changes a run reported.
+func newJournalEntry(kind string, config Config, summary string, changes []repfor.FileChange) (*JournalEntry, error) {
+	entry := &JournalEntry{
+		Kind:      kind,
+		CreatedAt: time.Now().UTC(),
+		Config:    runConfigOf(config),
+		Summary:   summary,
+	}
+	for _, c := range changes {
+		abs, err := filepath.Abs(c.Path)
+		if err != nil {
+			return nil, err
+		}
+		entry.Files = append(entry.Files, JournalFile{Path: abs, Before: c.Before, After: c.After})
+	}
+	return entry, nil
+}
+
+// saveJournalEntry writes entry, assigning it an ID if it has none, and
+// prunes the oldest entries beyond journalLimit.
+func saveJournalEntry(entry *JournalEntry) error {
+	dir, err := journalDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if entry.ID == "" {
+		// Zero-padded nanoseconds sort chronologically by name
+		entry.ID = fmt.Sprintf("%020d", time.Now().UnixNano())
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := repfor.WriteFile(filepath.Join(dir, entry.ID+".json"), data); err != nil {
+		return err
+	}
+
+	ids, err := journalIDs(dir)
+	if err != nil {
+		return err
+	}
+	for len(ids) > journalLimit {
+		if err := os.Remove(filepath.Join(dir, ids[0]+".json")); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		ids = ids[1:]
+	}
+	return nil
+}
+
+func removeJournalEntry(id string) error {
+	dir, err := journalDir()
+	if err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(dir, id+".json"))
+}
+
+// journalIDs returns the IDs of the stored entries, oldest first.
+func journalIDs(dir string) ([]string, error) {
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ids []string
+	for _, n := range names {
+		if id, ok := strings.CutSuffix(n.Name(), ".json"); ok && n.Type().IsRegular() {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// loadJournal returns every stored entry, newest first.
+func loadJournal() ([]*JournalEntry, error) {
+	dir, err := journalDir()
+	if err != nil {
+		return nil, err
+	}
+	ids, err := journalIDs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*JournalEntry, 0, len(ids))
+	for i := len(ids) - 1; i >= 0; i-- {
+		data, err := os.ReadFile(filepath.Join(dir, ids[i]+".json"))
+		if err != nil {
+			return nil, err
+		}
+		var entry JournalEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return nil, fmt.Errorf("corrupt journal entry %s: %w", ids[i], err)
+		}
+		entries = append(entries, &entry)
+	}
+	return entries, nil
+}
+
+// findJournalEntry returns the entry with the given ID, or the newest entry
+// accepted by match when id is empty.
+func findJournalEntry(id string, match func(*JournalEntry) bool) (*JournalEntry, error) {
+	entries, err := loadJournal()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if id != "" && e.ID != id {
+			continue
+		}
+		if match(e) {
+			return e, nil
+		}
+	}
+	return nil, errNoJournalEntry
+}
+
+// restoreFiles writes want over every file of entry, first verifying that
+// each file still has the content expect (unless force is set) so that edits
+// made since the entry was recorded are never silently overwritten.
+func restoreFiles(entry *JournalEntry, expect, want func(JournalFile) []byte, sandbox *repfor.Sandbox, force bool) error {
+	for _, f := range entry.Files {
+		if err := sandbox.Check(f.Path); err != nil {
+			return err
+		}
+		if force {
+			continue
+		}
+		current, err := os.ReadFile(f.Path)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(current, expect(f)) {
+			return fmt.Errorf("%s has changed since entry %s was recorded", f.Path, entry.ID)
+		}
+	}
+
+	for _, f := range entry.Files {
+		if err := repfor.WriteFile(f.Path, want(f)); err != nil {
+			return fmt.Errorf("failed to write %s: %w", f.Path, err)
+		}
+	}
+	return nil
+}
@@ -2,61 +2,43 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
-	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
-	"path/filepath"
-	"strings"
+	"sync"
 	"syscall"
-)
-
-type FileModification struct {
-	Path         string `json:"path"`
-	LinesChanged int    `json:"lines_changed"`
-	Replacements int    `json:"replacements"`
-}
-
-type DirectoryResult struct {
-	Dir               string             `json:"dir"`
-	FilesModified     int                `json:"files_modified"`
-	LinesChanged      int                `json:"lines_changed"`
-	TotalReplacements int                `json:"total_replacements"`
-	Files             []FileModification `json:"files"`
-}
+	"time"
 
-type Result struct {
-	Summary     string            `json:"summary"`
-	Directories []DirectoryResult `json:"directories"`
-	DryRun      bool              `json:"dry_run,omitempty"`
-}
+	"github.com/hegner123/repfor/pkg/repfor"
+)
 
+// Config is an engine run plus the settings that only concern the CLI and
+// MCP front ends.
 type Config struct {
-	Dirs            []string
-	Files           []string // file mode (takes precedence over Dirs)
-	Search          string
-	Replace         string
-	Ext             string
-	ExcludeFiles    []string
-	ExcludeLines    []string
-	CaseInsensitive bool
-	WholeWord       bool
-	DryRun          bool
-	Recursive       bool
-	CLIMode         bool
-	Verbose         bool
-	ReplaceSet      bool // tracks if --replace was explicitly provided (allows empty string)
+	repfor.Options
+	ReplaceSet  bool     // tracks if --replace was explicitly provided (allows empty string)
+	Profile     string   // named profile from .repfor.json to apply
+	HTTPAddr    string   // serve MCP over Streamable HTTP on this address instead of stdio
+	HTTPToken   string   // bearer token required by the HTTP transport (optional)
+	HistorySize int      // number of recent runs kept per MCP session as resources
+	Roots       []string // --root directories; tool calls may not touch paths outside them
 }
 
 // MCP JSON-RPC types
+
+// JSONRPCRequest is any incoming message. Requests and notifications carry a
+// Method; responses to server-initiated requests carry Result or Error instead.
 type JSONRPCRequest struct {
 	JSONRPC string          `json:"jsonrpc"`
-	ID      any             `json:"id"`
-	Method  string          `json:"method"`
+	ID      any             `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
 	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
 }
 
 type JSONRPCResponse struct {
@@ -83,7 +65,9 @@ type ServerInfo struct {
 }
 
 type Capabilities struct {
-	Tools map[string]bool `json:"tools"`
+	Tools     map[string]bool `json:"tools"`
+	Resources map[string]bool `json:"resources,omitempty"`
+	Prompts   map[string]bool `json:"prompts,omitempty"`
 }
 
 type ToolsListResult struct {
@@ -123,134 +107,38 @@ type ContentItem struct {
 }
 
 func main() {
-	config := parseFlags()
-
-	if config.CLIMode {
-		runCLI(config)
-		return
-	}
-
-	runMCPServer()
-}
-
-func parseFlags() Config {
-	config := Config{}
-	var dirStr string
-	var excludeFilesStr string
-	var excludeLinesStr string
-
-	flag.BoolVar(&config.CLIMode, "cli", false, "Run in CLI mode (default is MCP server mode)")
-	flag.StringVar(&dirStr, "dir", "", "Comma-separated list of directories to search (defaults to current directory)")
-	var fileStr string
-	flag.StringVar(&fileStr, "file", "", "Comma-separated list of files to process (takes precedence over --dir)")
-	flag.StringVar(&config.Search, "search", "", "String to search for (required)")
-	flag.StringVar(&config.Replace, "replace", "", "String to replace with (required, use empty string to delete)")
-	flag.StringVar(&config.Ext, "ext", "", "File extension to filter (e.g., .go, .txt)")
-	flag.StringVar(&excludeFilesStr, "exclude-files", "", "Comma-separated filename patterns to skip (substring match against filename)")
-	flag.StringVar(&excludeLinesStr, "exclude-lines", "", "Comma-separated strings to exclude from matched lines")
-	flag.BoolVar(&config.CaseInsensitive, "case-insensitive", false, "Perform case-insensitive search")
-	flag.BoolVar(&config.WholeWord, "whole-word", false, "Match whole words only")
-	flag.BoolVar(&config.DryRun, "dry-run", false, "Preview changes without modifying files")
-	flag.BoolVar(&config.Recursive, "recursive", false, "Recursively search subdirectories")
-	flag.BoolVar(&config.Verbose, "verbose", false, "Show progress on stderr")
-
-	flag.Parse()
-
-	// Check if --replace was explicitly set (allows empty string for delete mode)
-	flag.Visit(func(f *flag.Flag) {
-		if f.Name == "replace" {
-			config.ReplaceSet = true
-		}
-	})
-
-	if fileStr != "" {
-		config.Files = strings.Split(fileStr, ",")
-		for i := range config.Files {
-			config.Files[i] = strings.TrimSpace(config.Files[i])
-		}
-	}
-
-	if dirStr != "" {
-		config.Dirs = strings.Split(dirStr, ",")
-		for i := range config.Dirs {
-			config.Dirs[i] = strings.TrimSpace(config.Dirs[i])
-		}
-	} else {
-		config.Dirs = []string{"."}
-	}
-
-	if excludeFilesStr != "" {
-		config.ExcludeFiles = strings.Split(excludeFilesStr, ",")
-		for i := range config.ExcludeFiles {
-			config.ExcludeFiles[i] = strings.TrimSpace(config.ExcludeFiles[i])
-		}
-	}
-
-	if excludeLinesStr != "" {
-		config.ExcludeLines = strings.Split(excludeLinesStr, ",")
-		for i := range config.ExcludeLines {
-			config.ExcludeLines[i] = strings.TrimSpace(config.ExcludeLines[i])
-		}
-	}
-
-	// Convert literal escape sequences from shell args to actual control characters.
-	// Shell passes \n as two characters (backslash + n); isMultiline() needs real newlines.
-	config.Search = unescapeString(config.Search)
-	config.Replace = unescapeString(config.Replace)
-
-	return config
+	os.Exit(runCommand(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
 }
 
 // Exit codes for CLI mode
 const (
-	ExitSuccess   = 0 // Success with changes
-	ExitError     = 1 // Error occurred
-	ExitNoChanges = 2 // Success but no matches found
+	ExitSuccess     = 0 // Success with changes
+	ExitError       = 1 // Error occurred
+	ExitNoChanges   = 2 // Success but no matches found
+	ExitCheckFailed = 3 // check found more matches than allowed
 )
 
-func runCLI(config Config) {
-	if config.Search == "" {
-		fmt.Fprintln(os.Stderr, "Error: --search is required")
-		flag.Usage()
-		os.Exit(ExitError)
-	}
-
-	if !config.ReplaceSet {
-		fmt.Fprintln(os.Stderr, "Error: --replace is required (use empty string to delete matches)")
-		flag.Usage()
-		os.Exit(ExitError)
-	}
-
-	// Warn if search equals replace (no-op)
-	if config.Search == config.Replace {
-		fmt.Fprintln(os.Stderr, "Warning: search and replace are identical, no changes will be made")
-	}
-
-	result, err := replaceInDirectories(config)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(ExitError)
-	}
-
-	output, err := json.Marshal(result)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error marshaling JSON: %v\n", err)
-		os.Exit(ExitError)
-	}
-
-	fmt.Println(string(output))
+// serverOptions configures every MCP session a transport creates.
+type serverOptions struct {
+	roots       *repfor.Sandbox // from --root, fixed for the server's lifetime
+	historySize int
+}
 
-	// Exit with appropriate code
-	totalReplacements := 0
-	for _, dir := range result.Directories {
-		totalReplacements += dir.TotalReplacements
-	}
-	if totalReplacements == 0 {
-		os.Exit(ExitNoChanges)
+// newServerOptions resolves the server-wide settings shared by every session.
+func newServerOptions(config Config) serverOptions {
+	opts := serverOptions{historySize: config.HistorySize}
+	if len(config.Roots) > 0 {
+		sandbox, err := repfor.NewSandbox(config.Roots)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(ExitError)
+		}
+		opts.roots = sandbox
 	}
+	return opts
 }
 
-func runMCPServer() {
+func runMCPServer(config Config) {
 	// Set up signal handling for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -264,6 +152,14 @@ func runMCPServer() {
 		cancel()
 	}()
 
+	sess := newStdioSession(os.Stdout, newServerOptions(config))
+
+	// Tool calls run concurrently so a notifications/cancelled sent while one
+	// is in progress can stop it. Calls still running when stdin closes are
+	// finished and answered before returning.
+	var calls sync.WaitGroup
+	defer calls.Wait()
+
 	scanner := bufio.NewScanner(os.Stdin)
 
 	// Channel to receive scan results
@@ -297,40 +193,120 @@ func runMCPServer() {
 
 			var req JSONRPCRequest
 			if err := json.Unmarshal([]byte(line), &req); err != nil {
-				sendError(nil, -32700, "Parse error")
+				sess.send(newError(nil, -32700, "Parse error"))
 				continue
 			}
 
-			handleRequest(req)
+			if req.Method == "tools/call" {
+				calls.Add(1)
+				go func() {
+					defer calls.Done()
+					if resp := handleRequest(ctx, sess, req); resp != nil {
+						sess.send(resp)
+					}
+				}()
+				continue
+			}
+			if resp := handleRequest(ctx, sess, req); resp != nil {
+				sess.send(resp)
+			}
 		}
 	}
 }
 
-func handleRequest(req JSONRPCRequest) {
+// handleRequest dispatches a single JSON-RPC message for the given session and
+// returns the response to deliver, or nil when none is due. It is shared by the
+// stdio and HTTP transports so both behave identically. Cancelling ctx stops a
+// tool call between files.
+func handleRequest(ctx context.Context, sess *session, req JSONRPCRequest) *JSONRPCResponse {
 	// JSON-RPC 2.0: notifications (no id) must not receive a response
 	isNotification := req.ID == nil
 
+	// Responses to requests the server sent (e.g. roots/list) have no method
+	if req.Method == "" && req.ID != nil {
+		sess.handleResponse(req)
+		return nil
+	}
+
 	switch req.Method {
 	case "initialize":
-		handleInitialize(req)
+		return handleInitialize(sess, req)
 	case "notifications/initialized":
 		// MCP lifecycle notification, no response required
-		return
+		if sess.clientSupportsRoots() {
+			sess.requestRoots()
+		}
+		return nil
+	case "notifications/roots/list_changed":
+		sess.requestRoots()
+		return nil
+	case "notifications/cancelled":
+		sess.cancelCall(req.Params)
+		return nil
 	case "tools/list":
-		handleToolsList(req)
+		return handleToolsList(req)
 	case "tools/call":
-		handleToolsCall(req)
+		ctx, done := sess.trackCall(ctx, req.ID)
+		defer done()
+		return handleToolsCall(ctx, sess, req)
+	case "resources/list":
+		return handleResourcesList(sess, req)
+	case "resources/read":
+		return handleResourcesRead(sess, req)
+	case "resources/subscribe":
+		return handleResourcesSubscribe(sess, req, true)
+	case "resources/unsubscribe":
+		return handleResourcesSubscribe(sess, req, false)
+	case "prompts/list":
+		return handlePromptsList(sess, req)
+	case "prompts/get":
+		return handlePromptsGet(sess, req)
 	default:
 		if isNotification {
-			return
+			return nil
 		}
-		sendError(req.ID, -32601, "Method not found")
+		return newError(req.ID, -32601, "Method not found")
 	}
 }
 
-func handleInitialize(req JSONRPCRequest) {
+// supportedProtocolVersions lists the MCP revisions this server speaks, newest first.
+// Streamable HTTP was introduced in 2025-03-26; stdio clients may still use 2024-11-05.
+var supportedProtocolVersions = []string{"2025-03-26", "2024-11-05"}
+
+type InitializeParams struct {
+	ProtocolVersion string             `json:"protocolVersion"`
+	Capabilities    ClientCapabilities `json:"capabilities"`
+}
+
+type ClientCapabilities struct {
+	Roots *struct {
+		ListChanged bool `json:"listChanged"`
+	} `json:"roots,omitempty"`
+}
+
+func handleInitialize(sess *session, req JSONRPCRequest) *JSONRPCResponse {
+	var params InitializeParams
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return newError(req.ID, -32602, "Invalid params")
+		}
+	}
+
+	sess.state.Lock()
+	sess.supportsRoots = params.Capabilities.Roots != nil
+	sess.state.Unlock()
+
+	// Echo the client's version when we support it, otherwise offer our latest
+	version := supportedProtocolVersions[0]
+	for _, v := range supportedProtocolVersions {
+		if v == params.ProtocolVersion {
+			version = v
+			break
+		}
+	}
+
 	result := InitializeResult{
-		ProtocolVersion: "2024-11-05",
+		ProtocolVersion: version,
 		ServerInfo: ServerInfo{
 			Name:    "repfor",
 			Version: "1.0.0",
@@ -340,12 +316,19 @@ func handleInitialize(req JSONRPCRequest) {
 				"list": true,
 				"call": true,
 			},
+			Resources: map[string]bool{
+				"subscribe":   true,
+				"listChanged": true,
+			},
+			Prompts: map[string]bool{
+				"listChanged": false,
+			},
 		},
 	}
-	sendResponse(req.ID, result)
+	return newResponse(req.ID, result)
 }
 
-func handleToolsList(req JSONRPCRequest) {
+func handleToolsList(req JSONRPCRequest) *JSONRPCResponse {
 	result := ToolsListResult{
 		Tools: []Tool{
 			{
@@ -358,6 +341,10 @@ func handleToolsList(req JSONRPCRequest) {
 							Type:        "array",
 							Description: "Array of file paths to process. Can also accept a single string. Takes precedence over 'dir' if both are provided.",
 						},
+						"files_from": {
+							Type:        "string",
+							Description: "Path of a file listing the files to process, one per line or NUL-separated (e.g. saved output of 'git ls-files -z'). Added to 'file'; use it for long lists or paths containing commas. Optional.",
+						},
 						"dir": {
 							Type:        "array",
 							Description: "Array of directory paths to search. Can also accept a single string for backwards compatibility. Defaults to current directory if not provided.",
@@ -382,6 +369,30 @@ func handleToolsList(req JSONRPCRequest) {
 							Type:        "array",
 							Description: "Line content patterns to filter. Lines containing any of these strings will not be modified. Optional.",
 						},
+						"reindent": {
+							Type:        "boolean",
+							Description: "Re-base a multi-line 'replace' onto the indentation of the line each match starts on: lines after the first keep their indentation relative to the first, in the file's own tabs or spaces. Write the replacement at any indentation. Optional, defaults to false.",
+							Default:     false,
+						},
+						"ignore_whitespace": {
+							Type:        "boolean",
+							Description: "Let each run of spaces and tabs in 'search' match any run of them, and its lines match however they are indented, so a block copied from another file still matches. The matched text is returned in each file's 'matches'. Optional, defaults to false.",
+							Default:     false,
+						},
+						"ignore_line_breaks": {
+							Type:        "boolean",
+							Description: "With ignore_whitespace, let line breaks in 'search' match any whitespace too, so re-wrapped text matches. Optional, defaults to false.",
+							Default:     false,
+						},
+						"include_lines": {
+							Type:        "array",
+							Description: "Line content patterns to require. Only lines containing at least one of these strings are modified; exclude_lines still applies. Optional.",
+						},
+						"include_regexp": {
+							Type:        "boolean",
+							Description: "Treat include_lines as regular expressions (Go RE2 syntax, e.g. '^\\s*func'). Optional, defaults to false.",
+							Default:     false,
+						},
 						"case_insensitive": {
 							Type:        "boolean",
 							Description: "Perform case-insensitive search. Optional, defaults to false.",
@@ -402,49 +413,119 @@ func handleToolsList(req JSONRPCRequest) {
 							Description: "Recursively search subdirectories. Optional, defaults to false.",
 							Default:     false,
 						},
+						"git_tracked": {
+							Type:        "boolean",
+							Description: "Only process files tracked by git in each 'dir' (the repository index), instead of listing the directory. 'ext' and 'exclude_files' still apply; subdirectories are included only with 'recursive'. Optional, defaults to false.",
+							Default:     false,
+						},
+						"line_range": {
+							Type:        "string",
+							Description: "Only change lines in this 1-based, inclusive range, e.g. '120-180', '120-' or '-180'. Requires 'file'. Optional.",
+						},
+						"between": {
+							Type:        "array",
+							Description: "Start and end marker, e.g. ['// BEGIN GENERATED', '// END GENERATED']. Only lines between a line containing the start marker and the next line containing the end marker are changed; the marker lines never are. Optional.",
+						},
+						"outside": {
+							Type:        "boolean",
+							Description: "With 'between', only change lines outside the marked regions instead. Optional, defaults to false.",
+							Default:     false,
+						},
+						"scope": {
+							Type:        "string",
+							Description: "Which tokens matches may be in: 'code' (outside comments and string literals), 'comments', 'strings' or 'all'. Files are tokenized by extension (Go, JS/TS, Python, Java, C-family, shell, SQL, YAML); other files are skipped with a warning. Optional, defaults to 'all'.",
+						},
+						"language": {
+							Type:        "string",
+							Description: "Tokenize every file as this language for 'scope': go, js, python, c, shell, sql or yaml. Optional, defaults to choosing by file extension.",
+						},
+						"context_before": {
+							Type:        "string",
+							Description: "Only replace matches with a line containing this text among the context_window lines above them. Optional.",
+						},
+						"context_after": {
+							Type:        "string",
+							Description: "Only replace matches with a line containing this text among the context_window lines below them. Optional.",
+						},
+						"context_window": {
+							Type:        "integer",
+							Description: "How many lines above and below a match context_before and context_after look at. Optional, defaults to 1.",
+						},
+						"occurrence": {
+							Type:        "integer",
+							Description: "Only replace the Nth (1-based) match in each file. Optional.",
+						},
+						"first_only": {
+							Type:        "boolean",
+							Description: "Only replace the first match in each file. Optional, defaults to false.",
+							Default:     false,
+						},
+						"max_replacements_per_file": {
+							Type:        "integer",
+							Description: "Replace at most this many matches in each file. Optional.",
+						},
+						"max_replacements": {
+							Type:        "integer",
+							Description: "Replace at most this many matches in total. The result has 'truncated: true' when a limit left matches unchanged. Optional.",
+						},
+						"git_changed_since": {
+							Type:        "string",
+							Description: "Only process files in each 'dir' that differ between this git ref (e.g. 'main', 'HEAD~3') and the working tree, including uncommitted changes. Untracked files are not included. Filters apply as for 'git_tracked'. Optional.",
+						},
+						"profile": {
+							Type:        "string",
+							Description: "Named profile from the project's .repfor.json (e.g. 'go', 'docs') supplying defaults for ext, exclude_files, exclude_lines, recursive, case_insensitive and whole_word. Arguments passed explicitly always win. Optional.",
+						},
 					},
 					Required: []string{"search", "replace"},
 				},
 			},
+			applyPatchTool,
 		},
 	}
-	sendResponse(req.ID, result)
+	return newResponse(req.ID, result)
 }
 
-func handleToolsCall(req JSONRPCRequest) {
+func handleToolsCall(ctx context.Context, sess *session, req JSONRPCRequest) *JSONRPCResponse {
 	var params ToolCallParams
 	if err := json.Unmarshal(req.Params, &params); err != nil {
-		sendError(req.ID, -32602, "Invalid params")
-		return
+		return newError(req.ID, -32602, "Invalid params")
 	}
 
-	if params.Name != "repfor" {
-		sendError(req.ID, -32602, "Unknown tool")
-		return
+	switch params.Name {
+	case "repfor":
+	case "apply_patch":
+		return handleApplyPatchTool(ctx, sess, req, params.Arguments)
+	default:
+		return newError(req.ID, -32602, "Unknown tool")
 	}
 
 	search, ok := params.Arguments["search"].(string)
 	if !ok {
-		sendError(req.ID, -32602, "Missing or invalid 'search' parameter")
-		return
+		return newError(req.ID, -32602, "Missing or invalid 'search' parameter")
 	}
 
 	replace, ok := params.Arguments["replace"].(string)
 	if !ok {
-		sendError(req.ID, -32602, "Missing or invalid 'replace' parameter")
-		return
+		return newError(req.ID, -32602, "Missing or invalid 'replace' parameter")
 	}
 
 	// Convert literal escape sequences to actual control characters.
 	// JSON decoding turns \\n into the 2-char string "\n" (backslash + n),
 	// but isMultiline() needs real newline bytes to activate multi-line mode.
-	search = unescapeString(search)
-	replace = unescapeString(replace)
+	search = repfor.UnescapeString(search)
+	replace = repfor.UnescapeString(replace)
 
-	config := Config{
+	sandbox, err := sess.sandbox()
+	if err != nil {
+		return newError(req.ID, -32603, err.Error())
+	}
+
+	config := Config{Options: repfor.Options{
 		Search:  search,
 		Replace: replace,
-	}
+		Sandbox: sandbox,
+	}}
 
 	// File mode takes precedence over directory mode
 	if fileParam, exists := params.Arguments["file"]; exists {
@@ -463,6 +544,25 @@ func handleToolsCall(req JSONRPCRequest) {
 		}
 	}
 
+	if filesFrom, ok := params.Arguments["files_from"].(string); ok && filesFrom != "" {
+		if err := sandbox.Check(filesFrom); err != nil {
+			return newError(req.ID, -32602, err.Error())
+		}
+		data, err := os.ReadFile(filesFrom)
+		if err != nil {
+			return newError(req.ID, -32602, fmt.Sprintf("Cannot read files_from: %v", err))
+		}
+		// NUL-separated when the list contains any NUL, like `git ls-files -z`
+		paths, err := readFileList(bytes.NewReader(data), bytes.IndexByte(data, 0) >= 0)
+		if err != nil {
+			return newError(req.ID, -32602, fmt.Sprintf("Cannot read files_from: %v", err))
+		}
+		if len(paths) == 0 {
+			return newError(req.ID, -32602, "files_from lists no files")
+		}
+		config.Files = append(config.Files, paths...)
+	}
+
 	if dirParam, exists := params.Arguments["dir"]; exists {
 		switch v := dirParam.(type) {
 		case string:
@@ -503,949 +603,314 @@ func handleToolsCall(req JSONRPCRequest) {
 		}
 	}
 
-	if caseInsensitive, ok := params.Arguments["case_insensitive"].(bool); ok {
-		config.CaseInsensitive = caseInsensitive
-	}
-
-	if wholeWord, ok := params.Arguments["whole_word"].(bool); ok {
-		config.WholeWord = wholeWord
+	if ignoreWhitespace, ok := params.Arguments["ignore_whitespace"].(bool); ok {
+		config.IgnoreWhitespace = ignoreWhitespace
 	}
 
-	if dryRun, ok := params.Arguments["dry_run"].(bool); ok {
-		config.DryRun = dryRun
+	if ignoreLineBreaks, ok := params.Arguments["ignore_line_breaks"].(bool); ok {
+		config.IgnoreLineBreaks = ignoreLineBreaks
 	}
 
-	if recursive, ok := params.Arguments["recursive"].(bool); ok {
-		config.Recursive = recursive
-	}
-
-	result, err := replaceInDirectories(config)
-	if err != nil {
-		sendError(req.ID, -32603, fmt.Sprintf("Replacement failed: %v", err))
-		return
-	}
-
-	jsonResult, err := json.Marshal(result)
-	if err != nil {
-		sendError(req.ID, -32603, "Failed to marshal result")
-		return
-	}
-
-	response := ToolCallResult{
-		Content: []ContentItem{
-			{
-				Type: "text",
-				Text: string(jsonResult),
-			},
-		},
-	}
-
-	sendResponse(req.ID, response)
-}
-
-func sendResponse(id any, result any) {
-	resp := JSONRPCResponse{
-		JSONRPC: "2.0",
-		ID:      id,
-		Result:  result,
-	}
-	data, err := json.Marshal(resp)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to marshal response: %v\n", err)
-		return
-	}
-	fmt.Println(string(data))
-}
-
-func sendError(id any, code int, message string) {
-	resp := JSONRPCResponse{
-		JSONRPC: "2.0",
-		ID:      id,
-		Error: &Error{
-			Code:    code,
-			Message: message,
-		},
-	}
-	data, err := json.Marshal(resp)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to marshal error response: %v\n", err)
-		return
-	}
-	fmt.Println(string(data))
-}
-
-func replaceInDirectories(config Config) (*Result, error) {
-	result := &Result{
-		Directories: make([]DirectoryResult, 0, len(config.Dirs)),
-		DryRun:      config.DryRun,
-	}
-
-	// File mode takes precedence over directory mode
-	if len(config.Files) > 0 {
-		dirResult, err := replaceInFiles(config.Files, config)
-		if err != nil {
-			return nil, err
-		}
-		result.Directories = append(result.Directories, *dirResult)
-	} else {
-		// Collect all directories to process
-		dirsToProcess := config.Dirs
-		if config.Recursive {
-			dirsToProcess = collectDirectoriesRecursive(config.Dirs)
-		}
-
-		for _, dir := range dirsToProcess {
-			dirResult, err := replaceInDirectory(dir, config)
-			if err != nil {
-				return nil, err
+	if includeLinesArray, ok := params.Arguments["include_lines"].([]any); ok {
+		config.IncludeLines = make([]string, 0, len(includeLinesArray))
+		for _, v := range includeLinesArray {
+			if str, ok := v.(string); ok {
+				config.IncludeLines = append(config.IncludeLines, str)
 			}
-			result.Directories = append(result.Directories, *dirResult)
 		}
 	}
 
-	// Generate summary
-	totalFiles := 0
-	totalLines := 0
-	totalReplacements := 0
-	dirsWithChanges := 0
-
-	for _, dirResult := range result.Directories {
-		totalFiles += dirResult.FilesModified
-		totalLines += dirResult.LinesChanged
-		totalReplacements += dirResult.TotalReplacements
-		if dirResult.FilesModified > 0 {
-			dirsWithChanges++
-		}
-	}
-
-	// Build summary string
-	var action string
-	if config.DryRun {
-		action = "Would modify"
-	} else {
-		action = "Modified"
-	}
-
-	fileWord := "file"
-	if totalFiles != 1 {
-		fileWord = "files"
+	if includeRegexp, ok := params.Arguments["include_regexp"].(bool); ok {
+		config.IncludeRegexp = includeRegexp
 	}
 
-	lineWord := "line"
-	if totalLines != 1 {
-		lineWord = "lines"
+	if caseInsensitive, ok := params.Arguments["case_insensitive"].(bool); ok {
+		config.CaseInsensitive = caseInsensitive
 	}
 
-	replacementWord := "replacement"
-	if totalReplacements != 1 {
-		replacementWord = "replacements"
+	if wholeWord, ok := params.Arguments["whole_word"].(bool); ok {
+		config.WholeWord = wholeWord
 	}
 
-	var dirInfo string
-	if len(config.Dirs) > 1 {
-		dirWord := "directory"
-		if dirsWithChanges != 1 {
-			dirWord = "directories"
-		}
-		dirInfo = fmt.Sprintf(" across %d %s", dirsWithChanges, dirWord)
+	if reindent, ok := params.Arguments["reindent"].(bool); ok {
+		config.Reindent = reindent
 	}
 
-	result.Summary = fmt.Sprintf("%s %d %s%s: %d %s in %d %s",
-		action, totalFiles, fileWord, dirInfo, totalReplacements, replacementWord, totalLines, lineWord)
-
-	return result, nil
-}
-
-// collectDirectoriesRecursive walks the given directories and returns all directories
-// including subdirectories. The input directories are included in the result.
-func collectDirectoriesRecursive(dirs []string) []string {
-	var allDirs []string
-	seen := make(map[string]bool)
-
-	for _, dir := range dirs {
-		err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Warning: failed to access %s: %v\n", path, err)
-				return nil // Continue walking despite errors
-			}
-			if d.IsDir() {
-				// Use cleaned path to avoid duplicates
-				cleanPath := filepath.Clean(path)
-				if !seen[cleanPath] {
-					seen[cleanPath] = true
-					allDirs = append(allDirs, cleanPath)
-				}
-			}
-			return nil
-		})
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to walk directory %s: %v\n", dir, err)
-		}
+	if dryRun, ok := params.Arguments["dry_run"].(bool); ok {
+		config.DryRun = dryRun
 	}
 
-	return allDirs
-}
-
-func shouldExcludeFile(filename string, patterns []string, caseInsensitive bool) bool {
-	for _, pattern := range patterns {
-		name := filename
-		pat := pattern
-		if caseInsensitive {
-			name = strings.ToLower(name)
-			pat = strings.ToLower(pat)
-		}
-		if strings.Contains(name, pat) {
-			return true
-		}
+	if recursive, ok := params.Arguments["recursive"].(bool); ok {
+		config.Recursive = recursive
 	}
-	return false
-}
 
-func replaceInDirectory(dir string, config Config) (*DirectoryResult, error) {
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read directory: %w", err)
+	if gitTracked, ok := params.Arguments["git_tracked"].(bool); ok {
+		config.GitTracked = gitTracked
 	}
 
-	dirResult := &DirectoryResult{
-		Dir:   dir,
-		Files: make([]FileModification, 0),
+	if gitChangedSince, ok := params.Arguments["git_changed_since"].(string); ok {
+		config.GitChangedSince = gitChangedSince
 	}
 
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-
-		// Skip non-regular files (FIFOs, devices, sockets, etc.)
-		info, err := entry.Info()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to get file info for %s: %v\n", entry.Name(), err)
-			continue
-		}
-		if !info.Mode().IsRegular() {
-			continue
-		}
-
-		filename := entry.Name()
-
-		if config.Ext != "" && !strings.HasSuffix(filename, config.Ext) {
-			continue
-		}
-
-		if shouldExcludeFile(filename, config.ExcludeFiles, config.CaseInsensitive) {
-			continue
-		}
-
-		fullPath := filepath.Join(dir, filename)
-		linesChanged, replacements, err := replaceInFile(fullPath, config)
+	if lineRange, ok := params.Arguments["line_range"].(string); ok {
+		r, err := repfor.ParseLineRange(lineRange)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to process %s: %v\n", fullPath, err)
-			continue
-		}
-
-		if linesChanged > 0 {
-			dirResult.Files = append(dirResult.Files, FileModification{
-				Path:         filename,
-				LinesChanged: linesChanged,
-				Replacements: replacements,
-			})
-			dirResult.FilesModified++
-			dirResult.LinesChanged += linesChanged
-			dirResult.TotalReplacements += replacements
+			return newError(req.ID, -32602, err.Error())
 		}
+		config.LineRange = r
 	}
 
-	return dirResult, nil
-}
-
-func replaceInFiles(filePaths []string, config Config) (*DirectoryResult, error) {
-	dirResult := &DirectoryResult{
-		Dir:   "(files)",
-		Files: make([]FileModification, 0, len(filePaths)),
-	}
-
-	for _, filePath := range filePaths {
-		// Verify file exists and is a regular file
-		info, err := os.Stat(filePath)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to stat file %s: %v\n", filePath, err)
-			continue
+	if between, ok := params.Arguments["between"]; ok {
+		markers, ok := between.([]any)
+		if !ok || len(markers) != 2 {
+			return newError(req.ID, -32602, "'between' must be a start and an end marker")
 		}
-		if !info.Mode().IsRegular() {
-			fmt.Fprintf(os.Stderr, "Warning: not a regular file: %s\n", filePath)
-			continue
-		}
-
-		// Check extension filter if specified
-		if config.Ext != "" && !strings.HasSuffix(filePath, config.Ext) {
-			continue
-		}
-
-		if shouldExcludeFile(filepath.Base(filePath), config.ExcludeFiles, config.CaseInsensitive) {
-			continue
-		}
-
-		linesChanged, replacements, err := replaceInFile(filePath, config)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to process %s: %v\n", filePath, err)
-			continue
-		}
-
-		if linesChanged > 0 {
-			dirResult.Files = append(dirResult.Files, FileModification{
-				Path:         filePath,
-				LinesChanged: linesChanged,
-				Replacements: replacements,
-			})
-			dirResult.FilesModified++
-			dirResult.LinesChanged += linesChanged
-			dirResult.TotalReplacements += replacements
+		start, ok1 := markers[0].(string)
+		end, ok2 := markers[1].(string)
+		if !ok1 || !ok2 {
+			return newError(req.ID, -32602, "'between' must be a start and an end marker")
 		}
+		config.BetweenStart, config.BetweenEnd = start, end
 	}
 
-	return dirResult, nil
-}
-
-// maxLineSize is the maximum line size in bytes (10MB)
-const maxLineSize = 10 * 1024 * 1024
-
-func replaceInFile(path string, config Config) (int, int, error) {
-	// Early exit: if search equals replace, it's a no-op
-	if config.Search == config.Replace {
-		return 0, 0, nil
+	if outside, ok := params.Arguments["outside"].(bool); ok {
+		config.Outside = outside
 	}
 
-	// Dispatch to multiline path when search or replace contains newlines
-	if isMultiline(config.Search, config.Replace) {
-		return replaceInFileMultiline(path, config)
+	if scope, ok := params.Arguments["scope"].(string); ok {
+		config.Scope = scope
 	}
 
-	file, err := os.Open(path)
-	if err != nil {
-		return 0, 0, err
-	}
-	defer func() {
-		if cerr := file.Close(); cerr != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to close file %s: %v\n", path, cerr)
-		}
-	}()
-
-	// Detect line ending style by reading first chunk
-	lineEnding := "\n" // default to Unix style
-	detectBuf := make([]byte, 8192)
-	n, _ := file.Read(detectBuf)
-	if n > 0 {
-		for i := 0; i < n-1; i++ {
-			if detectBuf[i] == '\r' && detectBuf[i+1] == '\n' {
-				lineEnding = "\r\n"
-				break
-			}
-			if detectBuf[i] == '\n' {
-				break // Unix style confirmed
-			}
-		}
-	}
-	// Reset file to beginning
-	if _, err := file.Seek(0, 0); err != nil {
-		return 0, 0, fmt.Errorf("failed to seek file: %w", err)
+	if language, ok := params.Arguments["language"].(string); ok {
+		config.Language = language
 	}
 
-	var lines []string
-	scanner := bufio.NewScanner(file)
-	// Increase buffer size to handle very long lines (default is 64KB, set to 10MB)
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, maxLineSize)
-	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
+	if contextBefore, ok := params.Arguments["context_before"].(string); ok {
+		config.ContextBefore = contextBefore
 	}
 
-	if err := scanner.Err(); err != nil {
-		// Provide specific error for lines that are too long
-		if errors.Is(err, bufio.ErrTooLong) {
-			return 0, 0, fmt.Errorf("line too long (max %dMB): %w", maxLineSize/(1024*1024), err)
-		}
-		return 0, 0, err
+	if contextAfter, ok := params.Arguments["context_after"].(string); ok {
+		config.ContextAfter = contextAfter
 	}
 
-	linesChanged := 0
-	totalReplacements := 0
-	modifiedLines := make([]string, len(lines))
-	copy(modifiedLines, lines)
-
-	searchTerm := config.Search
-	replaceTerm := config.Replace
-	if config.CaseInsensitive {
-		searchTerm = strings.ToLower(searchTerm)
+	if contextWindow, ok := params.Arguments["context_window"].(float64); ok {
+		config.ContextWindow = int(contextWindow)
 	}
 
-	for i, line := range lines {
-		lineToCheck := line
-		if config.CaseInsensitive {
-			lineToCheck = strings.ToLower(line)
-		}
-
-		found := false
-		if config.WholeWord {
-			found = containsWholeWord(lineToCheck, searchTerm)
-		} else {
-			found = strings.Contains(lineToCheck, searchTerm)
-		}
-
-		if !found {
-			continue
-		}
-
-		excluded := false
-		for _, excludePattern := range config.ExcludeLines {
-			excludeToCheck := excludePattern
-			lineForExclude := line
-			if config.CaseInsensitive {
-				excludeToCheck = strings.ToLower(excludePattern)
-				lineForExclude = lineToCheck
-			}
-			if strings.Contains(lineForExclude, excludeToCheck) {
-				excluded = true
-				// DEBUG: uncomment for diagnostics
-				// fmt.Fprintf(os.Stderr, "DEBUG: Line %d excluded by pattern %q: %q\n", i, excludePattern, line)
-				break
-			}
-		}
-
-		if excluded {
-			continue
-		}
-
-		newLine := replaceInLine(line, config.Search, replaceTerm, config.CaseInsensitive, config.WholeWord)
-		if newLine != line {
-			modifiedLines[i] = newLine
-			linesChanged++
-			totalReplacements += countReplacements(line, config.Search, config.CaseInsensitive, config.WholeWord)
-		}
+	if occurrence, ok := params.Arguments["occurrence"].(float64); ok {
+		config.Occurrence = int(occurrence)
 	}
 
-	if linesChanged > 0 && !config.DryRun {
-		err := writeFileAtomic(path, modifiedLines, lineEnding)
-		if err != nil {
-			return 0, 0, fmt.Errorf("failed to write file: %w", err)
-		}
-		if config.Verbose {
-			fmt.Fprintf(os.Stderr, "Modified: %s (%d replacements in %d lines)\n", path, totalReplacements, linesChanged)
-		}
+	if firstOnly, ok := params.Arguments["first_only"].(bool); ok {
+		config.FirstOnly = firstOnly
 	}
 
-	return linesChanged, totalReplacements, nil
-}
-
-func replaceInLine(line, search, replace string, caseInsensitive, wholeWord bool) string {
-	if search == "" {
-		return line
+	if maxReplacements, ok := params.Arguments["max_replacements"].(float64); ok {
+		config.MaxReplacements = int(maxReplacements)
 	}
 
-	if !caseInsensitive && !wholeWord {
-		return strings.ReplaceAll(line, search, replace)
+	if maxPerFile, ok := params.Arguments["max_replacements_per_file"].(float64); ok {
+		config.MaxReplacementsPerFile = int(maxPerFile)
 	}
 
-	if caseInsensitive && !wholeWord {
-		return caseInsensitiveReplace(line, search, replace)
+	if profile, ok := params.Arguments["profile"].(string); ok {
+		config.Profile = profile
 	}
 
-	if wholeWord && !caseInsensitive {
-		return wholeWordReplace(line, search, replace)
+	explicit := func(option string) bool {
+		_, ok := params.Arguments[option]
+		return ok
 	}
-
-	return caseInsensitiveWholeWordReplace(line, search, replace)
-}
-
-func caseInsensitiveReplace(line, search, replace string) string {
-	if search == "" {
-		return line
+	if err := applyProjectDefaults(&config, explicit); err != nil {
+		return newError(req.ID, -32602, err.Error())
 	}
 
-	searchLower := strings.ToLower(search)
-	var result strings.Builder
-	result.Grow(len(line))
-	remaining := line
-
-	for {
-		lineLower := strings.ToLower(remaining)
-		idx := strings.Index(lineLower, searchLower)
-		if idx == -1 {
-			result.WriteString(remaining)
-			break
-		}
-
-		result.WriteString(remaining[:idx])
-		result.WriteString(replace)
-		remaining = remaining[idx+len(search):]
+	run := sess.runs.start("repfor", &config)
+	result, err := repfor.New(config.Options).Run(ctx)
+	sess.finishRun(run, result, err)
+	if err != nil {
+		return newError(req.ID, -32603, fmt.Sprintf("Replacement failed: %v", err))
 	}
 
-	return result.String()
-}
-
-func wholeWordReplace(line, search, replace string) string {
-	if search == "" {
-		return line
+	jsonResult, err := json.Marshal(result)
+	if err != nil {
+		return newError(req.ID, -32603, "Failed to marshal result")
 	}
 
-	var result strings.Builder
-	result.Grow(len(line))
-	remaining := line
-	searchLen := len(search)
-
-	for {
-		idx := strings.Index(remaining, search)
-		if idx == -1 {
-			result.WriteString(remaining)
-			break
-		}
-
-		beforeOk := idx == 0 || !isWordChar(rune(remaining[idx-1]))
-		afterIdx := idx + searchLen
-		afterOk := afterIdx >= len(remaining) || !isWordChar(rune(remaining[afterIdx]))
-
-		if beforeOk && afterOk {
-			result.WriteString(remaining[:idx])
-			result.WriteString(replace)
-			remaining = remaining[afterIdx:]
-		} else {
-			result.WriteString(remaining[:idx+1])
-			remaining = remaining[idx+1:]
-		}
+	response := ToolCallResult{
+		Content: []ContentItem{
+			{
+				Type: "text",
+				Text: string(jsonResult),
+			},
+		},
 	}
 
-	return result.String()
+	return newResponse(req.ID, response)
 }
 
-func caseInsensitiveWholeWordReplace(line, search, replace string) string {
-	if search == "" {
-		return line
-	}
-
-	var result strings.Builder
-	result.Grow(len(line))
-	remaining := line
-	searchLower := strings.ToLower(search)
-	searchLen := len(search)
-
-	for {
-		lineLower := strings.ToLower(remaining)
-		idx := strings.Index(lineLower, searchLower)
-		if idx == -1 {
-			result.WriteString(remaining)
-			break
-		}
-
-		beforeOk := idx == 0 || !isWordChar(rune(remaining[idx-1]))
-		afterIdx := idx + searchLen
-		afterOk := afterIdx >= len(remaining) || !isWordChar(rune(remaining[afterIdx]))
-
-		if beforeOk && afterOk {
-			result.WriteString(remaining[:idx])
-			result.WriteString(replace)
-			remaining = remaining[afterIdx:]
-		} else {
-			result.WriteString(remaining[:idx+1])
-			remaining = remaining[idx+1:]
-		}
+func newResponse(id any, result any) *JSONRPCResponse {
+	return &JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Result:  result,
 	}
-
-	return result.String()
 }
 
-func countReplacements(line, search string, caseInsensitive, wholeWord bool) int {
-	// Guard against empty string which would cause infinite loop in whole-word mode
-	if search == "" {
-		return 0
-	}
-
-	count := 0
-	lineToCheck := line
-	searchTerm := search
-
-	if caseInsensitive {
-		lineToCheck = strings.ToLower(line)
-		searchTerm = strings.ToLower(search)
-	}
-
-	if !wholeWord {
-		count = strings.Count(lineToCheck, searchTerm)
-		return count
-	}
-
-	startIdx := 0
-	for {
-		idx := strings.Index(lineToCheck[startIdx:], searchTerm)
-		if idx == -1 {
-			break
-		}
-
-		actualIdx := startIdx + idx
-		beforeOk := actualIdx == 0 || !isWordChar(rune(lineToCheck[actualIdx-1]))
-		afterIdx := actualIdx + len(searchTerm)
-		afterOk := afterIdx >= len(lineToCheck) || !isWordChar(rune(lineToCheck[afterIdx]))
-
-		if beforeOk && afterOk {
-			count++
-		}
-
-		startIdx = actualIdx + 1
+func newError(id any, code int, message string) *JSONRPCResponse {
+	return &JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error: &Error{
+			Code:    code,
+			Message: message,
+		},
 	}
-
-	return count
 }
 
-func containsWholeWord(text, word string) bool {
-	// Guard against empty string which would cause infinite loop
-	if word == "" {
-		return false
-	}
-
-	if !strings.Contains(text, word) {
-		return false
-	}
-
-	startIdx := 0
-	for {
-		idx := strings.Index(text[startIdx:], word)
-		if idx == -1 {
-			return false
-		}
+// session holds the state of one connected MCP client. Messages the server
+// initiates (notifications, requests) are delivered through send, which each
+// transport wires to its own output: stdout for stdio, the SSE stream for HTTP.
+type session struct {
+	id string
 
-		actualIdx := startIdx + idx
+	mu  sync.Mutex
+	out func([]byte) error
+	// streaming reports whether a notification sent now reaches the client.
+	// nil means always, as on stdio.
+	streaming func() bool
 
-		beforeOk := actualIdx == 0 || !isWordChar(rune(text[actualIdx-1]))
-		afterIdx := actualIdx + len(word)
-		afterOk := afterIdx >= len(text) || !isWordChar(rune(text[afterIdx]))
+	serverRoots *repfor.Sandbox // from --root, fixed for the server's lifetime
+	runs        *runHistory
 
-		if beforeOk && afterOk {
-			return true
-		}
-
-		startIdx = actualIdx + 1
-	}
-}
-
-func isWordChar(r rune) bool {
-	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_'
+	state           sync.Mutex
+	nextID          int
+	pending         map[string]func(JSONRPCRequest)
+	supportsRoots   bool            // client declared the roots capability
+	rootsReceived   bool            // a roots/list response has arrived, or the fallback applies
+	rootsAsked      time.Time       // when roots were first requested
+	clientRoots     *repfor.Sandbox // nil when the client reported no roots
+	subscriptions   map[string]bool
+	listedResources bool                          // client has called resources/list
+	calls           map[string]context.CancelFunc // tool calls in progress, by request id
 }
 
-func isMultiline(search, replace string) bool {
-	return strings.Contains(search, "\n") || strings.Contains(replace, "\n")
-}
-
-// unescapeString converts literal escape sequences (e.g. backslash followed by 'n')
-// into actual control characters. This is needed because JSON decoding and shell
-// argument passing deliver literal two-character sequences, not real newlines/tabs.
-func unescapeString(s string) string {
-	s = strings.ReplaceAll(s, "\\n", "\n")
-	s = strings.ReplaceAll(s, "\\r", "\r")
-	s = strings.ReplaceAll(s, "\\t", "\t")
-	return s
-}
-
-func countChangedLines(original, modified string) int {
-	origLines := strings.Split(original, "\n")
-	modLines := strings.Split(modified, "\n")
-
-	changed := 0
-	i := 0
-	for i < len(origLines) && i < len(modLines) {
-		if origLines[i] != modLines[i] {
-			changed++
-		}
-		i++
+func newSession(id string, opts serverOptions, out func([]byte) error) *session {
+	return &session{
+		id:            id,
+		out:           out,
+		serverRoots:   opts.roots,
+		runs:          newRunHistory(opts.historySize),
+		pending:       make(map[string]func(JSONRPCRequest)),
+		subscriptions: make(map[string]bool),
+		calls:         make(map[string]context.CancelFunc),
 	}
-	changed += len(origLines) - i
-	changed += len(modLines) - i
-
-	return changed
 }
 
-// replaceContentMultiline performs search/replace on whole-file content, handling all four
-// modes (standard, case-insensitive, whole-word, combined) with exclude support.
-// Returns the modified content, replacement count, and number of original lines affected.
-func replaceContentMultiline(content, search, replace string, caseInsensitive, wholeWord bool, exclude []string) (string, int, int) {
-	if search == "" {
-		return content, 0, 0
-	}
-
-	searchTerm := search
-	contentToSearch := content
-	if caseInsensitive {
-		searchTerm = strings.ToLower(search)
-		contentToSearch = strings.ToLower(content)
-	}
-
-	var result strings.Builder
-	result.Grow(len(content))
-	replacements := 0
-	affectedLines := make(map[int]bool)
-	pos := 0
-
-	for {
-		idx := strings.Index(contentToSearch[pos:], searchTerm)
-		if idx == -1 {
-			result.WriteString(content[pos:])
-			break
-		}
-
-		matchStart := pos + idx
-		matchEnd := matchStart + len(search)
-
-		// Check whole-word boundaries
-		if wholeWord {
-			beforeOk := matchStart == 0 || !isWordChar(rune(content[matchStart-1]))
-			afterOk := matchEnd >= len(content) || !isWordChar(rune(content[matchEnd]))
-			if !beforeOk || !afterOk {
-				result.WriteString(content[pos : matchStart+1])
-				pos = matchStart + 1
-				continue
-			}
-		}
-
-		// Check exclude patterns on the full lines spanning the match
-		if len(exclude) > 0 {
-			excluded := false
-			lineStart := matchStart
-			for lineStart > 0 && content[lineStart-1] != '\n' {
-				lineStart--
-			}
-			lineEnd := matchEnd
-			for lineEnd < len(content) && content[lineEnd] != '\n' {
-				lineEnd++
-			}
-			spanningText := content[lineStart:lineEnd]
-
-			for _, excl := range exclude {
-				exclToCheck := excl
-				textToCheck := spanningText
-				if caseInsensitive {
-					exclToCheck = strings.ToLower(excl)
-					textToCheck = strings.ToLower(spanningText)
-				}
-				if strings.Contains(textToCheck, exclToCheck) {
-					excluded = true
-					break
-				}
-			}
-
-			if excluded {
-				result.WriteString(content[pos:matchEnd])
-				pos = matchEnd
-				continue
-			}
-		}
-
-		// Track affected lines in original content
-		startLine := strings.Count(content[:matchStart], "\n")
-		matchNewlines := strings.Count(content[matchStart:matchEnd], "\n")
-		for l := startLine; l <= startLine+matchNewlines; l++ {
-			affectedLines[l] = true
-		}
-
-		// Perform replacement
-		result.WriteString(content[pos:matchStart])
-		result.WriteString(replace)
-		pos = matchEnd
-		replacements++
-	}
-
-	return result.String(), replacements, len(affectedLines)
+func newStdioSession(w io.Writer, opts serverOptions) *session {
+	return newSession("", opts, func(data []byte) error {
+		_, err := fmt.Fprintln(w, string(data))
+		return err
+	})
 }
 
-// replaceInFileMultiline handles replacement when search or replace contains newlines.
-// Reads the entire file, performs whole-content replacement, and writes back atomically.
-func replaceInFileMultiline(path string, config Config) (int, int, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return 0, 0, err
-	}
-
-	content := string(data)
-
-	// Detect line ending style
-	lineEnding := "\n"
-	if strings.Contains(content, "\r\n") {
-		lineEnding = "\r\n"
-	}
-
-	// Normalize search/replace to match file's line endings
-	search := config.Search
-	replace := config.Replace
-	if lineEnding == "\r\n" {
-		// Normalize any existing \r\n to \n first, then convert all \n to \r\n
-		search = strings.ReplaceAll(strings.ReplaceAll(search, "\r\n", "\n"), "\n", "\r\n")
-		replace = strings.ReplaceAll(strings.ReplaceAll(replace, "\r\n", "\n"), "\n", "\r\n")
-	}
-
-	modified, replacements, linesChanged := replaceContentMultiline(
-		content, search, replace,
-		config.CaseInsensitive, config.WholeWord, config.ExcludeLines,
-	)
+// request sends a server-initiated JSON-RPC request. onResponse runs when the
+// client's matching response is passed to handleResponse.
+func (s *session) request(method string, params any, onResponse func(JSONRPCRequest)) {
+	s.state.Lock()
+	s.nextID++
+	id := fmt.Sprintf("repfor-%d", s.nextID)
+	s.pending[id] = onResponse
+	s.state.Unlock()
 
-	if replacements == 0 {
-		return 0, 0, nil
-	}
-
-	if !config.DryRun {
-		err := writeFileAtomicBytes(path, []byte(modified))
+	msg := JSONRPCRequest{JSONRPC: "2.0", ID: id, Method: method}
+	if params != nil {
+		data, err := json.Marshal(params)
 		if err != nil {
-			return 0, 0, fmt.Errorf("failed to write file: %w", err)
-		}
-		if config.Verbose {
-			fmt.Fprintf(os.Stderr, "Modified: %s (%d replacements in %d lines)\n", path, replacements, linesChanged)
+			fmt.Fprintf(os.Stderr, "Failed to marshal %s params: %v\n", method, err)
+			return
 		}
+		msg.Params = data
 	}
-
-	return linesChanged, replacements, nil
+	s.send(msg)
 }
 
-// writeFileAtomicBytes writes raw bytes to a file atomically using temp file + rename pattern.
-func writeFileAtomicBytes(path string, data []byte) error {
-	resolvedPath, err := filepath.EvalSymlinks(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			resolvedPath = path
-		} else {
-			return fmt.Errorf("failed to resolve path: %w", err)
-		}
-	}
+func (s *session) handleResponse(msg JSONRPCRequest) {
+	key := fmt.Sprint(msg.ID)
 
-	mode := os.FileMode(0644)
-	if info, err := os.Stat(resolvedPath); err == nil {
-		mode = info.Mode()
-		if mode&0200 == 0 {
-			return fmt.Errorf("file is read-only: %s", resolvedPath)
-		}
-	}
+	s.state.Lock()
+	onResponse, ok := s.pending[key]
+	delete(s.pending, key)
+	s.state.Unlock()
 
-	dir := filepath.Dir(resolvedPath)
-	tmpFile, err := os.CreateTemp(dir, ".repfor-*.tmp")
-	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Warning: response for unknown request id %v\n", msg.ID)
+		return
 	}
-	tmpPath := tmpFile.Name()
-
-	success := false
-	defer func() {
-		if !success {
-			os.Remove(tmpPath)
-		}
-	}()
+	onResponse(msg)
+}
 
-	if _, err := tmpFile.Write(data); err != nil {
-		tmpFile.Close()
-		return err
-	}
+func (s *session) clientSupportsRoots() bool {
+	s.state.Lock()
+	defer s.state.Unlock()
+	return s.supportsRoots
+}
 
-	if err := tmpFile.Sync(); err != nil {
-		tmpFile.Close()
-		return fmt.Errorf("failed to sync file: %w", err)
+// trackCall derives the context of a tool call from ctx so that a
+// notifications/cancelled naming id can cancel it. The returned func must be
+// called when the call ends.
+func (s *session) trackCall(ctx context.Context, id any) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	key := fmt.Sprint(id)
+	s.state.Lock()
+	s.calls[key] = cancel
+	s.state.Unlock()
+	return ctx, func() {
+		s.state.Lock()
+		delete(s.calls, key)
+		s.state.Unlock()
+		cancel()
 	}
+}
 
-	if err := tmpFile.Close(); err != nil {
-		return fmt.Errorf("failed to close temp file: %w", err)
+// cancelCall handles notifications/cancelled by cancelling the named tool
+// call. Unknown or finished requests are ignored, as the spec requires.
+func (s *session) cancelCall(params json.RawMessage) {
+	var p struct {
+		RequestID any `json:"requestId"`
 	}
-
-	if err := os.Chmod(tmpPath, mode); err != nil {
-		return fmt.Errorf("failed to set permissions: %w", err)
+	if err := json.Unmarshal(params, &p); err != nil || p.RequestID == nil {
+		return
 	}
-
-	if err := os.Rename(tmpPath, resolvedPath); err != nil {
-		return fmt.Errorf("failed to rename temp file: %w", err)
+	s.state.Lock()
+	cancel, ok := s.calls[fmt.Sprint(p.RequestID)]
+	s.state.Unlock()
+	if ok {
+		cancel()
 	}
-
-	success = true
-	return nil
 }
 
-// writeFileAtomic writes lines to a file atomically using temp file + rename pattern.
-// This prevents data loss if the write fails partway through.
-func writeFileAtomic(path string, lines []string, lineEnding string) error {
-	// Resolve symlinks so we write to the target, not replace the symlink
-	resolvedPath, err := filepath.EvalSymlinks(path)
-	if err != nil {
-		// If file doesn't exist (new file), use original path
-		if os.IsNotExist(err) {
-			resolvedPath = path
-		} else {
-			return fmt.Errorf("failed to resolve path: %w", err)
-		}
-	}
-
-	// Get file info to preserve permissions (use default 0644 if file doesn't exist)
-	mode := os.FileMode(0644)
-	if info, err := os.Stat(resolvedPath); err == nil {
-		mode = info.Mode()
-		// Check if file is writable (owner write bit)
-		if mode&0200 == 0 {
-			return fmt.Errorf("file is read-only: %s", resolvedPath)
-		}
+// notify sends a notification the client has not asked for, such as
+// list_changed. It is dropped when nothing would deliver it now rather than
+// queued for a stream that may never open.
+func (s *session) notify(msg JSONRPCNotification) {
+	if s.streaming != nil && !s.streaming() {
+		return
 	}
+	s.send(msg)
+}
 
-	// Create temp file in same directory (required for atomic rename)
-	dir := filepath.Dir(resolvedPath)
-	tmpFile, err := os.CreateTemp(dir, ".repfor-*.tmp")
+// send marshals msg and delivers it to the client. Safe for concurrent use.
+func (s *session) send(msg any) {
+	data, err := json.Marshal(msg)
 	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
-	}
-	tmpPath := tmpFile.Name()
-
-	// Clean up temp file on any error
-	success := false
-	defer func() {
-		if !success {
-			os.Remove(tmpPath)
-		}
-	}()
-
-	writer := bufio.NewWriter(tmpFile)
-	for i, line := range lines {
-		if i > 0 {
-			if _, err := writer.WriteString(lineEnding); err != nil {
-				tmpFile.Close()
-				return err
-			}
-		}
-		if _, err := writer.WriteString(line); err != nil {
-			tmpFile.Close()
-			return err
-		}
-	}
-
-	if len(lines) > 0 {
-		if _, err := writer.WriteString(lineEnding); err != nil {
-			tmpFile.Close()
-			return err
-		}
-	}
-
-	if err := writer.Flush(); err != nil {
-		tmpFile.Close()
-		return err
-	}
-
-	// Sync to disk before close to ensure data is written
-	if err := tmpFile.Sync(); err != nil {
-		tmpFile.Close()
-		return fmt.Errorf("failed to sync file: %w", err)
-	}
-
-	if err := tmpFile.Close(); err != nil {
-		return fmt.Errorf("failed to close temp file: %w", err)
-	}
-
-	// Preserve original file permissions
-	if err := os.Chmod(tmpPath, mode); err != nil {
-		return fmt.Errorf("failed to set permissions: %w", err)
+		fmt.Fprintf(os.Stderr, "Failed to marshal message: %v\n", err)
+		return
 	}
 
-	// Atomic rename (on POSIX systems)
-	if err := os.Rename(tmpPath, resolvedPath); err != nil {
-		return fmt.Errorf("failed to rename temp file: %w", err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.out(data); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to send message: %v\n", err)
 	}
-
-	success = true
-	return nil
 }
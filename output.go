@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hegner123/repfor/pkg/repfor"
+)
+
+// Output formats for search and replace
+const (
+	outputAuto  = "auto" // text on a terminal, JSON otherwise
+	outputJSON  = "json"
+	outputJSONL = "jsonl" // one event per file as it is done, then a summary
+	outputSARIF = "sarif"
+	outputText  = "text"
+)
+
+// Color modes
+const (
+	colorAuto   = "auto" // on a terminal unless NO_COLOR is set
+	colorAlways = "always"
+	colorNever  = "never"
+)
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiDim    = "\x1b[2m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiBlue   = "\x1b[34m"
+)
+
+// outputFlags selects how a command presents its result.
+type outputFlags struct {
+	format string
+	color  string
+}
+
+// addOutputFlags defines --output, defaulting to format, and --color.
+func addOutputFlags(fs *flag.FlagSet, format string) *outputFlags {
+	o := &outputFlags{}
+	fs.StringVar(&o.format, "output", format, "Result `format`: auto (text on a terminal, else json), json, jsonl, sarif or text")
+	fs.StringVar(&o.color, "color", colorAuto, "Color `mode`: auto (terminal without NO_COLOR), always or never")
+	return o
+}
+
+// resolve returns the format of the result on stdout (never outputAuto) and
+// whether it should be colored.
+func (o *outputFlags) resolve(stdout io.Writer) (format string, color bool, err error) {
+	if format, err = o.resolveFormat(stdout); err != nil {
+		return "", false, err
+	}
+	color, err = o.colored(stdout)
+	return format, color, err
+}
+
+func (o *outputFlags) resolveFormat(stdout io.Writer) (string, error) {
+	switch o.format {
+	case outputAuto:
+		if isTerminal(stdout) {
+			return outputText, nil
+		}
+		return outputJSON, nil
+	case outputJSON, outputJSONL, outputSARIF, outputText:
+		return o.format, nil
+	}
+	return "", fmt.Errorf("invalid --output %q (want auto, json, jsonl, sarif or text)", o.format)
+}
+
+// colored reports whether output written to w should be colored.
+func (o *outputFlags) colored(w io.Writer) (bool, error) {
+	switch o.color {
+	case colorAuto:
+		return isTerminal(w) && os.Getenv("NO_COLOR") == "", nil
+	case colorAlways:
+		return true, nil
+	case colorNever:
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid --color %q (want auto, always or never)", o.color)
+}
+
+// isTerminal reports whether f is an interactive terminal.
+func isTerminal(f any) bool {
+	file, ok := f.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func paint(color bool, code, s string) string {
+	if !color || s == "" {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+// writeResultText renders result for a person: each file with its matched
+// lines, any warnings, then the summary. Without replaced the matches are
+// shown as found (search); otherwise each line is shown before and after.
+func writeResultText(w io.Writer, result *repfor.Result, replaced bool, color bool) error {
+	var b strings.Builder
+	for _, dir := range result.Directories {
+		for _, f := range dir.Files {
+			path := f.Path
+			if dir.Dir != "(files)" && !filepath.IsAbs(path) {
+				path = filepath.Join(dir.Dir, path)
+			}
+			stats := fmt.Sprintf("(%d %s in %d %s)", f.Replacements, plural(f.Replacements, "replacement"), f.LinesChanged, plural(f.LinesChanged, "line"))
+			if !replaced {
+				stats = fmt.Sprintf("(%d %s)", f.Replacements, plural(f.Replacements, "match"))
+			}
+			fmt.Fprintf(&b, "%s %s\n", paint(color, ansiBold, displayPath(path)), paint(color, ansiDim, stats))
+
+			for _, line := range groupByLine(f.Matches) {
+				m := line[0]
+				if !replaced {
+					fmt.Fprintf(&b, "%6d:%-4d %s\n", m.Line, m.Column, highlightLine(m.Text, line, false, ansiRed, color))
+					continue
+				}
+				fmt.Fprintf(&b, "%6d - %s\n", m.Line, highlightLine(m.Text, line, false, ansiRed, color))
+				// A multi-line replacement gets a + line for each of its lines
+				for _, after := range strings.Split(highlightLine(m.Text, line, true, ansiGreen, color), "\n") {
+					fmt.Fprintf(&b, "%6s + %s\n", "", after)
+				}
+			}
+			b.WriteString("\n")
+		}
+	}
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(&b, "%s %s: %s\n", paint(color, ansiYellow, "warning:"), displayPath(warning.Path), warning.Message)
+	}
+	fmt.Fprintln(&b, paint(color, ansiBold, result.Summary))
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// groupByLine groups matches by the line they start on, in line order.
+func groupByLine(matches []repfor.Match) [][]repfor.Match {
+	var groups [][]repfor.Match
+	for _, m := range matches {
+		if n := len(groups); n > 0 && groups[n-1][0].Line == m.Line {
+			groups[n-1] = append(groups[n-1], m)
+			continue
+		}
+		groups = append(groups, []repfor.Match{m})
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i][0].Line < groups[j][0].Line })
+	return groups
+}
+
+// highlightLine paints the matches in line, which all start on it. With
+// replaced set each match is shown as its Replacement instead. A match
+// running past the end of the line is cut off there.
+func highlightLine(line string, matches []repfor.Match, replaced bool, code string, color bool) string {
+	var b strings.Builder
+	prev := 0
+	for _, m := range matches {
+		off := columnOffset(line, m.Column)
+		if off < prev {
+			continue
+		}
+		end := min(off+len(m.Match), len(line))
+		text := line[off:end]
+		if replaced {
+			text = m.Replacement
+		}
+		b.WriteString(line[prev:off])
+		// Painted line by line, so that a line break never falls inside the color
+		lines := strings.Split(strings.ReplaceAll(text, "\r", ""), "\n")
+		for i, l := range lines {
+			lines[i] = paint(color, code, l)
+		}
+		b.WriteString(strings.Join(lines, "\n"))
+		prev = end
+	}
+	b.WriteString(line[prev:])
+	return b.String()
+}
+
+// columnOffset returns the byte offset of the 1-based character column in line.
+func columnOffset(line string, column int) int {
+	off := 0
+	for i := 1; i < column && off < len(line); i++ {
+		_, size := utf8.DecodeRuneInString(line[off:])
+		off += size
+	}
+	return off
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return word
+	}
+	if strings.HasSuffix(word, "ch") {
+		return word + "es"
+	}
+	return word + "s"
+}
+
+// writeCheckReport renders the result of a check as one compiler-style
+// path:line:column line per match, any warnings, then the summary in red if
+// the check failed or green if it passed.
+func writeCheckReport(w io.Writer, result *repfor.Result, failed, color bool) error {
+	var b strings.Builder
+	for _, dir := range result.Directories {
+		for _, f := range dir.Files {
+			path := f.Path
+			if dir.Dir != "(files)" && !filepath.IsAbs(path) {
+				path = filepath.Join(dir.Dir, path)
+			}
+			for _, m := range f.Matches {
+				loc := fmt.Sprintf("%s:%d:%d:", displayPath(path), m.Line, m.Column)
+				fmt.Fprintf(&b, "%s %s\n", paint(color, ansiBold, loc), highlightLine(m.Text, []repfor.Match{m}, false, ansiRed, color))
+			}
+		}
+	}
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(&b, "%s %s: %s\n", paint(color, ansiYellow, "warning:"), displayPath(warning.Path), warning.Message)
+	}
+	verdict := ansiGreen
+	if failed {
+		verdict = ansiRed
+	}
+	fmt.Fprintln(&b, paint(color, verdict+ansiBold, result.Summary))
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// eventStream writes a run as JSON Lines: one repfor.FileEvent per file as it
+// is done, then a summaryEvent. The first write error is kept and later
+// events are dropped.
+type eventStream struct {
+	w   io.Writer
+	err error
+}
+
+// summaryEvent ends an event stream with the totals of the run.
+type summaryEvent struct {
+	Type              string `json:"type"` // always "summary"
+	Summary           string `json:"summary"`
+	FilesModified     int    `json:"files_modified"`
+	LinesChanged      int    `json:"lines_changed"`
+	TotalReplacements int    `json:"total_replacements"`
+	Errors            int    `json:"errors"`
+	DryRun            bool   `json:"dry_run,omitempty"`
+	Truncated         bool   `json:"truncated,omitempty"`
+}
+
+func (s *eventStream) write(v any) {
+	if s.err != nil {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err == nil {
+		_, err = fmt.Fprintf(s.w, "%s\n", data)
+	}
+	s.err = err
+}
+
+// finish writes the summary of result and returns the first write error.
+func (s *eventStream) finish(result *repfor.Result) error {
+	e := summaryEvent{Type: "summary", Summary: result.Summary, Errors: len(result.Warnings), DryRun: result.DryRun, Truncated: result.Truncated}
+	for _, dir := range result.Directories {
+		e.FilesModified += dir.FilesModified
+		e.LinesChanged += dir.LinesChanged
+		e.TotalReplacements += dir.TotalReplacements
+	}
+	s.write(e)
+	return s.err
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hegner123/repfor/pkg/repfor"
+)
+
+func TestWriteResultText(t *testing.T) {
+	result := &repfor.Result{
+		Summary: "Modified 1 file: 4 replacements in 3 lines",
+		Directories: []repfor.DirectoryResult{{
+			Dir: "src",
+			Files: []repfor.FileModification{{
+				Path:         "a.go",
+				LinesChanged: 3,
+				Replacements: 4,
+				Matches: []repfor.Match{
+					{Line: 1, Column: 1, Match: "foo", Text: "foo(foo)", Replacement: "bar"},
+					{Line: 1, Column: 5, Match: "foo", Text: "foo(foo)", Replacement: "bar"},
+					// Edited when confirmed
+					{Line: 4, Column: 3, Match: "foo", Text: "é foo", Replacement: "qux"},
+					// Reindented
+					{Line: 6, Column: 2, Match: "foo", Text: "\tfoo", Replacement: "if x {\n\t\tfoo\n\t}"},
+				},
+			}},
+		}},
+		Warnings: []repfor.Warning{{Path: "src/b.go", Message: "failed to process: denied"}},
+	}
+
+	var b strings.Builder
+	if err := writeResultText(&b, result, true, false); err != nil {
+		t.Fatal(err)
+	}
+	want := "src/a.go (4 replacements in 3 lines)\n" +
+		"     1 - foo(foo)\n" +
+		"       + bar(bar)\n" +
+		"     4 - é foo\n" +
+		"       + é qux\n" +
+		"     6 - \tfoo\n" +
+		"       + \tif x {\n" +
+		"       + \t\tfoo\n" +
+		"       + \t}\n" +
+		"\n" +
+		"warning: src/b.go: failed to process: denied\n" +
+		"Modified 1 file: 4 replacements in 3 lines\n"
+	if b.String() != want {
+		t.Errorf("text output:\n%s\nwant:\n%s", b.String(), want)
+	}
+
+	b.Reset()
+	if err := writeResultText(&b, result, false, true); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "     1:1    "+ansiRed+"foo"+ansiReset+"("+ansiRed+"foo"+ansiReset+")\n") {
+		t.Errorf("search output missing highlighted match:\n%q", b.String())
+	}
+}
+
+func TestCLI_OutputFlags(t *testing.T) {
+	t.Setenv(stateDirEnv, t.TempDir())
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	createTestFile(t, tmpDir, "a.txt", "foo\n")
+
+	// Output that isn't a terminal defaults to JSON without color
+	_, stdout, _ := runCLI(t, "search", "--dir", tmpDir, "--search", "foo", "--color", "always")
+	if !strings.HasPrefix(stdout, "{") {
+		t.Errorf("default output when piped should be JSON: %q", stdout)
+	}
+
+	_, stdout, _ = runCLI(t, "search", "--dir", tmpDir, "--search", "foo", "--output", "text")
+	if strings.Contains(stdout, "\x1b[") || !strings.Contains(stdout, "Found 1 match in 1 file\n") {
+		t.Errorf("text output when piped should be uncolored: %q", stdout)
+	}
+
+	_, stdout, _ = runCLI(t, "replace", "--dir", tmpDir, "--search", "foo", "--replace", "bar", "--dry-run", "--output", "text", "--color", "always")
+	if !strings.Contains(stdout, "+ "+ansiGreen+"bar"+ansiReset) {
+		t.Errorf("--color always should color text output: %q", stdout)
+	}
+
+	for _, args := range [][]string{{"--output", "yaml"}, {"--color", "sometimes"}} {
+		code, _, stderr := runCLI(t, append([]string{"search", "--dir", tmpDir, "--search", "foo"}, args...)...)
+		if code != ExitError || !strings.Contains(stderr, "invalid") {
+			t.Errorf("%v: code %d, stderr %q", args, code, stderr)
+		}
+	}
+}
+
+func TestCLI_OutputJSONL(t *testing.T) {
+	t.Setenv(stateDirEnv, t.TempDir())
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	createTestFile(t, tmpDir, "a.txt", "foo foo\n")
+	createTestFile(t, tmpDir, "b.txt", "bar\n")
+
+	decode := func(stdout string) []map[string]any {
+		t.Helper()
+		var events []map[string]any
+		for _, line := range strings.Split(strings.TrimSuffix(stdout, "\n"), "\n") {
+			var e map[string]any
+			if err := json.Unmarshal([]byte(line), &e); err != nil {
+				t.Fatalf("invalid event %q: %v", line, err)
+			}
+			events = append(events, e)
+		}
+		return events
+	}
+
+	code, stdout, _ := runCLI(t, "replace", "--dir", tmpDir, "--search", "foo", "--replace", "baz", "--output", "jsonl")
+	if code != ExitSuccess {
+		t.Fatalf("exit code %d", code)
+	}
+	events := decode(stdout)
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %q", stdout)
+	}
+	if events[0]["type"] != "modified" || events[0]["replacements"] != 2.0 || !strings.HasSuffix(events[0]["path"].(string), "a.txt") {
+		t.Errorf("first event = %v", events[0])
+	}
+	if events[1]["type"] != "skipped" {
+		t.Errorf("second event = %v", events[1])
+	}
+	if events[2]["type"] != "summary" || events[2]["total_replacements"] != 2.0 || events[2]["summary"] != "Modified 1 file: 2 replacements in 1 line" {
+		t.Errorf("summary event = %v", events[2])
+	}
+
+	_, stdout, _ = runCLI(t, "search", "--dir", tmpDir, "--search", "baz", "--output", "jsonl")
+	events = decode(stdout)
+	if events[0]["type"] != "matched" || len(events[0]["matches"].([]any)) != 2 {
+		t.Errorf("search event = %v", events[0])
+	}
+	if last := events[len(events)-1]; last["summary"] != "Found 2 matches in 1 file" {
+		t.Errorf("search summary event = %v", last)
+	}
+}
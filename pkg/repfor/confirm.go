@@ -0,0 +1,116 @@
+package repfor
+
+import "strings"
+
+// Proposal is one match offered to Options.Confirm before it is replaced.
+type Proposal struct {
+	Path        string // "-" for Engine.Filter
+	Match       Match
+	Replacement string
+}
+
+// chooseLine replaces the occurrences in line that are in scope, that lim
+// allows and, when set, that config.Confirm approves. inScope is given the
+// byte range of a match in line. It returns the new line with the spans of
+// the replaced matches.
+func chooseLine(path string, lineNum int, line string, config Options, lim *limiter, inScope func(s span) bool) (string, []span) {
+	var b strings.Builder
+	var approved []span
+	prev := 0
+	for _, s := range matchSpans(line, config.Search, config.CaseInsensitive, config.WholeWord) {
+		if !inScope(s) || !lim.allow() {
+			continue
+		}
+		replacement := config.Replace
+		if config.Confirm != nil {
+			var ok bool
+			if replacement, ok = config.Confirm(Proposal{Path: path, Match: newMatch(lineNum, line, s.start, s.end-s.start), Replacement: config.Replace}); !ok {
+				continue
+			}
+		}
+		lim.replaced()
+		b.WriteString(line[prev:s.start])
+		b.WriteString(replacement)
+		prev = s.end
+		s.text = replacement
+		approved = append(approved, s)
+	}
+	b.WriteString(line[prev:])
+	return b.String(), approved
+}
+
+// span is the byte range [start, end) of a match in content, with the text
+// that replaces it once chosen.
+type span struct {
+	start, end int
+	text       string
+}
+
+// chooseContent rebuilds content replacing only the matches at spans that
+// are in scope, that lim allows and, when set, that config.Confirm approves,
+// each with the text replace returns for it. It returns the new content, the
+// number of replacements and original lines affected, and the spans
+// replaced.
+func chooseContent(path, content string, spans []span, replace func(s span) string, inScope func(s span) bool, config Options, lim *limiter) (string, int, int, []span) {
+	return replaceAt(content, spans, func(s span) (string, bool) {
+		if !inScope(s) {
+			return "", false
+		}
+		if !lim.allow() {
+			return "", false
+		}
+		replacement := replace(s)
+		if config.Confirm != nil {
+			var ok bool
+			if replacement, ok = config.Confirm(Proposal{Path: path, Match: contentMatch(content, s.start, s.end-s.start), Replacement: replacement}); !ok {
+				return "", false
+			}
+		}
+		lim.replaced()
+		return replacement, true
+	})
+}
+
+// replaceAt rebuilds content replacing the matches at spans for which
+// choose returns true, with the text it returns. It returns the new content,
+// the number of replacements and original lines affected, and the spans
+// replaced.
+func replaceAt(content string, spans []span, choose func(s span) (string, bool)) (string, int, int, []span) {
+	var b strings.Builder
+	var approved []span
+	affectedLines := make(map[int]bool)
+	prev := 0
+	for _, s := range spans {
+		replacement, ok := choose(s)
+		if !ok {
+			continue
+		}
+		startLine := strings.Count(content[:s.start], "\n")
+		for l := startLine; l <= startLine+strings.Count(content[s.start:s.end], "\n"); l++ {
+			affectedLines[l] = true
+		}
+		b.WriteString(content[prev:s.start])
+		b.WriteString(replacement)
+		prev = s.end
+		s.text = replacement
+		approved = append(approved, s)
+	}
+	b.WriteString(content[prev:])
+	return b.String(), len(approved), len(affectedLines), approved
+}
+
+// contentMatch builds the Match for the n bytes at byte offset start of
+// content, reporting the line the match starts on.
+func contentMatch(content string, start, n int) Match {
+	lineStart := strings.LastIndexByte(content[:start], '\n') + 1
+	lineEnd := strings.IndexByte(content[start:], '\n')
+	if lineEnd == -1 {
+		lineEnd = len(content)
+	} else {
+		lineEnd += start
+	}
+	line := strings.TrimSuffix(content[lineStart:lineEnd], "\r")
+	m := newMatch(strings.Count(content[:lineStart], "\n")+1, line, start-lineStart, n)
+	m.Match = content[start : start+n]
+	return m
+}
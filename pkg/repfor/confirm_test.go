@@ -0,0 +1,84 @@
+package repfor
+
+import (
+	"context"
+	"testing"
+)
+
+func TestConfirm_SelectsMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		search  string
+		replace string
+		content string
+		want    string
+		lines   int
+		line3   int // line of the third match
+	}{
+		{"line based", "foo", "bar", "foo foo\nfoo\nfoo\n", "bar foo\nEDIT\nfoo\n", 2, 2},
+		{"multiline", "foo\nx", "bar", "foo\nx\nfoo\nx\nfoo\nx\nfoo\nx\n", "bar\nfoo\nx\nEDIT\nfoo\nx\n", 4, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := setupTestDir(t)
+			defer cleanupTestDir(t, tmpDir)
+			path := createTestFile(t, tmpDir, "a.txt", tt.content)
+
+			// Approve the first match, decline the second, edit the third, decline the rest
+			var asked []Proposal
+			confirm := func(p Proposal) (string, bool) {
+				asked = append(asked, p)
+				switch len(asked) {
+				case 1:
+					return p.Replacement, true
+				case 3:
+					return "EDIT", true
+				}
+				return "", false
+			}
+
+			result, err := New(Options{Files: []string{path}, Search: tt.search, Replace: tt.replace, Confirm: confirm, CollectMatches: true}).Run(context.Background())
+			if err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			if got := readFileContent(t, path); got != tt.want {
+				t.Errorf("content = %q, want %q", got, tt.want)
+			}
+			if len(asked) != 4 {
+				t.Fatalf("asked about %d matches, want 4", len(asked))
+			}
+			if asked[2].Match.Line != tt.line3 {
+				t.Errorf("third proposal at line %d, want %d", asked[2].Match.Line, tt.line3)
+			}
+			dir := result.Directories[0]
+			if dir.TotalReplacements != 2 || dir.LinesChanged != tt.lines {
+				t.Errorf("replacements = %d, lines = %d; want 2, %d", dir.TotalReplacements, dir.LinesChanged, tt.lines)
+			}
+			// Matches record what was inserted, including the edit
+			matches := dir.Files[0].Matches
+			if len(matches) != 2 || matches[0].Replacement != tt.replace || matches[1].Replacement != "EDIT" {
+				t.Errorf("matches = %+v, want replacements %q and EDIT", matches, tt.replace)
+			}
+		})
+	}
+}
+
+func TestConfirm_AllDeclinedLeavesFile(t *testing.T) {
+	mem := newMemTree(t, map[string]string{"a.txt": "foo\n"})
+	result, err := New(Options{
+		Files:   []string{"a.txt"},
+		Search:  "foo",
+		Replace: "bar",
+		FS:      mem,
+		Confirm: func(Proposal) (string, bool) { return "", false },
+	}).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if result.Directories[0].FilesModified != 0 {
+		t.Errorf("declined file reported as modified: %+v", result.Directories[0])
+	}
+	if data, _ := mem.ReadFile("a.txt"); string(data) != "foo\n" {
+		t.Errorf("content = %q", data)
+	}
+}
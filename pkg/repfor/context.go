@@ -0,0 +1,37 @@
+package repfor
+
+import "errors"
+
+// hasContextConditions reports whether matches must be near given lines.
+func (o Options) hasContextConditions() bool {
+	return o.ContextBefore != "" || o.ContextAfter != ""
+}
+
+// validateContext checks the context options.
+func (o Options) validateContext() error {
+	if o.ContextWindow < 0 {
+		return errors.New("context window must not be negative")
+	}
+	return nil
+}
+
+// hasContext reports whether the match spanning lines first through last
+// (0-based indexes into lines) meets the context conditions: a line among
+// the ContextWindow lines above first contains ContextBefore, and one among
+// those below last contains ContextAfter. The window defaults to one line.
+func (o Options) hasContext(lines []string, first, last int) bool {
+	window := max(o.ContextWindow, 1)
+	return (o.ContextBefore == "" || o.anyContains(lines[max(first-window, 0):first], o.ContextBefore)) &&
+		(o.ContextAfter == "" || o.anyContains(lines[min(last+1, len(lines)):min(last+1+window, len(lines))], o.ContextAfter))
+}
+
+// anyContains reports whether one of lines contains s, ignoring case with
+// CaseInsensitive.
+func (o Options) anyContains(lines []string, s string) bool {
+	for _, line := range lines {
+		if containsMatch(line, s, o.CaseInsensitive) {
+			return true
+		}
+	}
+	return false
+}
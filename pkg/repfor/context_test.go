@@ -0,0 +1,59 @@
+package repfor
+
+import (
+	"context"
+	"testing"
+)
+
+const contextContent = "service: api\n  port: 80\n  timeout: 30\nservice: web\n  timeout: 30\n"
+
+func TestContext(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"before, default window", Options{ContextBefore: "service: api"}, contextContent},
+		{"before, wider window", Options{ContextBefore: "service: api", ContextWindow: 2}, "service: api\n  port: 80\n  timeout: 60\nservice: web\n  timeout: 30\n"},
+		{"before, case-insensitive", Options{ContextBefore: "SERVICE: WEB", CaseInsensitive: true}, "service: api\n  port: 80\n  timeout: 30\nservice: web\n  timeout: 60\n"},
+		{"after", Options{ContextAfter: "service: web"}, "service: api\n  port: 80\n  timeout: 60\nservice: web\n  timeout: 30\n"},
+		{"both", Options{ContextBefore: "port", ContextAfter: "web"}, "service: api\n  port: 80\n  timeout: 60\nservice: web\n  timeout: 30\n"},
+		{"both unmet", Options{ContextBefore: "port", ContextAfter: "db"}, contextContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := setupTestDir(t)
+			defer cleanupTestDir(t, tmpDir)
+			path := createTestFile(t, tmpDir, "a.yaml", contextContent)
+			opts := tt.opts
+			opts.Files = []string{path}
+			opts.Search, opts.Replace = "timeout: 30", "timeout: 60"
+
+			if _, err := New(opts).Run(context.Background()); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			if got := readFileContent(t, path); got != tt.want {
+				t.Errorf("content = %q\nwant      %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContext_Multiline(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.yaml", "# api\na\nb\n# end\na\nb\n")
+
+	// The window runs above the first line and below the last line of a match
+	opts := Options{Files: []string{path}, Search: "a\nb", Replace: "c", ContextBefore: "api", ContextAfter: "end"}
+	if _, err := New(opts).Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got := readFileContent(t, path); got != "# api\nc\n# end\na\nb\n" {
+		t.Errorf("content = %q", got)
+	}
+
+	if _, err := New(Options{Files: []string{path}, Search: "a", ContextAfter: "x", ContextWindow: -1}).Run(context.Background()); err == nil {
+		t.Error("a negative window should fail")
+	}
+}
@@ -0,0 +1,290 @@
+package repfor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Unified diff generation.
+//
+// Lines are compared including their terminators, so a missing final newline
+// shows up as a change and is marked "\ No newline at end of file" like diff(1)
+// and git do. The line matching uses lines that are unique in both inputs as
+// anchors (as in patience diff), which keeps large files with scattered edits
+// cheap, and runs Myers' algorithm on the small gaps between anchors.
+
+const (
+	diffContext = 3
+	// maxMyersGap bounds the gap size handed to Myers; larger gaps are emitted
+	// as a block delete + insert rather than risking quadratic memory.
+	maxMyersGap = 4000
+)
+
+type diffOp struct {
+	kind byte // ' ' equal, '-' delete, '+' insert
+	line string
+}
+
+// splitLines splits s into lines, each keeping its trailing "\n" (the last
+// line may lack one).
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// UnifiedDiff returns a unified diff turning before into after, with
+// oldName/newName in the ---/+++ headers. It returns "" when they are equal.
+func UnifiedDiff(oldName, newName string, before, after []byte) string {
+	if string(before) == string(after) {
+		return ""
+	}
+
+	ops := diffLines(splitLines(string(before)), splitLines(string(after)))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+	writeHunks(&b, ops)
+	return b.String()
+}
+
+func writeHunks(b *strings.Builder, ops []diffOp) {
+	// Line numbers (0-based) in a and b at the start of each op
+	aLine := make([]int, len(ops)+1)
+	bLine := make([]int, len(ops)+1)
+	for i, op := range ops {
+		aLine[i+1], bLine[i+1] = aLine[i], bLine[i]
+		if op.kind != '+' {
+			aLine[i+1]++
+		}
+		if op.kind != '-' {
+			bLine[i+1]++
+		}
+	}
+
+	i := 0
+	for i < len(ops) {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		// Extend the hunk while the next change is within 2*context lines
+		start := max(0, i-diffContext)
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j + 1
+				continue
+			}
+			if j-end >= 2*diffContext {
+				break
+			}
+		}
+		end = min(len(ops), end+diffContext)
+
+		oldCount := aLine[end] - aLine[start]
+		newCount := bLine[end] - bLine[start]
+		fmt.Fprintf(b, "@@ -%s +%s @@\n", hunkRange(aLine[start], oldCount), hunkRange(bLine[start], newCount))
+		for _, op := range ops[start:end] {
+			b.WriteByte(op.kind)
+			b.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+}
+
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, count)
+	}
+}
+
+// diffLines returns an edit script turning a into b.
+func diffLines(a, b []string) []diffOp {
+	ops := make([]diffOp, 0, max(len(a), len(b)))
+
+	// Common prefix and suffix never need matching
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+
+	midA := a[prefix : len(a)-suffix]
+	midB := b[prefix : len(b)-suffix]
+	ai, bi := 0, 0
+	for _, anchor := range uniqueAnchors(midA, midB) {
+		ops = diffGap(ops, midA[ai:anchor[0]], midB[bi:anchor[1]])
+		ops = append(ops, diffOp{' ', midA[anchor[0]]})
+		ai, bi = anchor[0]+1, anchor[1]+1
+	}
+	ops = diffGap(ops, midA[ai:], midB[bi:])
+
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// uniqueAnchors pairs up lines occurring exactly once in both a and b and
+// returns the longest sequence of such pairs that is increasing in both.
+func uniqueAnchors(a, b []string) [][2]int {
+	type counts struct{ a, b, aIdx, bIdx int }
+	seen := make(map[string]*counts)
+	for i, line := range a {
+		c := seen[line]
+		if c == nil {
+			c = &counts{}
+			seen[line] = c
+		}
+		c.a++
+		c.aIdx = i
+	}
+	for j, line := range b {
+		if c := seen[line]; c != nil {
+			c.b++
+			c.bIdx = j
+		}
+	}
+
+	var pairs [][2]int
+	for _, c := range seen {
+		if c.a == 1 && c.b == 1 {
+			pairs = append(pairs, [2]int{c.aIdx, c.bIdx})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i][0] < pairs[j][0] })
+
+	// Longest increasing subsequence on b index (patience sorting)
+	var tails []int
+	prev := make([]int, len(pairs))
+	for i, p := range pairs {
+		k := sort.Search(len(tails), func(t int) bool { return pairs[tails[t]][1] >= p[1] })
+		if k > 0 {
+			prev[i] = tails[k-1]
+		} else {
+			prev[i] = -1
+		}
+		if k == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[k] = i
+		}
+	}
+
+	anchors := make([][2]int, len(tails))
+	if len(tails) > 0 {
+		for i, k := len(tails)-1, tails[len(tails)-1]; i >= 0; i, k = i-1, prev[k] {
+			anchors[i] = pairs[k]
+		}
+	}
+	return anchors
+}
+
+// diffGap appends the edit script for a region between anchors.
+func diffGap(ops []diffOp, a, b []string) []diffOp {
+	if len(a)+len(b) > maxMyersGap {
+		for _, line := range a {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range b {
+			ops = append(ops, diffOp{'+', line})
+		}
+		return ops
+	}
+	return append(ops, myers(a, b)...)
+}
+
+// myers computes a minimal edit script with Myers' O(ND) algorithm.
+func myers(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	if n == 0 && m == 0 {
+		return nil
+	}
+	offset := n + m
+	v := make([]int, 2*offset+2)
+	var trace [][]int
+
+	for d := 0; d <= n+m; d++ {
+		// Only diagonals -d..d matter, so save just that window
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return myersBacktrack(a, b, trace)
+			}
+		}
+	}
+	return nil // unreachable
+}
+
+func myersBacktrack(a, b []string, trace [][]int) []diffOp {
+	var rev []diffOp
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d >= 0; d-- {
+		saved := trace[d]
+		at := func(k int) int { return saved[k+d] } // saved window starts at diagonal -d
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			rev = append(rev, diffOp{' ', a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				rev = append(rev, diffOp{'+', b[y-1]})
+			} else {
+				rev = append(rev, diffOp{'-', a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	ops := make([]diffOp, len(rev))
+	for i, op := range rev {
+		ops[len(rev)-1-i] = op
+	}
+	return ops
+}
@@ -0,0 +1,130 @@
+package repfor
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		before   string
+		after    string
+		expected string
+	}{
+		{
+			name:     "identical",
+			before:   "a\nb\n",
+			after:    "a\nb\n",
+			expected: "",
+		},
+		{
+			name:   "single line change",
+			before: "a\nb\nc\n",
+			after:  "a\nB\nc\n",
+			expected: "--- f\n+++ f\n" +
+				"@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			name:   "insertion into empty file",
+			before: "",
+			after:  "x\ny\n",
+			expected: "--- f\n+++ f\n" +
+				"@@ -0,0 +1,2 @@\n+x\n+y\n",
+		},
+		{
+			name:   "missing final newline",
+			before: "a\nb",
+			after:  "a\nc",
+			expected: "--- f\n+++ f\n" +
+				"@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n",
+		},
+		{
+			name:   "distant changes produce separate hunks",
+			before: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			after:  "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n",
+			expected: "--- f\n+++ f\n" +
+				"@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n" +
+				"@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n",
+		},
+		{
+			name:   "nearby changes merge into one hunk",
+			before: "1\n2\n3\n4\n5\n6\n7\n",
+			after:  "one\n2\n3\n4\n5\n6\nseven\n",
+			expected: "--- f\n+++ f\n" +
+				"@@ -1,7 +1,7 @@\n-1\n+one\n 2\n 3\n 4\n 5\n 6\n-7\n+seven\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := UnifiedDiff("f", "f", []byte(tt.before), []byte(tt.after))
+			if got != tt.expected {
+				t.Errorf("UnifiedDiff mismatch\ngot:\n%s\nwant:\n%s", got, tt.expected)
+			}
+		})
+	}
+}
+
+// applyOps rebuilds both sides of an edit script so it can be checked
+// against the inputs it was computed from.
+func applyOps(ops []diffOp) (string, string) {
+	var a, b strings.Builder
+	for _, op := range ops {
+		if op.kind != '+' {
+			a.WriteString(op.line)
+		}
+		if op.kind != '-' {
+			b.WriteString(op.line)
+		}
+	}
+	return a.String(), b.String()
+}
+
+func TestDiffLines_RandomInputs(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	vocab := []string{"a\n", "b\n", "}\n", "\n", "func x() {\n", "return nil\n"}
+
+	for i := 0; i < 500; i++ {
+		var before, after []string
+		for j := 0; j < rng.Intn(40); j++ {
+			line := vocab[rng.Intn(len(vocab))]
+			if rng.Intn(2) == 0 {
+				line = strings.Repeat("u", j+1) + "\n" // unique line, becomes an anchor
+			}
+			before = append(before, line)
+			switch rng.Intn(5) {
+			case 0: // delete
+			case 1:
+				after = append(after, "inserted\n", line)
+			case 2:
+				after = append(after, "changed "+line)
+			default:
+				after = append(after, line)
+			}
+		}
+
+		ops := diffLines(before, after)
+		gotA, gotB := applyOps(ops)
+		if gotA != strings.Join(before, "") || gotB != strings.Join(after, "") {
+			t.Fatalf("edit script does not reproduce inputs\nbefore: %q\nafter: %q", before, after)
+		}
+	}
+}
+
+func TestMyers_Minimal(t *testing.T) {
+	a := splitLines("a\nb\nc\na\nb\nb\na\n")
+	b := splitLines("c\nb\na\nb\na\nc\n")
+
+	edits := 0
+	for _, op := range myers(a, b) {
+		if op.kind != ' ' {
+			edits++
+		}
+	}
+	// The classic example from Myers' paper has an edit distance of 5
+	if edits != 5 {
+		t.Errorf("myers edit count = %d, want 5", edits)
+	}
+}
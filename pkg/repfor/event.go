@@ -0,0 +1,46 @@
+package repfor
+
+// Kinds of FileEvent
+const (
+	EventModified = "modified" // the file had matches and was (or would be) changed
+	EventSkipped  = "skipped"  // the file was read and had no matches
+	EventError    = "error"    // the file could not be read or processed
+)
+
+// FileEvent is the outcome of processing one file, reported through
+// Options.OnFile as soon as the file is done.
+type FileEvent struct {
+	Type         string  `json:"type"`
+	Path         string  `json:"path"`
+	LinesChanged int     `json:"lines_changed,omitempty"`
+	Replacements int     `json:"replacements,omitempty"`
+	Matches      []Match `json:"matches,omitempty"`
+	Truncated    bool    `json:"truncated,omitempty"`
+	Error        string  `json:"error,omitempty"`
+}
+
+// report passes the outcome of processing path to OnFile, if set.
+func (o Options) report(path string, mod *FileModification) {
+	if o.OnFile == nil {
+		return
+	}
+	if mod == nil {
+		o.OnFile(FileEvent{Type: EventSkipped, Path: path})
+		return
+	}
+	o.OnFile(FileEvent{
+		Type:         EventModified,
+		Path:         path,
+		LinesChanged: mod.LinesChanged,
+		Replacements: mod.Replacements,
+		Matches:      mod.Matches,
+		Truncated:    mod.Truncated,
+	})
+}
+
+// reportWarning passes a path the run had to skip to OnFile, if set.
+func (o Options) reportWarning(w Warning) {
+	if o.OnFile != nil {
+		o.OnFile(FileEvent{Type: EventError, Path: w.Path, Error: w.Message})
+	}
+}
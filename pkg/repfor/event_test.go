@@ -0,0 +1,40 @@
+package repfor
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestOnFile_Events(t *testing.T) {
+	mem := newMemTree(t, map[string]string{
+		"d/a.txt":     "foo foo\n",
+		"d/b.txt":     "bar\n",
+		"d/c.txt":     "foo\n",
+		"d/sub/d.txt": "foo\n",
+	})
+	if err := mem.Chmod("d/c.txt", 0200); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []FileEvent
+	result, err := New(Options{
+		Dirs:    []string{"d"},
+		Search:  "foo",
+		Replace: "baz",
+		FS:      mem,
+		OnFile:  func(e FileEvent) { events = append(events, e) },
+	}).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	want := []FileEvent{
+		{Type: EventModified, Path: "d/a.txt", LinesChanged: 1, Replacements: 2},
+		{Type: EventSkipped, Path: "d/b.txt"},
+		{Type: EventError, Path: "d/c.txt", Error: result.Warnings[0].Message},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %+v\nwant %+v", events, want)
+	}
+}
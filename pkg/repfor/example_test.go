@@ -0,0 +1,36 @@
+package repfor_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hegner123/repfor/pkg/repfor"
+)
+
+func Example() {
+	dir, _ := os.MkdirTemp("", "repfor-example-*")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "main.go")
+	os.WriteFile(path, []byte("func oldName() {}\n"), 0644)
+
+	engine := repfor.New(repfor.Options{
+		Files:     []string{path},
+		Search:    "oldName",
+		Replace:   "newName",
+		WholeWord: true,
+	})
+	result, err := engine.Run(context.Background())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	content, _ := os.ReadFile(path)
+	fmt.Println(result.Summary)
+	fmt.Print(string(content))
+	// Output:
+	// Modified 1 file: 1 replacement in 1 line
+	// func newName() {}
+}
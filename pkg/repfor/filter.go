@@ -0,0 +1,82 @@
+package repfor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Filter performs the replacement on the content read from r and writes the
+// result to w, which receives the input unchanged when nothing matches. It
+// applies Search, Replace, ExcludeLines, IncludeLines, CaseInsensitive,
+// WholeWord, the scoping, context and limit options, CollectMatches and
+// Confirm; the file selection options, DryRun, OnChange and OnFile are
+// ignored. Line endings are preserved, including a missing final newline.
+//
+// The result has one directory, "(stdin)", holding the input as file "-".
+func (e *Engine) Filter(r io.Reader, w io.Writer) (*Result, error) {
+	if e.opts.Search == "" {
+		return nil, errors.New("search string is required")
+	}
+	if err := e.opts.validateScope(); err != nil {
+		return nil, err
+	}
+	if err := e.opts.validateLimits(); err != nil {
+		return nil, err
+	}
+	if err := e.opts.validateContext(); err != nil {
+		return nil, err
+	}
+	if err := e.opts.validateWhitespace(); err != nil {
+		return nil, err
+	}
+	opts := e.opts
+	var err error
+	if opts.include, err = newIncludeFilter(opts); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+
+	after, mod := data, (*FileModification)(nil)
+	if e.opts.Search != e.opts.Replace {
+		if after, mod, err = replaceContent("-", data, opts); err != nil {
+			return nil, err
+		}
+	}
+	// The line-based path terminates every line; keep an unterminated last one
+	if mod != nil && !opts.wholeContent() && !bytes.HasSuffix(data, []byte("\n")) {
+		after = bytes.TrimSuffix(bytes.TrimSuffix(after, []byte("\n")), []byte("\r"))
+	}
+
+	if _, err := w.Write(after); err != nil {
+		return nil, fmt.Errorf("failed to write output: %w", err)
+	}
+
+	dir := DirectoryResult{Dir: "(stdin)", Files: make([]FileModification, 0, 1)}
+	if mod != nil {
+		mod.Path = "-"
+		dir.add(*mod)
+	}
+
+	replacementWord := "replacements"
+	if dir.TotalReplacements == 1 {
+		replacementWord = "replacement"
+	}
+	lineWord := "lines"
+	if dir.LinesChanged == 1 {
+		lineWord = "line"
+	}
+	result := &Result{
+		Summary:     fmt.Sprintf("Filtered input: %d %s in %d %s", dir.TotalReplacements, replacementWord, dir.LinesChanged, lineWord),
+		Directories: []DirectoryResult{dir},
+	}
+	if mod != nil && mod.Truncated {
+		result.Truncated = true
+		result.Summary += " (stopped at the replacement limit)"
+	}
+	return result, nil
+}
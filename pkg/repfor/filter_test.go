@@ -0,0 +1,38 @@
+package repfor
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEngine_Filter(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  Options
+		input string
+		want  string
+		count int
+	}{
+		{"crlf kept", Options{Search: "foo", Replace: "bar"}, "foo\r\nx\r\n", "bar\r\nx\r\n", 1},
+		{"unterminated last line", Options{Search: "foo", Replace: "bar"}, "a\nfoo", "a\nbar", 1},
+		{"whole word and exclude", Options{Search: "foo", Replace: "bar", WholeWord: true, ExcludeLines: []string{"keep"}}, "foo foobar\nfoo keep\n", "bar foobar\nfoo keep\n", 1},
+		{"multiline", Options{Search: "a\nb", Replace: "c"}, "a\r\nb\r\nd", "c\r\nd", 1},
+		{"no match passes through", Options{Search: "zzz", Replace: "y"}, "a\r\nb", "a\r\nb", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			result, err := New(tt.opts).Filter(strings.NewReader(tt.input), &out)
+			if err != nil {
+				t.Fatalf("Filter failed: %v", err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("output = %q, want %q", out.String(), tt.want)
+			}
+			if got := result.Directories[0].TotalReplacements; got != tt.count {
+				t.Errorf("replacements = %d, want %d", got, tt.count)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package repfor
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Case-insensitive matching compares rune by rune under Unicode simple case
+// folding, as strings.EqualFold does. Searching strings.ToLower of the text
+// instead would give offsets into the lowered copy, which do not index the
+// original when lowering changes a rune's length ("İ" is 2 bytes, its
+// lowercase 3).
+
+// indexMatch returns the byte range in s of the first occurrence of search,
+// compared case-insensitively when caseInsensitive is set, or -1, -1. The
+// range indexes s itself; without caseInsensitive it is always len(search)
+// long, with it the matched text may differ in length from search (the
+// Kelvin sign U+212A matches "k").
+func indexMatch(s, search string, caseInsensitive bool) (int, int) {
+	if !caseInsensitive {
+		i := strings.Index(s, search)
+		if i < 0 {
+			return -1, -1
+		}
+		return i, i + len(search)
+	}
+	for i := 0; i < len(s); {
+		if n, ok := hasPrefixFold(s[i:], search); ok {
+			return i, i + n
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+	}
+	return -1, -1
+}
+
+// containsMatch reports whether s contains substr, compared
+// case-insensitively when caseInsensitive is set.
+func containsMatch(s, substr string, caseInsensitive bool) bool {
+	if substr == "" {
+		return true
+	}
+	start, _ := indexMatch(s, substr, caseInsensitive)
+	return start >= 0
+}
+
+// hasPrefixFold reports whether s starts with prefix under simple case
+// folding, and how many bytes of s the match takes.
+func hasPrefixFold(s, prefix string) (int, bool) {
+	i := 0
+	for j := 0; j < len(prefix); {
+		if i >= len(s) {
+			return 0, false
+		}
+		sr, sn := utf8.DecodeRuneInString(s[i:])
+		pr, pn := utf8.DecodeRuneInString(prefix[j:])
+		if sr == utf8.RuneError || pr == utf8.RuneError {
+			// Invalid bytes only match themselves
+			if s[i:i+sn] != prefix[j:j+pn] {
+				return 0, false
+			}
+		} else if sr != pr && !equalFoldRune(sr, pr) {
+			return 0, false
+		}
+		i += sn
+		j += pn
+	}
+	return i, true
+}
+
+// equalFoldRune reports whether a and b are the same rune under simple case
+// folding.
+func equalFoldRune(a, b rune) bool {
+	for r := unicode.SimpleFold(a); r != a; r = unicode.SimpleFold(r) {
+		if r == b {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package repfor
+
+import (
+	"context"
+	"testing"
+)
+
+func TestIndexMatch(t *testing.T) {
+	tests := []struct {
+		s, search       string
+		caseInsensitive bool
+		start, end      int
+	}{
+		{"hello world", "world", false, 6, 11},
+		{"hello World", "world", false, -1, -1},
+		{"hello World", "world", true, 6, 11},
+		// Lowering "İ" lengthens it; offsets still index the original
+		{"İİ foo", "FOO", true, 5, 8},
+		{"ÀÀ foo", "àà", true, 0, 4},
+		// The Kelvin sign folds to k but is 3 bytes long
+		{"Key", "key", true, 0, 5},
+		{"straße", "strasse", true, -1, -1},
+		{"a\xffb", "\xffB", true, 1, 3},
+		{"a\xffb", "\xfeb", true, -1, -1},
+	}
+	for _, tt := range tests {
+		start, end := indexMatch(tt.s, tt.search, tt.caseInsensitive)
+		if start != tt.start || end != tt.end {
+			t.Errorf("indexMatch(%q, %q, %v) = %d, %d; want %d, %d", tt.s, tt.search, tt.caseInsensitive, start, end, tt.start, tt.end)
+		}
+	}
+}
+
+func TestCaseInsensitiveReplace_NonASCII(t *testing.T) {
+	for line, want := range map[string]string{
+		"İİ foo İfoo": "İİ bar İbar",
+		"ÀÀ Foo":      "ÀÀ bar",
+		"Key":         "Key", // search is "foo"
+	} {
+		if got := replaceInLine(line, "foo", "bar", true, false); got != want {
+			t.Errorf("replaceInLine(%q) = %q, want %q", line, got, want)
+		}
+	}
+	if got := replaceInLine("İ foo xfoo", "FOO", "bar", true, true); got != "İ bar xfoo" {
+		t.Errorf("whole word = %q", got)
+	}
+}
+
+func TestCaseInsensitiveFilters_Fold(t *testing.T) {
+	// "Σ" folds to final sigma "ς", but lowers to "σ"
+	const content = "ΟΔΟΣ foo\nfoo\n"
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"exclude", Options{ExcludeLines: []string{"ς"}}, "ΟΔΟΣ foo\nbar\n"},
+		{"include", Options{IncludeLines: []string{"ς"}}, "ΟΔΟΣ bar\nfoo\n"},
+		{"context before", Options{ContextBefore: "ς"}, "ΟΔΟΣ foo\nbar\n"},
+		{"multiline exclude", Options{Search: "foo\nfoo", ExcludeLines: []string{"ς"}}, content},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := setupTestDir(t)
+			defer cleanupTestDir(t, tmpDir)
+			path := createTestFile(t, tmpDir, "a.txt", content)
+			opts := tt.opts
+			opts.Files = []string{path}
+			if opts.Search == "" {
+				opts.Search = "foo"
+			}
+			opts.Replace = "bar"
+			opts.CaseInsensitive = true
+
+			if _, err := New(opts).Run(context.Background()); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			if got := readFileContent(t, path); got != tt.want {
+				t.Errorf("content = %q\nwant      %q", got, tt.want)
+			}
+		})
+	}
+
+	if !shouldExcludeFile("ΟΔΟΣ.txt", []string{"ς"}, true) {
+		t.Error("Expected file name to be excluded under case folding")
+	}
+}
@@ -0,0 +1,648 @@
+package repfor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// FS is the filesystem the engine reads and writes through. Reads use the
+// io/fs interfaces, so the fs package helpers (fs.ReadFile, fs.WalkDir, ...)
+// work on any FS.
+//
+// Unlike io/fs, names are paths as they appear in Options: absolute or
+// relative, in OS form. An FS is therefore not suitable for fs.Sub or
+// testing/fstest.
+type FS interface {
+	fs.StatFS
+	fs.ReadDirFS
+	fs.ReadFileFS
+
+	// WriteFile creates or truncates name, writes data and sets the file's
+	// permission bits to perm.
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	// Rename replaces newname with the file oldname.
+	Rename(oldname, newname string) error
+	// Remove deletes a file or an empty directory.
+	Remove(name string) error
+}
+
+// symlinkFS is implemented by filesystems with symbolic links. Writes go to
+// the link target instead of replacing the link.
+type symlinkFS interface {
+	EvalSymlinks(name string) (string, error)
+}
+
+// tempFS is implemented by filesystems that can create a uniquely named
+// temp file atomically, so no other process can claim its name first.
+type tempFS interface {
+	// WriteTemp creates a new file in dir named by pattern as for
+	// os.CreateTemp, writes data, sets perm and returns the file's name.
+	WriteTemp(dir, pattern string, data []byte, perm fs.FileMode) (string, error)
+}
+
+// fsys returns the filesystem of a run.
+func (o Options) fsys() FS {
+	if o.FS == nil {
+		return OSFS{}
+	}
+	return o.FS
+}
+
+// OSFS is the operating system's filesystem. It is the default when
+// Options.FS is nil.
+type OSFS struct{}
+
+func (OSFS) Open(name string) (fs.File, error)          { return os.Open(name) }
+func (OSFS) Stat(name string) (fs.FileInfo, error)      { return os.Stat(name) }
+func (OSFS) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
+func (OSFS) ReadFile(name string) ([]byte, error)       { return os.ReadFile(name) }
+func (OSFS) Rename(oldname, newname string) error       { return os.Rename(oldname, newname) }
+func (OSFS) Remove(name string) error                   { return os.Remove(name) }
+
+// EvalSymlinks returns name with all symbolic links resolved.
+func (OSFS) EvalSymlinks(name string) (string, error) { return filepath.EvalSymlinks(name) }
+
+// WriteFile syncs the data to disk before returning and sets perm exactly,
+// regardless of the umask.
+func (OSFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	return writeSync(f, data, perm)
+}
+
+// WriteTemp creates the file with os.CreateTemp, which refuses existing names,
+// and otherwise writes as WriteFile does. A failed write removes the file.
+func (OSFS) WriteTemp(dir, pattern string, data []byte, perm fs.FileMode) (string, error) {
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return "", err
+	}
+	if err := writeSync(f, data, perm); err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// writeSync writes data to f, syncs it, sets perm and closes f.
+func writeSync(f *os.File, data []byte, perm fs.FileMode) error {
+	_, err := f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// MemFS is an in-memory filesystem. The roots "/" and "." always exist;
+// other directories are created with MkdirAll. Permission bits are enforced
+// as for an unprivileged user: files without the owner read bit cannot be
+// read, directories without it cannot be listed, and files without the owner
+// write bit cannot be overwritten.
+//
+// A MemFS is safe for concurrent use.
+type MemFS struct {
+	// Quota limits the total size of all files in bytes (zero means no
+	// limit). A write that does not fit stores what fits and fails with
+	// ENOSPC, like a full disk.
+	Quota int64
+
+	mu    sync.Mutex
+	nodes map[string]*memNode
+	used  int64
+}
+
+type memNode struct {
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+// NewMemFS returns an empty in-memory filesystem.
+func NewMemFS() *MemFS {
+	return &MemFS{nodes: make(map[string]*memNode)}
+}
+
+// memName maps a path to its key in a MemFS.
+func memName(name string) string {
+	return path.Clean(filepath.ToSlash(name))
+}
+
+func (m *MemFS) lookup(name string) (*memNode, bool) {
+	if name == "." || name == "/" {
+		return &memNode{mode: fs.ModeDir | 0755}, true
+	}
+	n, ok := m.nodes[name]
+	return n, ok
+}
+
+// MkdirAll creates directory name and any missing parents.
+func (m *MemFS) MkdirAll(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.mkdirAll(memName(name), perm)
+}
+
+func (m *MemFS) mkdirAll(name string, perm fs.FileMode) error {
+	if n, ok := m.lookup(name); ok {
+		if !n.mode.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+		}
+		return nil
+	}
+	if err := m.mkdirAll(path.Dir(name), perm); err != nil {
+		return err
+	}
+	m.nodes[name] = &memNode{mode: fs.ModeDir | perm.Perm(), modTime: time.Now()}
+	return nil
+}
+
+// Chmod sets the permission bits of name.
+func (m *MemFS) Chmod(name string, mode fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = memName(name)
+	n, ok := m.nodes[name]
+	if !ok {
+		return &fs.PathError{Op: "chmod", Path: name, Err: fs.ErrNotExist}
+	}
+	n.mode = n.mode.Type() | mode.Perm()
+	return nil
+}
+
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = memName(name)
+	n, ok := m.lookup(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return n.info(name), nil
+}
+
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, err := m.readable("read", memName(name))
+	if err != nil {
+		return nil, err
+	}
+	if n.mode.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: syscall.EISDIR}
+	}
+	return bytes.Clone(n.data), nil
+}
+
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = memName(name)
+	n, err := m.readable("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !n.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	return m.children(name), nil
+}
+
+func (m *MemFS) Open(name string) (fs.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = memName(name)
+	n, err := m.readable("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if n.mode.IsDir() {
+		return &dirFile{info: n.info(name), entries: m.children(name)}, nil
+	}
+	return &memFile{Reader: bytes.NewReader(bytes.Clone(n.data)), info: n.info(name)}, nil
+}
+
+// readable returns the node of name if it exists and may be read.
+func (m *MemFS) readable(op, name string) (*memNode, error) {
+	n, ok := m.lookup(name)
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	if n.mode&0400 == 0 {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+	}
+	return n, nil
+}
+
+// children lists the entries of directory dir, sorted by name.
+func (m *MemFS) children(dir string) []fs.DirEntry {
+	var entries []fs.DirEntry
+	for name, n := range m.nodes {
+		if name != dir && path.Dir(name) == dir {
+			entries = append(entries, fs.FileInfoToDirEntry(n.info(name)))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries
+}
+
+func (m *MemFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = memName(name)
+	if parent, ok := m.lookup(path.Dir(name)); !ok || !parent.mode.IsDir() {
+		return &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	var oldSize int64
+	if n, ok := m.nodes[name]; ok {
+		if n.mode.IsDir() {
+			return &fs.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+		}
+		if n.mode&0200 == 0 {
+			return &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+		}
+		oldSize = int64(len(n.data))
+	}
+
+	var err error
+	if m.Quota > 0 {
+		if free := m.Quota - (m.used - oldSize); int64(len(data)) > free {
+			data = data[:max(free, 0)]
+			err = &fs.PathError{Op: "write", Path: name, Err: syscall.ENOSPC}
+		}
+	}
+	m.nodes[name] = &memNode{data: bytes.Clone(data), mode: perm.Perm(), modTime: time.Now()}
+	m.used += int64(len(data)) - oldSize
+	return err
+}
+
+func (m *MemFS) Rename(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	oldname, newname = memName(oldname), memName(newname)
+	n, ok := m.nodes[oldname]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrNotExist}
+	}
+	if n.mode.IsDir() {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: errors.New("cannot rename directories")}
+	}
+	if parent, ok := m.lookup(path.Dir(newname)); !ok || !parent.mode.IsDir() {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrNotExist}
+	}
+	if target, ok := m.nodes[newname]; ok {
+		if target.mode.IsDir() {
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EISDIR}
+		}
+		m.used -= int64(len(target.data))
+	}
+	m.nodes[newname] = n
+	delete(m.nodes, oldname)
+	return nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = memName(name)
+	n, ok := m.nodes[name]
+	if !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	if n.mode.IsDir() && len(m.children(name)) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+	}
+	m.used -= int64(len(n.data))
+	delete(m.nodes, name)
+	return nil
+}
+
+// files returns the names of all regular files, sorted.
+func (m *MemFS) files() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var names []string
+	for name, n := range m.nodes {
+		if n.mode.IsRegular() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (n *memNode) info(name string) fs.FileInfo {
+	return &memInfo{name: path.Base(name), size: int64(len(n.data)), mode: n.mode, modTime: n.modTime}
+}
+
+type memInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i *memInfo) Name() string       { return i.name }
+func (i *memInfo) Size() int64        { return i.size }
+func (i *memInfo) Mode() fs.FileMode  { return i.mode }
+func (i *memInfo) ModTime() time.Time { return i.modTime }
+func (i *memInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *memInfo) Sys() any           { return nil }
+
+// memFile is an open MemFS file, reading a snapshot of its content.
+type memFile struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memFile) Close() error               { return nil }
+
+// dirFile is an open directory listing a snapshot of its entries.
+type dirFile struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *dirFile) Close() error               { return nil }
+
+func (d *dirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: syscall.EISDIR}
+}
+
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(rest))
+	d.offset += n
+	return rest[:n], nil
+}
+
+// OverlayFS is a copy-on-write view of a base filesystem: reads see the base
+// with the overlay's changes applied, and writes, renames and removals are
+// kept in memory, leaving the base untouched. Running the engine on an
+// OverlayFS previews a replacement with full fidelity, without DryRun.
+type OverlayFS struct {
+	base  FS
+	upper *MemFS
+
+	mu      sync.Mutex
+	removed map[string]bool // base paths hidden by Remove or Rename
+}
+
+// NewOverlayFS returns an overlay over base.
+func NewOverlayFS(base FS) *OverlayFS {
+	return &OverlayFS{base: base, upper: NewMemFS(), removed: make(map[string]bool)}
+}
+
+// Changed returns the files written through the overlay, sorted. Names are
+// in the slash-separated form of MemFS.
+func (o *OverlayFS) Changed() []string {
+	return o.upper.files()
+}
+
+// inUpper reports whether name has been written to the overlay; hidden
+// reports whether it was removed from the base.
+func (o *OverlayFS) inUpper(name string) (inUpper, hidden bool) {
+	o.mu.Lock()
+	hidden = o.removed[memName(name)]
+	o.mu.Unlock()
+	_, err := o.upper.Stat(name)
+	return err == nil, hidden
+}
+
+func notExist(op, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+func (o *OverlayFS) Stat(name string) (fs.FileInfo, error) {
+	inUpper, hidden := o.inUpper(name)
+	if inUpper {
+		return o.upper.Stat(name)
+	}
+	if hidden {
+		return nil, notExist("stat", name)
+	}
+	return o.base.Stat(name)
+}
+
+func (o *OverlayFS) ReadFile(name string) ([]byte, error) {
+	inUpper, hidden := o.inUpper(name)
+	if inUpper {
+		if info, _ := o.upper.Stat(name); !info.IsDir() {
+			return o.upper.ReadFile(name)
+		}
+	}
+	if hidden {
+		return nil, notExist("read", name)
+	}
+	return o.base.ReadFile(name)
+}
+
+func (o *OverlayFS) Open(name string) (fs.File, error) {
+	info, err := o.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		entries, err := o.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		return &dirFile{info: info, entries: entries}, nil
+	}
+	if inUpper, _ := o.inUpper(name); inUpper {
+		return o.upper.Open(name)
+	}
+	return o.base.Open(name)
+}
+
+// ReadDir merges the base listing with the overlay's changes.
+func (o *OverlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	inUpper, hidden := o.inUpper(name)
+	if hidden && !inUpper {
+		return nil, notExist("readdir", name)
+	}
+
+	merged := make(map[string]fs.DirEntry)
+	base, err := o.base.ReadDir(name)
+	if err != nil && !(inUpper && errors.Is(err, fs.ErrNotExist)) {
+		return nil, err
+	}
+	dir := memName(name)
+	o.mu.Lock()
+	for _, e := range base {
+		if !o.removed[path.Join(dir, e.Name())] {
+			merged[e.Name()] = e
+		}
+	}
+	o.mu.Unlock()
+	if inUpper {
+		upper, err := o.upper.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range upper {
+			if existing, ok := merged[e.Name()]; ok && e.IsDir() {
+				e = existing // directories created only to hold written files
+			}
+			merged[e.Name()] = e
+		}
+	}
+
+	entries := make([]fs.DirEntry, 0, len(merged))
+	for _, e := range merged {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (o *OverlayFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	dir := filepath.Dir(name)
+	if info, err := o.Stat(dir); err != nil || !info.IsDir() {
+		return notExist("open", name)
+	}
+	if info, err := o.Stat(name); err == nil {
+		if info.IsDir() {
+			return &fs.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+		}
+		if info.Mode()&0200 == 0 {
+			return &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+		}
+	}
+	if err := o.upper.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := o.upper.WriteFile(name, data, perm); err != nil {
+		return err
+	}
+	o.mu.Lock()
+	delete(o.removed, memName(name))
+	o.mu.Unlock()
+	return nil
+}
+
+func (o *OverlayFS) Rename(oldname, newname string) error {
+	info, err := o.Stat(oldname)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrNotExist}
+	}
+	if info.IsDir() {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: errors.New("cannot rename directories")}
+	}
+	data, err := o.ReadFile(oldname)
+	if err != nil {
+		return err
+	}
+	// A rename replaces newname regardless of its permissions
+	if err := o.Remove(newname); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := o.WriteFile(newname, data, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return o.Remove(oldname)
+}
+
+func (o *OverlayFS) Remove(name string) error {
+	info, err := o.Stat(name)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		entries, err := o.ReadDir(name)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return &fs.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+		}
+	}
+	if inUpper, _ := o.inUpper(name); inUpper {
+		if err := o.upper.Remove(name); err != nil {
+			return err
+		}
+	}
+	if _, err := o.base.Stat(name); err == nil {
+		o.mu.Lock()
+		o.removed[memName(name)] = true
+		o.mu.Unlock()
+	}
+	return nil
+}
+
+// writeAtomic replaces the content of name through a temp file in the same
+// directory and a rename, so a failed write leaves the original intact.
+// Symlinks are followed and the existing file's permissions are kept.
+func writeAtomic(fsys FS, name string, data []byte) error {
+	// Resolve symlinks so we write to the target, not replace the symlink
+	target := name
+	if sl, ok := fsys.(symlinkFS); ok {
+		resolved, err := sl.EvalSymlinks(name)
+		switch {
+		case err == nil:
+			target = resolved
+		case !errors.Is(err, fs.ErrNotExist):
+			return fmt.Errorf("failed to resolve path: %w", err)
+		}
+	}
+
+	// Preserve permissions (use default 0644 if the file doesn't exist)
+	mode := fs.FileMode(0644)
+	if info, err := fsys.Stat(target); err == nil {
+		mode = info.Mode().Perm()
+		// Check if file is writable (owner write bit)
+		if mode&0200 == 0 {
+			return fmt.Errorf("file is read-only: %s", target)
+		}
+	}
+
+	// The temp file must be in the same directory for the rename to be atomic
+	dir := filepath.Dir(target)
+	var tmp string
+	if t, ok := fsys.(tempFS); ok {
+		var err error
+		if tmp, err = t.WriteTemp(dir, ".repfor-*.tmp", data, mode); err != nil {
+			return fmt.Errorf("failed to write temp file: %w", err)
+		}
+	} else {
+		tmp = filepath.Join(dir, fmt.Sprintf(".repfor-%016x.tmp", rand.Uint64()))
+		if err := fsys.WriteFile(tmp, data, mode); err != nil {
+			_ = fsys.Remove(tmp)
+			return fmt.Errorf("failed to write temp file: %w", err)
+		}
+	}
+	if err := fsys.Rename(tmp, target); err != nil {
+		_ = fsys.Remove(tmp)
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package repfor
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+// newMemTree returns a MemFS holding files (path -> content), creating
+// parent directories as needed.
+func newMemTree(t *testing.T, files map[string]string) *MemFS {
+	t.Helper()
+	mem := NewMemFS()
+	for name, content := range files {
+		if err := mem.MkdirAll(path.Dir(name), 0755); err != nil {
+			t.Fatalf("MkdirAll %s: %v", name, err)
+		}
+		if err := mem.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile %s: %v", name, err)
+		}
+	}
+	return mem
+}
+
+func entryNames(entries []fs.DirEntry) []string {
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestMemFS_Operations(t *testing.T) {
+	mem := newMemTree(t, map[string]string{"/src/a.txt": "a", "/src/sub/b.txt": "b"})
+
+	entries, err := mem.ReadDir("/src")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if got := entryNames(entries); !reflect.DeepEqual(got, []string{"a.txt", "sub"}) {
+		t.Errorf("ReadDir = %v", got)
+	}
+
+	if err := mem.WriteFile("/missing/c.txt", nil, 0644); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("WriteFile without parent: %v", err)
+	}
+	if err := mem.Rename("/src/a.txt", "/src/sub/a.txt"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if _, err := mem.Stat("/src/a.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("old name still exists after rename: %v", err)
+	}
+	if data, err := fs.ReadFile(mem, "/src/sub/a.txt"); err != nil || string(data) != "a" {
+		t.Errorf("renamed file = %q, %v", data, err)
+	}
+
+	if err := mem.Remove("/src/sub"); !errors.Is(err, syscall.ENOTEMPTY) {
+		t.Errorf("Remove of non-empty dir: %v", err)
+	}
+
+	if err := mem.Chmod("/src/sub/b.txt", 0444); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.WriteFile("/src/sub/b.txt", []byte("x"), 0644); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("WriteFile over read-only file: %v", err)
+	}
+}
+
+func TestMemFS_Quota(t *testing.T) {
+	mem := newMemTree(t, map[string]string{"a.txt": "12345"})
+	mem.Quota = 8
+
+	err := mem.WriteFile("b.txt", []byte("abcdef"), 0644)
+	if !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("Expected ENOSPC, got %v", err)
+	}
+	if data, _ := mem.ReadFile("b.txt"); string(data) != "abc" {
+		t.Errorf("partial write = %q, want %q", data, "abc")
+	}
+
+	// Removing a file frees its space
+	if err := mem.Remove("b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.WriteFile("b.txt", []byte("abc"), 0644); err != nil {
+		t.Errorf("WriteFile after freeing space: %v", err)
+	}
+}
+
+func TestEngine_MemFS(t *testing.T) {
+	mem := newMemTree(t, map[string]string{
+		"proj/a.go":     "foo()\r\nbar()\r\n",
+		"proj/b.txt":    "foo\n",
+		"proj/sub/c.go": "foo foo\n",
+	})
+
+	result, err := New(Options{
+		Dirs:      []string{"proj"},
+		Search:    "foo",
+		Replace:   "baz",
+		Ext:       ".go",
+		Recursive: true,
+		FS:        mem,
+	}).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if result.Summary != "Modified 2 files: 3 replacements in 2 lines" {
+		t.Errorf("summary = %q", result.Summary)
+	}
+
+	want := map[string]string{
+		"proj/a.go":     "baz()\r\nbar()\r\n",
+		"proj/b.txt":    "foo\n",
+		"proj/sub/c.go": "baz baz\n",
+	}
+	for name, content := range want {
+		if data, _ := mem.ReadFile(name); string(data) != content {
+			t.Errorf("%s = %q, want %q", name, data, content)
+		}
+	}
+	if files := mem.files(); len(files) != len(want) {
+		t.Errorf("unexpected files left behind: %v", files)
+	}
+}
+
+func TestOverlayFS_PreviewLeavesBase(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	filePath := createTestFile(t, tmpDir, "a.txt", "old value\n")
+	createTestFile(t, tmpDir, "b.txt", "unrelated\n")
+
+	overlay := NewOverlayFS(OSFS{})
+	_, err := New(Options{Dirs: []string{tmpDir}, Search: "old", Replace: "new", FS: overlay}).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if content := readFileContent(t, filePath); content != "old value\n" {
+		t.Errorf("base file modified through overlay: %q", content)
+	}
+	if data, err := overlay.ReadFile(filePath); err != nil || string(data) != "new value\n" {
+		t.Errorf("overlay content = %q, %v", data, err)
+	}
+	if got := overlay.Changed(); !reflect.DeepEqual(got, []string{filepath.ToSlash(filePath)}) {
+		t.Errorf("Changed() = %v", got)
+	}
+
+	entries, err := overlay.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if got := entryNames(entries); !reflect.DeepEqual(got, []string{"a.txt", "b.txt"}) {
+		t.Errorf("merged ReadDir = %v", got)
+	}
+}
+
+func TestOverlayFS_RemoveHidesBase(t *testing.T) {
+	base := newMemTree(t, map[string]string{"d/a.txt": "a", "d/b.txt": "b"})
+	overlay := NewOverlayFS(base)
+
+	if err := overlay.Rename("d/a.txt", "d/c.txt"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if err := overlay.Remove("d/b.txt"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+
+	entries, err := fs.ReadDir(overlay, "d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := entryNames(entries); !reflect.DeepEqual(got, []string{"c.txt"}) {
+		t.Errorf("ReadDir after rename and remove = %v", got)
+	}
+	if _, err := overlay.Stat("d/b.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("removed file still visible: %v", err)
+	}
+	if got := base.files(); !reflect.DeepEqual(got, []string{"d/a.txt", "d/b.txt"}) {
+		t.Errorf("base changed: %v", got)
+	}
+}
+
+func TestOSFS_WriteTemp(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+
+	// Each temp file gets a fresh name, never an existing one
+	first, err := OSFS{}.WriteTemp(tmpDir, ".repfor-*.tmp", []byte("one"), 0640)
+	if err != nil {
+		t.Fatalf("WriteTemp failed: %v", err)
+	}
+	second, err := OSFS{}.WriteTemp(tmpDir, ".repfor-*.tmp", []byte("two"), 0640)
+	if err != nil {
+		t.Fatalf("WriteTemp failed: %v", err)
+	}
+	if first == second || filepath.Dir(first) != tmpDir {
+		t.Errorf("temp names = %q, %q", first, second)
+	}
+	if got := readFileContent(t, first); got != "one" {
+		t.Errorf("content = %q, want one", got)
+	}
+	info, err := OSFS{}.Stat(first)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("mode = %v, want 0640", info.Mode().Perm())
+	}
+
+	// No temp file is left behind by an atomic write
+	path := createTestFile(t, tmpDir, "a.txt", "old")
+	if err := writeAtomic(OSFS{}, path, []byte("new")); err != nil {
+		t.Fatalf("writeAtomic failed: %v", err)
+	}
+	entries, _ := OSFS{}.ReadDir(tmpDir)
+	if len(entries) != 3 {
+		t.Errorf("entries = %v, want the two temp files and a.txt", entryNames(entries))
+	}
+}
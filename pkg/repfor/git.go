@@ -0,0 +1,58 @@
+package repfor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// gitSelection reports whether the run selects files from git rather than by
+// listing directories.
+func (o Options) gitSelection() bool {
+	return o.GitTracked || o.GitChangedSince != ""
+}
+
+// gitFiles returns the files under dir selected by git: those changed
+// between GitChangedSince and the working tree, or else every tracked file.
+// Without Recursive only files directly in dir are kept. Deleted files and
+// submodules are skipped.
+func gitFiles(ctx context.Context, dir string, config Options) ([]string, error) {
+	args := []string{"-C", dir, "ls-files", "-z", "--cached"}
+	if ref := config.GitChangedSince; ref != "" {
+		// A ref starting with "-" would be parsed as an option
+		if strings.HasPrefix(ref, "-") {
+			return nil, fmt.Errorf("invalid git ref %q", ref)
+		}
+		args = []string{"-C", dir, "diff", "--name-only", "-z", "--relative", "--diff-filter=d", ref, "--"}
+	}
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git %s in %s: %s", args[2], dir, msg)
+		}
+		return nil, fmt.Errorf("git %s in %s: %w", args[2], dir, err)
+	}
+
+	var files []string
+	for _, name := range strings.Split(string(out), "\x00") {
+		if name == "" || (!config.Recursive && strings.Contains(name, "/")) {
+			continue
+		}
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		info, err := fs.Stat(config.fsys(), path)
+		if errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+			continue
+		}
+		files = append(files, path)
+	}
+	return files, nil
+}
@@ -0,0 +1,141 @@
+package repfor
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gitRepo creates a repository in a temp dir with committed files a.go,
+// b.txt and sub/c.go, then modifies a.go and adds untracked d.go.
+func gitRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+
+	git("init", "-q")
+	createTestFile(t, dir, "a.go", "foo\n")
+	createTestFile(t, dir, "b.txt", "foo\n")
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	createTestFile(t, filepath.Join(dir, "sub"), "c.go", "foo\n")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+
+	createTestFile(t, dir, "a.go", "foo changed\n")
+	createTestFile(t, dir, "d.go", "foo\n")
+	return dir
+}
+
+func modifiedPaths(result *Result) []string {
+	var paths []string
+	for _, d := range result.Directories {
+		for _, f := range d.Files {
+			paths = append(paths, filepath.Base(f.Path))
+		}
+	}
+	return paths
+}
+
+func TestGitSelection(t *testing.T) {
+	dir := gitRepo(t)
+
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"tracked", Options{GitTracked: true}, "a.go b.txt"},
+		{"tracked recursive with ext", Options{GitTracked: true, Recursive: true, Ext: ".go"}, "a.go c.go"},
+		{"changed since HEAD", Options{GitChangedSince: "HEAD", Recursive: true}, "a.go"},
+		{"changed with exclude", Options{GitChangedSince: "HEAD", ExcludeFiles: []string{"a."}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.Dirs = []string{dir}
+			opts.Search = "foo"
+			opts.Replace = "bar"
+			opts.DryRun = true
+			result, err := New(opts).Run(context.Background())
+			if err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			if got := strings.Join(modifiedPaths(result), " "); got != tt.want {
+				t.Errorf("selected %q, want %q", got, tt.want)
+			}
+			if result.Directories[0].Dir != dir {
+				t.Errorf("Dir = %q, want %q", result.Directories[0].Dir, dir)
+			}
+		})
+	}
+}
+
+func TestGitSelection_Errors(t *testing.T) {
+	dir := gitRepo(t)
+
+	for _, ref := range []string{"--output=/tmp/x", "no-such-ref"} {
+		_, err := New(Options{Dirs: []string{dir}, Search: "foo", GitChangedSince: ref}).Run(context.Background())
+		if err == nil {
+			t.Errorf("Expected error for ref %q", ref)
+		}
+	}
+
+	_, err := New(Options{Dirs: []string{t.TempDir()}, Search: "foo", GitTracked: true}).Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "git ls-files") {
+		t.Errorf("Expected git error outside a repository, got %v", err)
+	}
+}
+
+func TestGitSelection_Sandbox(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	base := t.TempDir()
+	dir := filepath.Join(base, "repo")
+	outside := filepath.Join(base, "out")
+	for _, d := range []string{dir, outside} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	createTestFile(t, outside, "s.txt", "secret foo\n")
+	createTestFile(t, dir, "a.txt", "foo\n")
+	if err := os.Symlink(filepath.Join("..", "out", "s.txt"), filepath.Join(dir, "s.txt")); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+	for _, args := range [][]string{{"init", "-q"}, {"add", "."}} {
+		if out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	sandbox, err := NewSandbox([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The tracked symlink leads out of the sandbox and must not be read
+	result, err := New(Options{Dirs: []string{dir}, Search: "foo", Replace: "bar", DryRun: true, CollectMatches: true, GitTracked: true, Sandbox: sandbox}).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got := strings.Join(modifiedPaths(result), " "); got != "a.txt" {
+		t.Errorf("selected %q, want a.txt", got)
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0].Message, "outside the allowed roots") {
+		t.Errorf("warnings = %+v", result.Warnings)
+	}
+}
@@ -0,0 +1,60 @@
+package repfor
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// includeFilter is the compiled form of Options.IncludeLines: the lines a
+// replacement may change must contain one of the patterns.
+type includeFilter struct {
+	patterns        []string
+	regexps         []*regexp.Regexp
+	caseInsensitive bool
+}
+
+// newIncludeFilter compiles the IncludeLines of o. It returns nil when o has
+// none, which lets every line through.
+func newIncludeFilter(o Options) (*includeFilter, error) {
+	if len(o.IncludeLines) == 0 {
+		return nil, nil
+	}
+	f := &includeFilter{caseInsensitive: o.CaseInsensitive}
+	for _, pattern := range o.IncludeLines {
+		if !o.IncludeRegexp {
+			f.patterns = append(f.patterns, pattern)
+			continue
+		}
+		// Multiline matches are checked against several lines at once, so
+		// ^ and $ anchor at each of them
+		flags := "(?m)"
+		if o.CaseInsensitive {
+			flags = "(?mi)"
+		}
+		re, err := regexp.Compile(flags + pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include pattern %q: %w", pattern, err)
+		}
+		f.regexps = append(f.regexps, re)
+	}
+	return f, nil
+}
+
+// match reports whether text, one or more whole lines, contains one of the
+// patterns. A nil filter matches everything.
+func (f *includeFilter) match(text string) bool {
+	if f == nil {
+		return true
+	}
+	for _, re := range f.regexps {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+	for _, pattern := range f.patterns {
+		if containsMatch(text, pattern, f.caseInsensitive) {
+			return true
+		}
+	}
+	return false
+}
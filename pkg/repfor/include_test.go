@@ -0,0 +1,78 @@
+package repfor
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestIncludeLines(t *testing.T) {
+	const content = "import foo\nfunc foo() {}\n\tfunc foo\nvar foo\n"
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"substring", Options{IncludeLines: []string{"import", "var"}}, "import bar\nfunc foo() {}\n\tfunc foo\nvar bar\n"},
+		{"case-insensitive", Options{IncludeLines: []string{"IMPORT"}, CaseInsensitive: true}, "import bar\nfunc foo() {}\n\tfunc foo\nvar foo\n"},
+		{"case-sensitive", Options{IncludeLines: []string{"IMPORT"}}, content},
+		{"regexp", Options{IncludeLines: []string{`^\s*func`}, IncludeRegexp: true}, "import foo\nfunc bar() {}\n\tfunc bar\nvar foo\n"},
+		{"regexp case-insensitive", Options{IncludeLines: []string{`^VAR `}, IncludeRegexp: true, CaseInsensitive: true}, "import foo\nfunc foo() {}\n\tfunc foo\nvar bar\n"},
+		{"with exclude", Options{IncludeLines: []string{"func"}, ExcludeLines: []string{"{}"}}, "import foo\nfunc foo() {}\n\tfunc bar\nvar foo\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := setupTestDir(t)
+			defer cleanupTestDir(t, tmpDir)
+			path := createTestFile(t, tmpDir, "a.go", content)
+			opts := tt.opts
+			opts.Files = []string{path}
+			opts.Search, opts.Replace = "foo", "bar"
+
+			if _, err := New(opts).Run(context.Background()); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			if got := readFileContent(t, path); got != tt.want {
+				t.Errorf("content = %q\nwant      %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIncludeLines_Multiline(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.go", "a\nb\n// keep\na\nb\n")
+
+	// The lines spanned by a match are checked together
+	opts := Options{Files: []string{path}, Search: "a\nb", Replace: "c", IncludeLines: []string{`^b$`}, IncludeRegexp: true}
+	if _, err := New(opts).Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got := readFileContent(t, path); got != "c\n// keep\nc\n" {
+		t.Errorf("content = %q", got)
+	}
+
+	path = createTestFile(t, tmpDir, "b.go", "a\nb\n// keep\na\nb // here\n")
+	opts = Options{Files: []string{path}, Search: "a\nb", Replace: "c", IncludeLines: []string{"here"}}
+	if _, err := New(opts).Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got := readFileContent(t, path); got != "a\nb\n// keep\nc // here\n" {
+		t.Errorf("content = %q", got)
+	}
+}
+
+func TestIncludeLines_Filter(t *testing.T) {
+	var out strings.Builder
+	if _, err := New(Options{Search: "x", Replace: "y", IncludeLines: []string{"keep"}}).Filter(strings.NewReader("x\nx keep\n"), &out); err != nil {
+		t.Fatalf("Filter failed: %v", err)
+	}
+	if out.String() != "x\ny keep\n" {
+		t.Errorf("output = %q", out.String())
+	}
+
+	if _, err := New(Options{Search: "x", IncludeLines: []string{"("}, IncludeRegexp: true}).Filter(strings.NewReader("x\n"), &out); err == nil {
+		t.Error("an invalid pattern should fail")
+	}
+}
@@ -0,0 +1,79 @@
+package repfor
+
+import "errors"
+
+// runBudget counts the replacements of one run against
+// Options.MaxReplacements.
+type runBudget struct {
+	used      int
+	truncated bool // a match was left because the budget ran out
+}
+
+// limiter decides which matches of one file are replaced under the
+// occurrence and limit options. Matches are offered in file order; allow is
+// asked about each one that would otherwise be replaced, and replaced is
+// called for each one that is.
+type limiter struct {
+	occurrence int // 1-based match to replace; 0 means every match
+	perFile    int // 0 means no limit
+	perRun     int // 0 means no limit
+	run        *runBudget
+
+	seen      int
+	made      int
+	truncated bool // a match was left because of a limit
+}
+
+// validateLimits checks the occurrence and limit options.
+func (o Options) validateLimits() error {
+	if o.Occurrence < 0 || o.MaxReplacements < 0 || o.MaxReplacementsPerFile < 0 {
+		return errors.New("occurrence and replacement limits must not be negative")
+	}
+	if o.FirstOnly && o.Occurrence > 1 {
+		return errors.New("first only and an occurrence other than 1 conflict")
+	}
+	return nil
+}
+
+// limiter returns the limiter for one file of the run.
+func (o Options) limiter() *limiter {
+	l := &limiter{
+		occurrence: o.Occurrence,
+		perFile:    o.MaxReplacementsPerFile,
+		perRun:     o.MaxReplacements,
+		run:        o.budget,
+	}
+	if o.FirstOnly && (l.perFile == 0 || l.perFile > 1) {
+		l.perFile = 1
+	}
+	if l.run == nil {
+		l.run = &runBudget{}
+	}
+	return l
+}
+
+// active reports whether the limiter can decline a match, so that matches
+// must be replaced one at a time.
+func (l *limiter) active() bool {
+	return l.occurrence > 0 || l.perFile > 0 || l.perRun > 0
+}
+
+// allow reports whether the next match may be replaced.
+func (l *limiter) allow() bool {
+	l.seen++
+	if l.occurrence > 0 && l.seen != l.occurrence {
+		return false
+	}
+	if (l.perFile > 0 && l.made >= l.perFile) || (l.perRun > 0 && l.run.used >= l.perRun) {
+		l.truncated = true
+		l.run.truncated = true
+		return false
+	}
+	return true
+}
+
+// replaced records that the match last allowed was replaced.
+func (l *limiter) replaced() {
+	l.made++
+	l.run.used++
+}
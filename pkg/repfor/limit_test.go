@@ -0,0 +1,141 @@
+package repfor
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      Options
+		want      [2]string // resulting content of a.txt and b.txt
+		truncated bool
+	}{
+		{"occurrence", Options{Occurrence: 2}, [2]string{"foo bar\nfoo\n", "foo\nbar\n"}, false},
+		{"occurrence past the end", Options{Occurrence: 5}, [2]string{"foo foo\nfoo\n", "foo\nfoo\n"}, false},
+		{"first only", Options{FirstOnly: true}, [2]string{"bar foo\nfoo\n", "bar\nfoo\n"}, true},
+		{"per file", Options{MaxReplacementsPerFile: 2}, [2]string{"bar bar\nfoo\n", "bar\nbar\n"}, true},
+		{"per run", Options{MaxReplacements: 4}, [2]string{"bar bar\nbar\n", "bar\nfoo\n"}, true},
+		{"per run not reached", Options{MaxReplacements: 5}, [2]string{"bar bar\nbar\n", "bar\nbar\n"}, false},
+		{"occurrence within a line", Options{Occurrence: 1, ExcludeLines: []string{"foo foo"}}, [2]string{"foo foo\nbar\n", "bar\nfoo\n"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := setupTestDir(t)
+			defer cleanupTestDir(t, tmpDir)
+			a := createTestFile(t, tmpDir, "a.txt", "foo foo\nfoo\n")
+			b := createTestFile(t, tmpDir, "b.txt", "foo\nfoo\n")
+			opts := tt.opts
+			opts.Files = []string{a, b}
+			opts.Search, opts.Replace = "foo", "bar"
+
+			result, err := New(opts).Run(context.Background())
+			if err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			if got := [2]string{readFileContent(t, a), readFileContent(t, b)}; got != tt.want {
+				t.Errorf("content = %q, want %q", got, tt.want)
+			}
+			if result.Truncated != tt.truncated || strings.Contains(result.Summary, "limit") != tt.truncated {
+				t.Errorf("truncated = %v, summary %q; want %v", result.Truncated, result.Summary, tt.truncated)
+			}
+		})
+	}
+}
+
+func TestLimits_CaseInsensitiveNonASCII(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.txt", "ÀÀ foo İfoo\n")
+
+	// Lowering "İ" changes its length, which must not shift the match
+	result, err := New(Options{Files: []string{path}, Search: "FOO", Replace: "bar", CaseInsensitive: true, Occurrence: 2, CollectMatches: true}).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if content := readFileContent(t, path); content != "ÀÀ foo İbar\n" {
+		t.Errorf("content = %q", content)
+	}
+	if m := result.Directories[0].Files[0].Matches; len(m) != 1 || m[0].Column != 9 || m[0].Match != "foo" {
+		t.Errorf("matches = %+v", m)
+	}
+
+	path = createTestFile(t, tmpDir, "b.txt", "İİ\nx İ\nx\n")
+	if _, err := New(Options{Files: []string{path}, Search: "İ\nX", Replace: "y", CaseInsensitive: true, MaxReplacements: 1}).Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if content := readFileContent(t, path); content != "İy İ\nx\n" {
+		t.Errorf("multiline content = %q", content)
+	}
+}
+
+func TestLimits_Multiline(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.txt", "a\nb\na\nb\na\nb\n")
+
+	result, err := New(Options{Files: []string{path}, Search: "a\nb", Replace: "c", Occurrence: 2, CollectMatches: true}).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if content := readFileContent(t, path); content != "a\nb\nc\na\nb\n" {
+		t.Errorf("content = %q", content)
+	}
+	if m := result.Directories[0].Files[0].Matches; len(m) != 1 || m[0].Line != 3 {
+		t.Errorf("matches = %+v", m)
+	}
+
+	result, err = New(Options{Files: []string{path}, Search: "a\nb", Replace: "c", MaxReplacementsPerFile: 1, DryRun: true}).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if f := result.Directories[0].Files[0]; f.Replacements != 1 || !f.Truncated || !result.Truncated {
+		t.Errorf("file = %+v, truncated %v", f, result.Truncated)
+	}
+}
+
+func TestLimits_WithConfirm(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.txt", "foo foo foo\n")
+
+	// A declined match does not use up the limit
+	asked := 0
+	confirm := func(p Proposal) (string, bool) {
+		asked++
+		return p.Replacement, asked > 1
+	}
+	if _, err := New(Options{Files: []string{path}, Search: "foo", Replace: "bar", FirstOnly: true, Confirm: confirm}).Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if content := readFileContent(t, path); content != "foo bar foo\n" || asked != 2 {
+		t.Errorf("content = %q after %d proposals", content, asked)
+	}
+}
+
+func TestLimits_Filter(t *testing.T) {
+	var out strings.Builder
+	result, err := New(Options{Search: "x", Replace: "y", MaxReplacements: 1}).Filter(strings.NewReader("x x\n"), &out)
+	if err != nil {
+		t.Fatalf("Filter failed: %v", err)
+	}
+	if out.String() != "y x\n" || !result.Truncated {
+		t.Errorf("output %q, truncated %v", out.String(), result.Truncated)
+	}
+}
+
+func TestLimits_Errors(t *testing.T) {
+	for _, opts := range []Options{
+		{Occurrence: -1},
+		{MaxReplacements: -2},
+		{MaxReplacementsPerFile: -1},
+		{FirstOnly: true, Occurrence: 2},
+	} {
+		opts.Search = "x"
+		if _, err := New(opts).Run(context.Background()); err == nil {
+			t.Errorf("Run(%+v) should fail", opts)
+		}
+	}
+}
@@ -0,0 +1,576 @@
+package repfor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// GitPatch returns changes as one git-style patch, applicable with
+// `git apply` or `patch -p1`: a "diff --git" section per changed file, named
+// by its path relative to root behind a/ and b/ prefixes. Changes that leave
+// a file as it was are skipped. It fails if a file lies outside root.
+func GitPatch(root string, changes []FileChange) (string, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, c := range changes {
+		abs, err := filepath.Abs(c.Path)
+		if err != nil {
+			return "", err
+		}
+		rel, err := filepath.Rel(absRoot, abs)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", fmt.Errorf("%s is outside the patch root %s", c.Path, root)
+		}
+		rel = filepath.ToSlash(rel)
+
+		oldName, newName := quoteGitPath("a/"+rel), quoteGitPath("b/"+rel)
+		// Like git, end names holding a space with a tab so patch(1) reads
+		// them whole
+		tab := ""
+		if strings.Contains(rel, " ") {
+			tab = "\t"
+		}
+		diff := UnifiedDiff(oldName+tab, newName+tab, c.Before, c.After)
+		if diff == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "diff --git %s %s\n%s", oldName, newName, diff)
+	}
+	return b.String(), nil
+}
+
+// gitEscapes are the C escapes git uses in quoted paths; other control
+// characters are written in octal.
+var gitEscapes = map[byte]string{
+	'"': `\"`, '\\': `\\`, '\a': `\a`, '\b': `\b`, '\t': `\t`, '\n': `\n`, '\v': `\v`, '\f': `\f`, '\r': `\r`,
+}
+
+// quoteGitPath quotes a path in a patch header the way git does when it holds
+// a quote, backslash or control character. Other UTF-8 is left as is.
+func quoteGitPath(name string) string {
+	if !strings.ContainsFunc(name, func(r rune) bool { return r < 0x20 || r == 0x7f || r == '"' || r == '\\' }) {
+		return name
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch esc, ok := gitEscapes[c]; {
+		case ok:
+			b.WriteString(esc)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// unquoteGitPath reverses quoteGitPath. Names that are not quoted are
+// returned as they are.
+func unquoteGitPath(name string) (string, error) {
+	if len(name) < 2 || name[0] != '"' || name[len(name)-1] != '"' {
+		return name, nil
+	}
+	s := name[1 : len(name)-1]
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 == len(s) {
+			return "", fmt.Errorf("invalid quoted path %s", name)
+		}
+		i++
+		if s[i] >= '0' && s[i] <= '7' {
+			if i+3 > len(s) {
+				return "", fmt.Errorf("invalid quoted path %s", name)
+			}
+			c, err := strconv.ParseUint(s[i:i+3], 8, 8)
+			if err != nil {
+				return "", fmt.Errorf("invalid quoted path %s", name)
+			}
+			b.WriteByte(byte(c))
+			i += 2
+			continue
+		}
+		found := false
+		for c, esc := range gitEscapes {
+			if esc[1] == s[i] {
+				b.WriteByte(c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "", fmt.Errorf("invalid quoted path %s", name)
+		}
+	}
+	return b.String(), nil
+}
+
+// Patch parsing and application.
+//
+// ParsePatch accepts what git diff, diff -u and hand-edited diffs produce: the
+// lines between file sections (diff --git, index, mode lines) are ignored, and
+// hunk line counts are only a guide, since generated diffs often get them
+// wrong. ApplyPatch locates every hunk by its content, the way patch(1) does:
+// first at the line in its header, then at growing offsets from it, then
+// again ignoring up to maxPatchFuzz context lines at each end.
+
+// maxPatchFuzz is the most context lines ignored at each end of a hunk that
+// does not match as written, as with patch(1)'s default fuzz factor.
+const maxPatchFuzz = 2
+
+// devNull names the missing side of a created or deleted file.
+const devNull = "/dev/null"
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// FilePatch is the part of a unified diff that changes one file.
+type FilePatch struct {
+	OldName string // from the --- header, "/dev/null" for a created file
+	NewName string // from the +++ header, "/dev/null" for a deleted file
+	Hunks   []Hunk
+}
+
+// Hunk is one @@ section of a FilePatch.
+type Hunk struct {
+	OldStart int // 1-based, from the header
+	NewStart int
+	// Lines holds the body: each line starts with ' ', '-' or '+' and keeps
+	// its terminator unless it is marked "\ No newline at end of file".
+	Lines []string
+}
+
+// ParsePatch parses a unified diff holding changes to one or more files.
+func ParsePatch(data []byte) ([]FilePatch, error) {
+	lines := splitLines(string(data))
+	var patches []FilePatch
+	for i := 0; i < len(lines); i++ {
+		if !isFileHeader(lines, i) {
+			continue
+		}
+		oldName, err := patchName(lines[i][len("--- "):])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		newName, err := patchName(lines[i+1][len("+++ "):])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+2, err)
+		}
+		fp := FilePatch{OldName: oldName, NewName: newName}
+
+		i += 2
+		for i < len(lines) && strings.HasPrefix(lines[i], "@@ ") {
+			h, next, err := parseHunk(lines, i)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			fp.Hunks = append(fp.Hunks, h)
+			i = next
+		}
+		if len(fp.Hunks) == 0 {
+			return nil, fmt.Errorf("line %d: no hunks for %s", i, newName)
+		}
+		patches = append(patches, fp)
+		i-- // the loop moves past the last hunk line
+	}
+	if len(patches) == 0 {
+		return nil, errors.New("no file changes found in patch")
+	}
+	return patches, nil
+}
+
+// isFileHeader reports whether lines[i] starts a "--- old" "+++ new" pair.
+func isFileHeader(lines []string, i int) bool {
+	return strings.HasPrefix(lines[i], "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ ")
+}
+
+// patchName extracts the file name from the rest of a ---/+++ header, which
+// may be quoted or followed by a tab and a timestamp.
+func patchName(s string) (string, error) {
+	s = strings.TrimRight(s, "\r\n")
+	if strings.HasPrefix(s, `"`) {
+		if end := strings.LastIndexByte(s, '"'); end > 0 {
+			s = s[:end+1]
+		}
+		return unquoteGitPath(s)
+	}
+	name, _, _ := strings.Cut(s, "\t")
+	if name = strings.TrimRight(name, " "); name == "" {
+		return "", errors.New("missing file name")
+	}
+	return name, nil
+}
+
+// parseHunk parses the hunk whose header is lines[i] and returns it with the
+// index of the line after it. The body ends where the header's counts say,
+// or later if it goes on with lines that can only belong to it.
+func parseHunk(lines []string, i int) (Hunk, int, error) {
+	m := hunkHeader.FindStringSubmatch(lines[i])
+	if m == nil {
+		return Hunk{}, 0, fmt.Errorf("malformed hunk header %q", strings.TrimRight(lines[i], "\r\n"))
+	}
+	count := func(s string) int {
+		if s == "" {
+			return 1
+		}
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+	h := Hunk{}
+	h.OldStart, _ = strconv.Atoi(m[1])
+	h.NewStart, _ = strconv.Atoi(m[3])
+	oldLeft, newLeft := count(m[2]), count(m[4])
+
+	for i++; i < len(lines); i++ {
+		line := lines[i]
+		counted := oldLeft > 0 || newLeft > 0
+		switch {
+		case strings.HasPrefix(line, `\`):
+			// "\ No newline at end of file" applies to the line before
+			if n := len(h.Lines); n > 0 {
+				h.Lines[n-1] = strings.TrimSuffix(h.Lines[n-1], "\n")
+			}
+			continue
+		case line == "\n" || line == "\r\n":
+			// A context line whose leading space was stripped by an editor
+			if !counted {
+				return h, i, nil
+			}
+			line = " " + line
+		case line[0] != ' ' && line[0] != '-' && line[0] != '+':
+			return h, i, nil
+		case !counted && (strings.HasPrefix(line, "--- ") || trimEOL(line) == "-- "):
+			// The next file, or the signature git format-patch appends
+			return h, i, nil
+		}
+
+		switch line[0] {
+		case ' ':
+			oldLeft--
+			newLeft--
+		case '-':
+			oldLeft--
+		case '+':
+			newLeft--
+		}
+		h.Lines = append(h.Lines, line)
+	}
+	return h, i, nil
+}
+
+// PatchResult reports how a patch applied. Nothing is written unless every
+// hunk of every file applies.
+type PatchResult struct {
+	Summary string        `json:"summary"`
+	Applied bool          `json:"applied"` // every hunk applied (and, without DryRun, was written)
+	Files   []PatchedFile `json:"files"`
+	DryRun  bool          `json:"dry_run,omitempty"`
+}
+
+// PatchedFile is the outcome of one file of a patch.
+type PatchedFile struct {
+	Path  string       `json:"path"`
+	Hunks []HunkResult `json:"hunks"`
+	Error string       `json:"error,omitempty"` // why the file as a whole could not be patched
+}
+
+// HunkResult is the outcome of one hunk.
+type HunkResult struct {
+	Hunk    int    `json:"hunk"` // 1-based position in the file's patch
+	Applied bool   `json:"applied"`
+	Line    int    `json:"line,omitempty"`   // line of the original file where it applied
+	Offset  int    `json:"offset,omitempty"` // lines away from the line in its header
+	Fuzz    int    `json:"fuzz,omitempty"`   // context lines ignored at each end
+	Error   string `json:"error,omitempty"`
+}
+
+// ApplyPatch applies a unified diff to files under dir, as `patch -p1` run
+// in dir would when the names have git's a/ and b/ prefixes, and as -p0
+// otherwise. Names must stay within dir. It applies Sandbox, FS, DryRun and
+// OnChange; the search and selection options are ignored. Only existing files
+// are patched: hunks creating, deleting or renaming files are rejected.
+//
+// Every hunk is checked before anything is written. If any fails, the result
+// reports which and no file is modified; the error is reserved for patches
+// that cannot be parsed. A failure while writing leaves the files written
+// before it modified.
+func (e *Engine) ApplyPatch(ctx context.Context, dir string, patch []byte) (*PatchResult, error) {
+	files, err := ParsePatch(patch)
+	if err != nil {
+		return nil, err
+	}
+	fsys := e.opts.fsys()
+	result := &PatchResult{Files: make([]PatchedFile, 0, len(files)), DryRun: e.opts.DryRun, Applied: true}
+
+	var changes []FileChange
+	changed := make(map[string]int) // index in changes of each patched path
+	var sections []int              // index in result.Files of the last section of each change
+	hunks, failed := 0, 0
+	for _, fp := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		// A file named by several sections is patched by each in turn, as
+		// git apply does
+		read := func(path string) ([]byte, error) {
+			if i, ok := changed[path]; ok {
+				return changes[i].After, nil
+			}
+			return fs.ReadFile(fsys, path)
+		}
+		pf, change := e.patchFile(read, dir, fp)
+		hunks += len(pf.Hunks)
+		result.Files = append(result.Files, pf)
+		switch i, ok := changed[pf.Path]; {
+		case change == nil:
+			failed++
+		case ok:
+			changes[i].After = change.After
+			sections[i] = len(result.Files) - 1
+		default:
+			changed[pf.Path] = len(changes)
+			changes = append(changes, *change)
+			sections = append(sections, len(result.Files)-1)
+		}
+	}
+
+	if failed > 0 {
+		result.Applied = false
+		result.Summary = fmt.Sprintf("Patch does not apply to %d of %d files; no files written", failed, len(files))
+		return result, nil
+	}
+
+	action := "Would apply"
+	if !e.opts.DryRun {
+		action = "Applied"
+		for i, c := range changes {
+			// Re-check at write time: writeAtomic follows symlinks to their target
+			err := e.opts.Sandbox.Check(c.Path)
+			if err == nil {
+				err = writeAtomic(fsys, c.Path, c.After)
+			}
+			if err != nil {
+				result.Files[sections[i]].Error = fmt.Sprintf("failed to write file: %v", err)
+				result.Applied = false
+				result.Summary = fmt.Sprintf("Patch failed while writing %s after %d of %d files", c.Path, i, len(changes))
+				return result, nil
+			}
+			if e.opts.OnChange != nil {
+				e.opts.OnChange(c)
+			}
+		}
+	} else if e.opts.OnChange != nil {
+		for _, c := range changes {
+			e.opts.OnChange(c)
+		}
+	}
+
+	fileWord := "files"
+	if len(changes) == 1 {
+		fileWord = "file"
+	}
+	hunkWord := "hunks"
+	if hunks == 1 {
+		hunkWord = "hunk"
+	}
+	result.Summary = fmt.Sprintf("%s %d %s to %d %s", action, hunks, hunkWord, len(changes), fileWord)
+	return result, nil
+}
+
+// patchFile applies fp to its file under dir, as read returns it, without
+// writing it. The change is nil unless every hunk applied.
+func (e *Engine) patchFile(read func(path string) ([]byte, error), dir string, fp FilePatch) (PatchedFile, *FileChange) {
+	pf := PatchedFile{Path: fp.NewName, Hunks: make([]HunkResult, 0, len(fp.Hunks))}
+	fail := func(format string, args ...any) (PatchedFile, *FileChange) {
+		pf.Error = fmt.Sprintf(format, args...)
+		return pf, nil
+	}
+
+	switch {
+	case fp.OldName == devNull:
+		return fail("creating files is not supported")
+	case fp.NewName == devNull:
+		pf.Path = fp.OldName
+		return fail("deleting files is not supported")
+	}
+	oldName, newName := fp.OldName, fp.NewName
+	if strings.HasPrefix(oldName, "a/") && strings.HasPrefix(newName, "b/") {
+		oldName, newName = oldName[2:], newName[2:]
+	}
+	if oldName != newName {
+		return fail("renaming files is not supported (%s to %s)", fp.OldName, fp.NewName)
+	}
+	if !filepath.IsLocal(filepath.FromSlash(newName)) {
+		return fail("path escapes %s", dir)
+	}
+	path := filepath.Join(dir, filepath.FromSlash(newName))
+	pf.Path = path
+
+	if err := e.opts.Sandbox.Check(path); err != nil {
+		return fail("%v", err)
+	}
+	data, err := read(path)
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	lines, ok := applyHunks(splitLines(string(data)), fp.Hunks, &pf.Hunks)
+	if !ok {
+		return pf, nil
+	}
+	return pf, &FileChange{Path: path, Before: data, After: []byte(strings.Join(lines, ""))}
+}
+
+// applyHunks applies hunks in order to lines (as split by splitLines),
+// appending each outcome to results, and reports whether all applied.
+func applyHunks(lines []string, hunks []Hunk, results *[]HunkResult) ([]string, bool) {
+	out := append([]string(nil), lines...)
+	eol := "\n"
+	if len(lines) > 0 && strings.HasSuffix(lines[0], "\r\n") {
+		eol = "\r\n"
+	}
+
+	allOK := true
+	delta := 0 // where the original line numbers now are in out
+	floor := 0 // hunks apply in order and must not overlap
+	for k, h := range hunks {
+		res, ok := HunkResult{Hunk: k + 1, Error: "context does not match"}, false
+		leading, trailing := contextRun(h.Lines, false), contextRun(h.Lines, true)
+		oldLen := 0
+		for _, l := range h.Lines {
+			if l[0] != '+' {
+				oldLen++
+			}
+		}
+
+		for fuzz := 0; fuzz <= maxPatchFuzz && !ok; fuzz++ {
+			skipHead, skipTail := min(fuzz, leading), min(fuzz, trailing)
+			if fuzz > 0 && skipHead < fuzz && skipTail < fuzz {
+				break // no more context to ignore
+			}
+			body := h.Lines[skipHead : len(h.Lines)-skipTail]
+			var old []string
+			for _, l := range body {
+				if l[0] != '+' {
+					old = append(old, l[1:])
+				}
+			}
+			if len(old) == 0 && oldLen > 0 {
+				break // nothing left to anchor the hunk
+			}
+
+			// A hunk without old lines inserts after the line in its header
+			expected := h.OldStart - 1 + delta + skipHead
+			origEnd := h.OldStart - 1 + oldLen
+			if oldLen == 0 {
+				expected, origEnd = h.OldStart+delta, h.OldStart
+			}
+			pos := findLines(out, old, expected, floor)
+			if pos < 0 {
+				continue
+			}
+
+			var replacement []string
+			j := pos
+			for _, l := range body {
+				switch l[0] {
+				case ' ':
+					replacement = append(replacement, out[j])
+					j++
+				case '-':
+					j++
+				case '+':
+					added := l[1:]
+					if text, ok := strings.CutSuffix(added, "\n"); ok {
+						added = strings.TrimSuffix(text, "\r") + eol
+					}
+					replacement = append(replacement, added)
+				}
+			}
+			out = append(out[:pos], append(replacement, out[pos+len(old):]...)...)
+
+			ok = true
+			res = HunkResult{Hunk: k + 1, Applied: true, Offset: pos - expected, Fuzz: fuzz}
+			res.Line = h.OldStart + res.Offset
+			floor = pos + len(replacement)
+			delta = floor + skipTail - origEnd
+		}
+		if !ok {
+			allOK = false
+		}
+		*results = append(*results, res)
+	}
+
+	// An unterminated line can only be last
+	for i := 0; i < len(out)-1; i++ {
+		if !strings.HasSuffix(out[i], "\n") {
+			out[i] += eol
+		}
+	}
+	return out, allOK
+}
+
+// contextRun counts the context lines at the start of a hunk body, or at its
+// end if fromEnd is set.
+func contextRun(body []string, fromEnd bool) int {
+	n := 0
+	for n < len(body) {
+		l := body[n]
+		if fromEnd {
+			l = body[len(body)-1-n]
+		}
+		if l[0] != ' ' {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// findLines returns the index of old in lines closest to expected and not
+// before floor, or -1. Lines are compared without their terminators, so a
+// patch with other line endings than the file still applies. An empty old
+// matches at expected, kept within range.
+func findLines(lines, old []string, expected, floor int) int {
+	last := len(lines) - len(old)
+	if len(old) == 0 {
+		return min(max(expected, floor), len(lines))
+	}
+	matchesAt := func(pos int) bool {
+		for i, l := range old {
+			if trimEOL(lines[pos+i]) != trimEOL(l) {
+				return false
+			}
+		}
+		return true
+	}
+	for d := 0; expected-d >= floor || expected+d <= last; d++ {
+		if p := expected - d; p >= floor && p <= last && matchesAt(p) {
+			return p
+		}
+		if p := expected + d; d > 0 && p >= floor && p <= last && matchesAt(p) {
+			return p
+		}
+	}
+	return -1
+}
+
+func trimEOL(line string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+}
@@ -0,0 +1,307 @@
+package repfor
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGitPatch(t *testing.T) {
+	root := t.TempDir()
+	changes := []FileChange{
+		{Path: filepath.Join(root, "sub", "a.go"), Before: []byte("foo\nbar\n"), After: []byte("baz\nbar\n")},
+		{Path: filepath.Join(root, "same.txt"), Before: []byte("x\n"), After: []byte("x\n")},
+		{Path: filepath.Join(root, "b c.txt"), Before: []byte("foo"), After: []byte("baz")},
+	}
+
+	patch, err := GitPatch(root, changes)
+	if err != nil {
+		t.Fatalf("GitPatch failed: %v", err)
+	}
+	want := "diff --git a/sub/a.go b/sub/a.go\n" +
+		"--- a/sub/a.go\n" +
+		"+++ b/sub/a.go\n" +
+		"@@ -1,2 +1,2 @@\n" +
+		"-foo\n" +
+		"+baz\n" +
+		" bar\n" +
+		"diff --git a/b c.txt b/b c.txt\n" +
+		"--- a/b c.txt\t\n" +
+		"+++ b/b c.txt\t\n" +
+		"@@ -1 +1 @@\n" +
+		"-foo\n" +
+		"\\ No newline at end of file\n" +
+		"+baz\n" +
+		"\\ No newline at end of file\n"
+	if patch != want {
+		t.Errorf("patch:\n%s\nwant:\n%s", patch, want)
+	}
+
+	_, err = GitPatch(filepath.Join(root, "sub"), changes)
+	if err == nil || !strings.Contains(err.Error(), "outside the patch root") {
+		t.Errorf("Expected error for a file outside the root, got %v", err)
+	}
+}
+
+func TestQuoteGitPath(t *testing.T) {
+	tests := map[string]string{
+		"a/plain.go":    "a/plain.go",
+		"a/héllo wörld": "a/héllo wörld",
+		"a/tab\there":   `"a/tab\there"`,
+		`a/say "hi"`:    `"a/say \"hi\""`,
+		"a/bell\x01":    `"a/bell\001"`,
+		`a/back\slash`:  `"a/back\\slash"`,
+	}
+	for name, want := range tests {
+		if got := quoteGitPath(name); got != want {
+			t.Errorf("quoteGitPath(%q) = %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestParsePatch(t *testing.T) {
+	patch := "From 1234 Mon Sep 17 00:00:00 2001\n" +
+		"Subject: [PATCH] change\n" +
+		"---\n" +
+		"diff --git a/a.go b/a.go\n" +
+		"index 1234..5678 100644\n" +
+		"--- a/a.go\n" +
+		"+++ b/a.go\n" +
+		"@@ -1,2 +1,2 @@\n" +
+		"-foo\n" +
+		"+bar\n" +
+		" end\n" +
+		"diff --git \"a/tab\\there\" \"b/tab\\there\"\n" +
+		"--- \"a/tab\\there\"\n" +
+		"+++ \"b/tab\\there\"\n" +
+		"@@ -1 +1 @@\n" +
+		"-x\n" +
+		"\\ No newline at end of file\n" +
+		"+y\n" +
+		"-- \n" +
+		"2.40.0\n"
+
+	files, err := ParsePatch([]byte(patch))
+	if err != nil {
+		t.Fatalf("ParsePatch failed: %v", err)
+	}
+	want := []FilePatch{
+		{OldName: "a/a.go", NewName: "b/a.go", Hunks: []Hunk{{OldStart: 1, NewStart: 1, Lines: []string{"-foo\n", "+bar\n", " end\n"}}}},
+		{OldName: "a/tab\there", NewName: "b/tab\there", Hunks: []Hunk{{OldStart: 1, NewStart: 1, Lines: []string{"-x", "+y\n"}}}},
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("ParsePatch = %#v\nwant %#v", files, want)
+	}
+
+	// Hunk counts that are too small do not cut the hunk short
+	files, err = ParsePatch([]byte("--- a.txt\t2024-01-01\n+++ a.txt\t2024-01-02\n@@ -1,1 +1,1 @@\n a\n-b\n+c\n"))
+	if err != nil {
+		t.Fatalf("ParsePatch failed: %v", err)
+	}
+	if got := files[0].Hunks[0].Lines; !reflect.DeepEqual(got, []string{" a\n", "-b\n", "+c\n"}) || files[0].NewName != "a.txt" {
+		t.Errorf("miscounted hunk = %q (%s)", got, files[0].NewName)
+	}
+
+	for _, bad := range []string{"just text\n", "--- a\n+++ b\n@@ bogus @@\n", "--- a\n+++ b\n"} {
+		if _, err := ParsePatch([]byte(bad)); err == nil {
+			t.Errorf("Expected error for %q", bad)
+		}
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	before := "package x\n\nfunc a() {\n\tfoo()\n}\n\nfunc b() {\n\tfoo()\n}\n"
+	after := "package x\n\nfunc a() {\n\tbar()\n}\n\nfunc b() {\n\tbar()\n\tbaz()\n}\n"
+	patch, err := GitPatch("proj", []FileChange{{Path: "proj/x.go", Before: []byte(before), After: []byte(after)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		content string // the file the patch is applied to
+		want    string
+		hunks   []HunkResult
+	}{
+		{"exact", before, after, []HunkResult{{Hunk: 1, Applied: true, Line: 1}}},
+		{
+			"offset",
+			"// header\n// more\n" + before,
+			"// header\n// more\n" + after,
+			[]HunkResult{{Hunk: 1, Applied: true, Line: 3, Offset: 2}},
+		},
+		{
+			"fuzz",
+			strings.Replace(before, "package x", "package y", 1),
+			strings.Replace(after, "package x", "package y", 1),
+			[]HunkResult{{Hunk: 1, Applied: true, Line: 1, Fuzz: 1}},
+		},
+		{
+			"crlf file",
+			strings.ReplaceAll(before, "\n", "\r\n"),
+			strings.ReplaceAll(after, "\n", "\r\n"),
+			[]HunkResult{{Hunk: 1, Applied: true, Line: 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := newMemTree(t, map[string]string{"proj/x.go": tt.content})
+			var changes []FileChange
+			result, err := New(Options{FS: mem, OnChange: func(c FileChange) { changes = append(changes, c) }}).
+				ApplyPatch(context.Background(), "proj", []byte(patch))
+			if err != nil {
+				t.Fatalf("ApplyPatch failed: %v", err)
+			}
+			if !result.Applied || result.Summary != "Applied 1 hunk to 1 file" {
+				t.Fatalf("result = %+v", result)
+			}
+			if !reflect.DeepEqual(result.Files[0].Hunks, tt.hunks) {
+				t.Errorf("hunks = %+v, want %+v", result.Files[0].Hunks, tt.hunks)
+			}
+			if data, _ := mem.ReadFile("proj/x.go"); string(data) != tt.want {
+				t.Errorf("content = %q, want %q", data, tt.want)
+			}
+			if len(changes) != 1 || string(changes[0].Before) != tt.content {
+				t.Errorf("OnChange got %d changes", len(changes))
+			}
+		})
+	}
+}
+
+func TestApplyPatch_MultipleHunks(t *testing.T) {
+	var before, after strings.Builder
+	for i := 1; i <= 30; i++ {
+		fmt.Fprintf(&before, "line %d\n", i)
+		switch i {
+		case 5:
+			after.WriteString("five\n")
+		case 25:
+			after.WriteString("twenty-five\nextra\n")
+		default:
+			fmt.Fprintf(&after, "line %d\n", i)
+		}
+	}
+	patch := UnifiedDiff("a/f.txt", "b/f.txt", []byte(before.String()), []byte(after.String()))
+
+	// Lines added above the second hunk shift it further than the first
+	content := strings.Replace(before.String(), "line 15\n", "line 15\nnew 1\nnew 2\n", 1)
+	mem := newMemTree(t, map[string]string{"d/f.txt": content})
+	result, err := New(Options{FS: mem}).ApplyPatch(context.Background(), "d", []byte(patch))
+	if err != nil {
+		t.Fatalf("ApplyPatch failed: %v", err)
+	}
+	want := []HunkResult{{Hunk: 1, Applied: true, Line: 2}, {Hunk: 2, Applied: true, Line: 24, Offset: 2}}
+	if !reflect.DeepEqual(result.Files[0].Hunks, want) {
+		t.Errorf("hunks = %+v, want %+v", result.Files[0].Hunks, want)
+	}
+	wantContent := strings.Replace(after.String(), "line 15\n", "line 15\nnew 1\nnew 2\n", 1)
+	if data, _ := mem.ReadFile("d/f.txt"); string(data) != wantContent {
+		t.Errorf("content = %q", data)
+	}
+}
+
+func TestApplyPatch_RepeatedFile(t *testing.T) {
+	// The second section for f.txt applies on top of the first
+	first := UnifiedDiff("a/f.txt", "b/f.txt", []byte("a\nb\nc\n"), []byte("A\nb\nc\n"))
+	second := UnifiedDiff("a/f.txt", "b/f.txt", []byte("A\nb\nc\n"), []byte("A\nb\nC\n"))
+	mem := newMemTree(t, map[string]string{"d/f.txt": "a\nb\nc\n"})
+	var changes []FileChange
+	opts := Options{FS: mem, OnChange: func(c FileChange) { changes = append(changes, c) }}
+	result, err := New(opts).ApplyPatch(context.Background(), "d", []byte(first+second))
+	if err != nil {
+		t.Fatalf("ApplyPatch failed: %v", err)
+	}
+	if !result.Applied || result.Summary != "Applied 2 hunks to 1 file" {
+		t.Errorf("result = %+v", result)
+	}
+	if data, _ := mem.ReadFile("d/f.txt"); string(data) != "A\nb\nC\n" {
+		t.Errorf("content = %q, want both sections applied", data)
+	}
+	if len(changes) != 1 || string(changes[0].Before) != "a\nb\nc\n" {
+		t.Errorf("changes = %+v, want one change from the original", changes)
+	}
+
+	// A section that only fits the original fails once an earlier one changed it
+	mem = newMemTree(t, map[string]string{"d/f.txt": "a\nb\nc\n"})
+	result, err = New(Options{FS: mem}).ApplyPatch(context.Background(), "d", []byte(first+first))
+	if err != nil {
+		t.Fatalf("ApplyPatch failed: %v", err)
+	}
+	if result.Applied {
+		t.Error("Expected the repeated section to fail")
+	}
+	if data, _ := mem.ReadFile("d/f.txt"); string(data) != "a\nb\nc\n" {
+		t.Errorf("content = %q, want unchanged", data)
+	}
+}
+
+func TestApplyPatch_Rejected(t *testing.T) {
+	files := map[string]string{"d/a.txt": "one\ntwo\n", "d/b.txt": "three\n"}
+	tests := []struct {
+		name  string
+		patch string
+		err   string // file error, or "" for a failed hunk
+	}{
+		{"context mismatch", "--- a/b.txt\n+++ b/b.txt\n@@ -1 +1 @@\n-four\n+five\n", ""},
+		{"missing file", "--- a/c.txt\n+++ b/c.txt\n@@ -1 +1 @@\n-x\n+y\n", "file does not exist"},
+		{"escape", "--- a/../x\n+++ b/../x\n@@ -1 +1 @@\n-x\n+y\n", "path escapes"},
+		{"create", "--- /dev/null\n+++ b/c.txt\n@@ -0,0 +1 @@\n+new\n", "creating files"},
+		{"delete", "--- a/b.txt\n+++ /dev/null\n@@ -1 +0,0 @@\n-three\n", "deleting files"},
+		{"rename", "--- a/b.txt\n+++ b/c.txt\n@@ -1 +1 @@\n-three\n+3\n", "renaming files"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := newMemTree(t, files)
+			// The valid change to a.txt must not be written either
+			patch := "--- a/a.txt\n+++ b/a.txt\n@@ -1,2 +1,2 @@\n-one\n+1\n two\n" + tt.patch
+			result, err := New(Options{FS: mem}).ApplyPatch(context.Background(), "d", []byte(patch))
+			if err != nil {
+				t.Fatalf("ApplyPatch failed: %v", err)
+			}
+			if result.Applied || result.Summary != "Patch does not apply to 1 of 2 files; no files written" {
+				t.Errorf("result = %+v", result)
+			}
+			got := result.Files[1]
+			if tt.err == "" {
+				if len(got.Hunks) != 1 || got.Hunks[0].Applied || got.Hunks[0].Error == "" {
+					t.Errorf("hunks = %+v", got.Hunks)
+				}
+			} else if !strings.Contains(got.Error, tt.err) {
+				t.Errorf("file error = %q, want %q", got.Error, tt.err)
+			}
+			if !reflect.DeepEqual(mem.files(), []string{"d/a.txt", "d/b.txt"}) {
+				t.Errorf("files = %v", mem.files())
+			}
+			if data, _ := mem.ReadFile("d/a.txt"); string(data) != "one\ntwo\n" {
+				t.Errorf("a.txt written despite failure: %q", data)
+			}
+		})
+	}
+}
+
+func TestApplyPatch_DryRunAndSandbox(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.txt", "old\n")
+	patch := []byte("--- a/a.txt\n+++ b/a.txt\n@@ -1 +1 @@\n-old\n+new\n")
+
+	result, err := New(Options{DryRun: true}).ApplyPatch(context.Background(), tmpDir, patch)
+	if err != nil || !result.Applied || result.Summary != "Would apply 1 hunk to 1 file" {
+		t.Fatalf("dry run = %+v, %v", result, err)
+	}
+	if content := readFileContent(t, path); content != "old\n" {
+		t.Errorf("dry run modified the file: %q", content)
+	}
+
+	sandbox, err := NewSandbox([]string{t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = New(Options{Sandbox: sandbox}).ApplyPatch(context.Background(), tmpDir, patch)
+	if err != nil || result.Applied || result.Files[0].Error == "" {
+		t.Errorf("patch outside sandbox = %+v, %v", result, err)
+	}
+}
@@ -0,0 +1,94 @@
+package repfor
+
+import "strings"
+
+// detectIndent returns the unit text is indented by: a tab, two or four
+// spaces, or "" when no line is indented. Lines indented by a single space
+// are taken for alignment (" * " in block comments) and ignored.
+func detectIndent(text string) string {
+	tabs, spaces, by2, by4 := 0, 0, 0, 0
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		switch w := len(line) - len(strings.TrimLeft(line, " ")); {
+		case strings.HasPrefix(line, "\t"):
+			tabs++
+		case w >= 2:
+			spaces++
+			if w%2 == 0 {
+				by2++
+			}
+			if w%4 == 0 {
+				by4++
+			}
+		}
+	}
+	switch {
+	case tabs == 0 && spaces == 0:
+		return ""
+	case tabs >= spaces:
+		return "\t"
+	case by4 > 0 && by4 == by2:
+		return "    "
+	}
+	return "  "
+}
+
+// leadingSpace returns the spaces and tabs line starts with.
+func leadingSpace(line string) string {
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// indentLevels counts the levels of indentation in ws, a run of spaces and
+// tabs. A tab is one level; spaces count by the width of unit, or four
+// when unit is a tab or unknown.
+func indentLevels(ws, unit string) int {
+	size := 4
+	if unit != "" && unit != "\t" {
+		size = len(unit)
+	}
+	return strings.Count(ws, "\t") + strings.Count(ws, " ")/size
+}
+
+// reindent re-bases replace, the replacement for the match at offset start
+// of content, onto the indentation of the line the match starts on. Each
+// line after the first keeps its indentation relative to the first line,
+// rendered in fileUnit (the file's indent unit); replaceUnit is the unit
+// replace itself is indented by. When the match takes in the indentation of
+// its line, the first line is given that indentation as well.
+func reindent(content string, start int, replace, fileUnit, replaceUnit string) string {
+	lines := strings.Split(replace, "\n")
+	if len(lines) == 1 {
+		return replace
+	}
+	lineStart := strings.LastIndexByte(content[:start], '\n') + 1
+	target := leadingSpace(content[lineStart:])
+	unit := fileUnit
+	if unit == "" {
+		unit = replaceUnit
+	}
+	if unit == "" {
+		unit = "\t"
+	}
+
+	base := indentLevels(leadingSpace(lines[0]), replaceUnit)
+	if k := start - lineStart; k <= len(target) {
+		lines[0] = target[k:] + strings.TrimLeft(lines[0], " \t")
+	}
+	for i := 1; i < len(lines); i++ {
+		text := strings.TrimLeft(lines[i], " \t")
+		if strings.TrimRight(text, "\r") == "" {
+			lines[i] = text // no trailing whitespace on blank lines
+			continue
+		}
+		rel := indentLevels(leadingSpace(lines[i]), replaceUnit) - base
+		if rel >= 0 {
+			// Keep the target verbatim, alignment included
+			lines[i] = target + strings.Repeat(unit, rel) + text
+		} else {
+			lines[i] = strings.Repeat(unit, max(indentLevels(target, unit)+rel, 0)) + text
+		}
+	}
+	return strings.Join(lines, "\n")
+}
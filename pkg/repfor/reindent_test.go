@@ -0,0 +1,111 @@
+package repfor
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestDetectIndent(t *testing.T) {
+	tests := map[string]string{
+		"a\n\tb\n\t\tc\n":                "\t",
+		"a\n    b\n        c\n":          "    ",
+		"a\n  b\n    c\n":                "  ",
+		"/*\n * a\n */\n":                "",
+		"a\n\tb\n    c\n\td\n":           "\t",
+		"a\n    b\n      c\n    d\n":     "  ",
+		"no indentation\nat all\n\n  \n": "",
+	}
+	for text, want := range tests {
+		if got := detectIndent(text); got != want {
+			t.Errorf("detectIndent(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestReindent(t *testing.T) {
+	const replace = "if err != nil {\n    return fmt.Errorf(\"f: %w\", err)\n}"
+	tests := []struct {
+		name, content, search, want string
+	}{
+		{
+			"tabs",
+			"func f() {\n\tif x {\n\t\treturn err\n\t}\n}\n",
+			"return err",
+			"func f() {\n\tif x {\n\t\tif err != nil {\n\t\t\treturn fmt.Errorf(\"f: %w\", err)\n\t\t}\n\t}\n}\n",
+		},
+		{
+			"two spaces",
+			"function f() {\n  if (x) {\n    return err\n  }\n}\n",
+			"return err",
+			"function f() {\n  if (x) {\n    if err != nil {\n      return fmt.Errorf(\"f: %w\", err)\n    }\n  }\n}\n",
+		},
+		{
+			"match takes in the indentation",
+			"func f() {\n\t\treturn err\n}\n",
+			"\t\treturn err",
+			"func f() {\n\t\tif err != nil {\n\t\t\treturn fmt.Errorf(\"f: %w\", err)\n\t\t}\n}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := setupTestDir(t)
+			defer cleanupTestDir(t, tmpDir)
+			path := createTestFile(t, tmpDir, "a.go", tt.content)
+
+			opts := Options{Files: []string{path}, Search: tt.search, Replace: replace, Reindent: true, CollectMatches: true}
+			result, err := New(opts).Run(context.Background())
+			if err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			got := readFileContent(t, path)
+			if got != tt.want {
+				t.Errorf("content = %q\nwant      %q", got, tt.want)
+			}
+			// The match records the re-based text
+			m := result.Directories[0].Files[0].Matches[0]
+			if !strings.Contains(got, m.Replacement) || m.Replacement == replace {
+				t.Errorf("match replacement = %q, not the reindented text", m.Replacement)
+			}
+		})
+	}
+}
+
+func TestReindent_Outdent(t *testing.T) {
+	// Lines less indented than the first come out less indented than the match
+	content := "\t\tfoo(a)\n"
+	got := reindent(content, 2, "    foo(\n        a,\n    )\nbar()", "\t", "    ")
+	if want := "foo(\n\t\t\ta,\n\t\t)\n\tbar()"; got != want {
+		t.Errorf("reindent = %q, want %q", got, want)
+	}
+}
+
+func TestReindent_WithIgnoreWhitespaceAndConfirm(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.go", "func f() {\r\n\tif a {\r\n\t\tb()\r\n\r\n\t}\r\n}\r\n")
+
+	// The proposal shows the replacement as it will be written
+	var proposed []string
+	opts := Options{
+		Files:            []string{path},
+		Search:           "if a {\n    b()\n\n}",
+		Replace:          "if a {\n    b()\n\n    c()\n}",
+		IgnoreWhitespace: true,
+		Reindent:         true,
+		Confirm: func(p Proposal) (string, bool) {
+			proposed = append(proposed, p.Replacement)
+			return p.Replacement, true
+		},
+	}
+	if _, err := New(opts).Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	want := "if a {\r\n\t\tb()\r\n\r\n\t\tc()\r\n\t}"
+	if len(proposed) != 1 || proposed[0] != want {
+		t.Errorf("proposed %q, want %q", proposed, want)
+	}
+	if got := readFileContent(t, path); got != "func f() {\r\n\t"+want+"\r\n}\r\n" {
+		t.Errorf("content = %q", got)
+	}
+}
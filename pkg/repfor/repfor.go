@@ -0,0 +1,1100 @@
+// Package repfor performs literal (non-regex) search and replace across files.
+//
+// A run is described by Options and executed with New(opts).Run(ctx). Files
+// are rewritten atomically (temp file + rename), keeping their line endings
+// and permissions; DryRun reports what would change without writing.
+//
+// All file access goes through Options.FS: the OS filesystem by default, or
+// a MemFS or OverlayFS to run against memory or preview changes.
+package repfor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// FileModification reports the changes made (or, in dry-run mode, that would
+// be made) to one file.
+type FileModification struct {
+	Path         string  `json:"path"`
+	LinesChanged int     `json:"lines_changed"`
+	Replacements int     `json:"replacements"`
+	Matches      []Match `json:"matches,omitempty"`   // only with Options.CollectMatches
+	Truncated    bool    `json:"truncated,omitempty"` // a replacement limit left matches unchanged
+}
+
+// Match locates one occurrence of the search string. Line and Column are
+// 1-based; Column counts characters (runes), not bytes.
+type Match struct {
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Match  string `json:"match"` // the matched text as it appears in the file
+	Text   string `json:"text"`  // the full line containing the start of the match
+	// Replacement is the text put in place of the match, which differs from
+	// Options.Replace when Confirm edited it or Reindent re-based it. Empty
+	// when searching.
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// DirectoryResult totals the modifications within one directory, or within
+// the explicit file list in file mode.
+type DirectoryResult struct {
+	Dir               string             `json:"dir"`
+	FilesModified     int                `json:"files_modified"`
+	LinesChanged      int                `json:"lines_changed"`
+	TotalReplacements int                `json:"total_replacements"`
+	Files             []FileModification `json:"files"`
+
+	warnings []Warning // moved to Result.Warnings
+}
+
+// Result is the outcome of a run.
+type Result struct {
+	Summary     string            `json:"summary"`
+	Directories []DirectoryResult `json:"directories"`
+	Warnings    []Warning         `json:"warnings,omitempty"`
+	DryRun      bool              `json:"dry_run,omitempty"`
+	Truncated   bool              `json:"truncated,omitempty"` // a replacement limit left matches unchanged
+}
+
+// Warning reports a path the run skipped because it could not be read or
+// processed. Warnings are also written to stderr.
+type Warning struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// Options configures a run. Search is required; Dirs defaults to the current
+// directory and is ignored when Files is set.
+//
+// Options marshal to JSON as a record of what a run did; the fields that
+// only wire the run up (filesystem, callbacks, reporting) are left out.
+type Options struct {
+	Dirs            []string  `json:"dir,omitempty"`
+	Files           []string  `json:"file,omitempty"` // file mode (takes precedence over Dirs)
+	Search          string    `json:"search"`
+	Replace         string    `json:"replace"`
+	Ext             string    `json:"ext,omitempty"`
+	ExcludeFiles    []string  `json:"exclude_files,omitempty"`
+	ExcludeLines    []string  `json:"exclude_lines,omitempty"`
+	IncludeLines    []string  `json:"include_lines,omitempty"`  // only lines containing one of these
+	IncludeRegexp   bool      `json:"include_regexp,omitempty"` // IncludeLines are regular expressions
+	CaseInsensitive bool      `json:"case_insensitive,omitempty"`
+	WholeWord       bool      `json:"whole_word,omitempty"`
+	Reindent        bool      `json:"reindent,omitempty"` // re-base a multiline Replace onto the indentation of each match
+	DryRun          bool      `json:"dry_run,omitempty"`
+	Recursive       bool      `json:"recursive,omitempty"`
+	GitTracked      bool      `json:"git_tracked,omitempty"`       // only files tracked by git in each of Dirs
+	GitChangedSince string    `json:"git_changed_since,omitempty"` // only files changed since this git ref in each of Dirs
+	LineRange       LineRange `json:"line_range"`                  // only lines in this range (file mode only)
+	BetweenStart    string    `json:"between_start,omitempty"`     // only lines between a line containing this...
+	BetweenEnd      string    `json:"between_end,omitempty"`       // ...and the next line containing this
+	Outside         bool      `json:"outside,omitempty"`           // only lines outside the Between regions instead
+	ContextBefore   string    `json:"context_before,omitempty"`    // only matches with a line containing this in the ContextWindow lines above
+	ContextAfter    string    `json:"context_after,omitempty"`     // only matches with a line containing this in the ContextWindow lines below
+	ContextWindow   int       `json:"context_window,omitempty"`    // lines searched for ContextBefore and ContextAfter (0 means 1)
+	Scope           string    `json:"scope,omitempty"`             // ScopeCode, ScopeComments or ScopeStrings: only matches in those tokens
+	Language        string    `json:"language,omitempty"`          // language of the files for Scope (default: by file extension)
+	Verbose         bool      `json:"-"`                           // report each modified file on stderr
+	CollectMatches  bool      `json:"-"`                           // record the location of every match in FileModification.Matches
+	Sandbox         *Sandbox  `json:"-"`                           // path restriction on the OS filesystem (nil means unrestricted)
+	FS              FS        `json:"-"`                           // filesystem to read and write (nil means OSFS)
+	// IgnoreWhitespace lets each run of spaces and tabs in Search match any
+	// run of them, and each line break (with the spaces and tabs around it)
+	// match a line break however indented. IgnoreLineBreaks further lets any
+	// run of whitespace match any other, so re-wrapped text matches. Matches
+	// are reported in FileModification.Matches, as their text varies.
+	IgnoreWhitespace bool `json:"ignore_whitespace,omitempty"`
+	IgnoreLineBreaks bool `json:"ignore_line_breaks,omitempty"`
+	// Occurrence, if set, replaces only the Nth (1-based) match in each
+	// file. FirstOnly limits each file to its first replacement, and
+	// MaxReplacementsPerFile and MaxReplacements cap the replacements per
+	// file and per run; matches left by a cap mark the result Truncated.
+	Occurrence             int  `json:"occurrence,omitempty"`
+	FirstOnly              bool `json:"first_only,omitempty"`
+	MaxReplacementsPerFile int  `json:"max_replacements_per_file,omitempty"`
+	MaxReplacements        int  `json:"max_replacements,omitempty"`
+	// Confirm, if set, is asked about every match before it is replaced and
+	// returns the text to replace it with; ok false keeps the match.
+	Confirm func(p Proposal) (replacement string, ok bool) `json:"-"`
+	// OnChange, if set, is called for every file the run modifies (or would
+	// modify, in dry-run mode) with its content before and after.
+	OnChange func(FileChange) `json:"-"`
+	// OnFile, if set, is called as each file is done: modified, skipped for
+	// having no matches, or failed. Paths that could not be walked are
+	// reported as errors too.
+	OnFile func(FileEvent) `json:"-"`
+
+	budget  *runBudget     // replacements made so far in the run
+	include *includeFilter // IncludeLines, compiled
+}
+
+// FileChange is the content of one file before and after a replacement.
+type FileChange struct {
+	Path   string
+	Before []byte
+	After  []byte
+}
+
+// Engine performs one search-and-replace run over a set of files.
+type Engine struct {
+	opts Options
+}
+
+// New returns an engine for opts. Directories default to the current one.
+func New(opts Options) *Engine {
+	if len(opts.Dirs) == 0 {
+		opts.Dirs = []string{"."}
+	}
+	return &Engine{opts: opts}
+}
+
+// Run performs the replacement and returns per-directory statistics. It stops
+// between files once ctx is done, returning ctx.Err(); files already written
+// stay modified.
+func (e *Engine) Run(ctx context.Context) (*Result, error) {
+	if e.opts.Search == "" {
+		return nil, errors.New("search string is required")
+	}
+	if err := e.opts.validateScope(); err != nil {
+		return nil, err
+	}
+	if err := e.opts.validateLimits(); err != nil {
+		return nil, err
+	}
+	if err := e.opts.validateContext(); err != nil {
+		return nil, err
+	}
+	if err := e.opts.validateWhitespace(); err != nil {
+		return nil, err
+	}
+	// Line numbers only make sense for files chosen one by one
+	if e.opts.LineRange != (LineRange{}) && len(e.opts.Files) == 0 {
+		return nil, errors.New("a line range needs files, not directories")
+	}
+	opts := e.opts
+	var err error
+	if opts.include, err = newIncludeFilter(opts); err != nil {
+		return nil, err
+	}
+	return replaceInDirectories(ctx, opts)
+}
+
+func replaceInDirectories(ctx context.Context, config Options) (*Result, error) {
+	result := &Result{
+		Directories: make([]DirectoryResult, 0, len(config.Dirs)),
+		DryRun:      config.DryRun,
+	}
+	config.budget = &runBudget{}
+
+	// Reject arguments that resolve outside the sandbox before touching anything
+	targets := config.Dirs
+	if len(config.Files) > 0 {
+		targets = config.Files
+	}
+	for _, target := range targets {
+		if err := config.Sandbox.Check(target); err != nil {
+			return nil, err
+		}
+	}
+
+	// File mode takes precedence over directory mode
+	if len(config.Files) > 0 {
+		dirResult, err := replaceInFiles(ctx, config.Files, config)
+		if err != nil {
+			return nil, err
+		}
+		result.Directories = append(result.Directories, *dirResult)
+	} else if config.gitSelection() {
+		// Git selection replaces directory listing; filters still apply
+		for _, dir := range config.Dirs {
+			files, err := gitFiles(ctx, dir, config)
+			if err != nil {
+				return nil, err
+			}
+			dirResult, err := replaceInFiles(ctx, files, config)
+			if err != nil {
+				return nil, err
+			}
+			dirResult.Dir = dir
+			result.Directories = append(result.Directories, *dirResult)
+		}
+	} else {
+		// Collect all directories to process
+		dirsToProcess := config.Dirs
+		if config.Recursive {
+			var warnings []Warning
+			dirsToProcess, warnings = collectDirectoriesRecursive(config.fsys(), config.Dirs)
+			result.Warnings = append(result.Warnings, warnings...)
+			for _, w := range warnings {
+				config.reportWarning(w)
+			}
+		}
+
+		for _, dir := range dirsToProcess {
+			dirResult, err := replaceInDirectory(ctx, dir, config)
+			if err != nil {
+				return nil, err
+			}
+			result.Directories = append(result.Directories, *dirResult)
+		}
+	}
+
+	for _, dirResult := range result.Directories {
+		result.Warnings = append(result.Warnings, dirResult.warnings...)
+	}
+
+	// Generate summary
+	totalFiles := 0
+	totalLines := 0
+	totalReplacements := 0
+	dirsWithChanges := 0
+
+	for _, dirResult := range result.Directories {
+		totalFiles += dirResult.FilesModified
+		totalLines += dirResult.LinesChanged
+		totalReplacements += dirResult.TotalReplacements
+		if dirResult.FilesModified > 0 {
+			dirsWithChanges++
+		}
+	}
+
+	// Build summary string
+	var action string
+	if config.DryRun {
+		action = "Would modify"
+	} else {
+		action = "Modified"
+	}
+
+	fileWord := "file"
+	if totalFiles != 1 {
+		fileWord = "files"
+	}
+
+	lineWord := "line"
+	if totalLines != 1 {
+		lineWord = "lines"
+	}
+
+	replacementWord := "replacement"
+	if totalReplacements != 1 {
+		replacementWord = "replacements"
+	}
+
+	var dirInfo string
+	if len(config.Dirs) > 1 {
+		dirWord := "directory"
+		if dirsWithChanges != 1 {
+			dirWord = "directories"
+		}
+		dirInfo = fmt.Sprintf(" across %d %s", dirsWithChanges, dirWord)
+	}
+
+	result.Summary = fmt.Sprintf("%s %d %s%s: %d %s in %d %s",
+		action, totalFiles, fileWord, dirInfo, totalReplacements, replacementWord, totalLines, lineWord)
+	if config.budget.truncated {
+		result.Truncated = true
+		result.Summary += " (stopped at the replacement limit)"
+	}
+
+	return result, nil
+}
+
+// collectDirectoriesRecursive walks the given directories and returns all directories
+// including subdirectories, and warnings for the paths it could not access. The
+// input directories are included in the result.
+func collectDirectoriesRecursive(fsys FS, dirs []string) ([]string, []Warning) {
+	var allDirs []string
+	var warnings []Warning
+	seen := make(map[string]bool)
+
+	for _, dir := range dirs {
+		err := fs.WalkDir(fsys, dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				warnings = append(warnings, newWarning(path, "failed to access", err))
+				return nil // Continue walking despite errors
+			}
+			if d.IsDir() {
+				// Use cleaned path to avoid duplicates
+				cleanPath := filepath.Clean(path)
+				if !seen[cleanPath] {
+					seen[cleanPath] = true
+					allDirs = append(allDirs, cleanPath)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			warnings = append(warnings, newWarning(dir, "failed to walk directory", err))
+		}
+	}
+
+	return allDirs, warnings
+}
+
+// newWarning builds a Warning and reports it on stderr.
+func newWarning(path, what string, err error) Warning {
+	fmt.Fprintf(os.Stderr, "Warning: %s %s: %v\n", what, path, err)
+	return Warning{Path: path, Message: fmt.Sprintf("%s: %v", what, err)}
+}
+
+// warn records a path skipped in d and reports it to config.OnFile.
+func (d *DirectoryResult) warn(config Options, path, what string, err error) {
+	w := newWarning(path, what, err)
+	d.warnings = append(d.warnings, w)
+	config.reportWarning(w)
+}
+
+func shouldExcludeFile(filename string, patterns []string, caseInsensitive bool) bool {
+	for _, pattern := range patterns {
+		if containsMatch(filename, pattern, caseInsensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+func replaceInDirectory(ctx context.Context, dir string, config Options) (*DirectoryResult, error) {
+	entries, err := fs.ReadDir(config.fsys(), dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	dirResult := &DirectoryResult{
+		Dir:   dir,
+		Files: make([]FileModification, 0),
+	}
+
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if entry.IsDir() {
+			continue
+		}
+
+		// Skip non-regular files (FIFOs, devices, sockets, etc.)
+		info, err := entry.Info()
+		if err != nil {
+			dirResult.warn(config, filepath.Join(dir, entry.Name()), "failed to get file info for", err)
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		filename := entry.Name()
+
+		if config.Ext != "" && !strings.HasSuffix(filename, config.Ext) {
+			continue
+		}
+
+		if shouldExcludeFile(filename, config.ExcludeFiles, config.CaseInsensitive) {
+			continue
+		}
+
+		fullPath := filepath.Join(dir, filename)
+		mod, err := processFile(fullPath, config)
+		if err != nil {
+			dirResult.warn(config, fullPath, "failed to process", err)
+			continue
+		}
+		config.report(fullPath, mod)
+
+		if mod != nil {
+			mod.Path = filename
+			dirResult.add(*mod)
+		}
+	}
+
+	return dirResult, nil
+}
+
+func replaceInFiles(ctx context.Context, filePaths []string, config Options) (*DirectoryResult, error) {
+	dirResult := &DirectoryResult{
+		Dir:   "(files)",
+		Files: make([]FileModification, 0, len(filePaths)),
+	}
+
+	for _, filePath := range filePaths {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		// Verify file exists and is a regular file
+		info, err := fs.Stat(config.fsys(), filePath)
+		if err != nil {
+			dirResult.warn(config, filePath, "failed to stat file", err)
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			dirResult.warn(config, filePath, "skipped", errors.New("not a regular file"))
+			continue
+		}
+
+		// Check extension filter if specified
+		if config.Ext != "" && !strings.HasSuffix(filePath, config.Ext) {
+			continue
+		}
+
+		if shouldExcludeFile(filepath.Base(filePath), config.ExcludeFiles, config.CaseInsensitive) {
+			continue
+		}
+
+		mod, err := processFile(filePath, config)
+		if err != nil {
+			dirResult.warn(config, filePath, "failed to process", err)
+			continue
+		}
+		config.report(filePath, mod)
+
+		if mod != nil {
+			dirResult.add(*mod)
+		}
+	}
+
+	return dirResult, nil
+}
+
+// add records a modified file and updates the directory totals.
+func (d *DirectoryResult) add(mod FileModification) {
+	d.Files = append(d.Files, mod)
+	d.FilesModified++
+	d.LinesChanged += mod.LinesChanged
+	d.TotalReplacements += mod.Replacements
+}
+
+// maxLineSize is the maximum line size in bytes (10MB)
+const maxLineSize = 10 * 1024 * 1024
+
+// replaceInFile performs the replacement on one file and returns the number of
+// lines changed and replacements made.
+func replaceInFile(path string, config Options) (int, int, error) {
+	mod, err := processFile(path, config)
+	if err != nil || mod == nil {
+		return 0, 0, err
+	}
+	return mod.LinesChanged, mod.Replacements, nil
+}
+
+// processFile performs the replacement on one file. It returns nil when the
+// file has no matches.
+func processFile(path string, config Options) (*FileModification, error) {
+	// Early exit: if search equals replace, it's a no-op
+	if config.Search == config.Replace {
+		return nil, nil
+	}
+
+	// Files selected by git, or reached through a symlink, may lie outside
+	// the sandbox even when the directory holding them does not
+	if err := config.Sandbox.Check(path); err != nil {
+		return nil, err
+	}
+	data, err := fs.ReadFile(config.fsys(), path)
+	if err != nil {
+		return nil, err
+	}
+
+	after, mod, err := replaceContent(path, data, config)
+	if err != nil || mod == nil {
+		return nil, err
+	}
+	mod.Path = path
+
+	if !config.DryRun {
+		// Re-check at write time: writeAtomic follows symlinks to their target
+		if err := config.Sandbox.Check(path); err != nil {
+			return nil, err
+		}
+		err := writeAtomic(config.fsys(), path, after)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write file: %w", err)
+		}
+		if config.Verbose {
+			fmt.Fprintf(os.Stderr, "Modified: %s (%d replacements in %d lines)\n", path, mod.Replacements, mod.LinesChanged)
+		}
+	}
+
+	if config.OnChange != nil {
+		config.OnChange(FileChange{Path: path, Before: data, After: after})
+	}
+
+	return mod, nil
+}
+
+// replaceContent performs the replacement on the content of the file at path
+// and returns the new content. The modification is nil when nothing matched.
+func replaceContent(path string, data []byte, config Options) ([]byte, *FileModification, error) {
+	// Dispatch to multiline path when a match or replacement can span lines
+	if config.wholeContent() {
+		return replaceDataMultiline(path, data, config)
+	}
+
+	// Detect line ending style from the first chunk
+	lineEnding := "\n" // default to Unix style
+	detectBuf := data[:min(len(data), 8192)]
+	for i := 0; i < len(detectBuf)-1; i++ {
+		if detectBuf[i] == '\r' && detectBuf[i+1] == '\n' {
+			lineEnding = "\r\n"
+			break
+		}
+		if detectBuf[i] == '\n' {
+			break // Unix style confirmed
+		}
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	// Increase buffer size to handle very long lines (default is 64KB, set to 10MB)
+	buf := make([]byte, 0, 64*1024)
+	scanner.Buffer(buf, maxLineSize)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	if err := scanner.Err(); err != nil {
+		// Provide specific error for lines that are too long
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, nil, fmt.Errorf("line too long (max %dMB): %w", maxLineSize/(1024*1024), err)
+		}
+		return nil, nil, err
+	}
+
+	scope, err := config.lineScope(lines)
+	if err != nil {
+		return nil, nil, err
+	}
+	kinds, err := config.tokenKinds(path, string(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	var lineStarts []int // byte offset of each line, to look up its tokens
+	if kinds != nil {
+		lineStarts = lineOffsets(data)
+	}
+
+	linesChanged := 0
+	totalReplacements := 0
+	var matches []Match
+	lim := config.limiter()
+	modifiedLines := make([]string, len(lines))
+	copy(modifiedLines, lines)
+
+	replaceTerm := config.Replace
+
+	for i, line := range lines {
+		if scope != nil && !scope[i] {
+			continue
+		}
+
+		found := false
+		switch {
+		case config.CaseInsensitive:
+			found = len(matchSpans(line, config.Search, true, config.WholeWord)) > 0
+		case config.WholeWord:
+			found = containsWholeWord(line, config.Search)
+		default:
+			found = strings.Contains(line, config.Search)
+		}
+
+		if !found || !config.include.match(line) || !config.hasContext(lines, i, i) {
+			continue
+		}
+
+		excluded := false
+		for _, excludePattern := range config.ExcludeLines {
+			if containsMatch(line, excludePattern, config.CaseInsensitive) {
+				excluded = true
+				// DEBUG: uncomment for diagnostics
+				// fmt.Fprintf(os.Stderr, "DEBUG: Line %d excluded by pattern %q: %q\n", i, excludePattern, line)
+				break
+			}
+		}
+
+		if excluded {
+			continue
+		}
+
+		if config.Confirm != nil || lim.active() || kinds != nil {
+			inScope := func(s span) bool {
+				return kinds == nil || config.inTokenScope(kinds, lineStarts[i]+s.start, s.end-s.start)
+			}
+			newLine, approved := chooseLine(path, i+1, line, config, lim, inScope)
+			if len(approved) == 0 {
+				continue
+			}
+			modifiedLines[i] = newLine
+			linesChanged++
+			totalReplacements += len(approved)
+			if config.CollectMatches {
+				for _, s := range approved {
+					m := newMatch(i+1, line, s.start, s.end-s.start)
+					m.Replacement = s.text
+					matches = append(matches, m)
+				}
+			}
+			continue
+		}
+
+		newLine := replaceInLine(line, config.Search, replaceTerm, config.CaseInsensitive, config.WholeWord)
+		if newLine != line {
+			modifiedLines[i] = newLine
+			linesChanged++
+			totalReplacements += countReplacements(line, config.Search, config.CaseInsensitive, config.WholeWord)
+			if config.CollectMatches {
+				for _, s := range matchSpans(line, config.Search, config.CaseInsensitive, config.WholeWord) {
+					m := newMatch(i+1, line, s.start, s.end-s.start)
+					m.Replacement = replaceTerm
+					matches = append(matches, m)
+				}
+			}
+		}
+	}
+
+	if linesChanged == 0 {
+		return data, nil, nil
+	}
+
+	return joinLines(modifiedLines, lineEnding), &FileModification{LinesChanged: linesChanged, Replacements: totalReplacements, Matches: matches, Truncated: lim.truncated}, nil
+}
+
+func replaceInLine(line, search, replace string, caseInsensitive, wholeWord bool) string {
+	if search == "" {
+		return line
+	}
+
+	if !caseInsensitive && !wholeWord {
+		return strings.ReplaceAll(line, search, replace)
+	}
+
+	if caseInsensitive && !wholeWord {
+		return caseInsensitiveReplace(line, search, replace)
+	}
+
+	if wholeWord && !caseInsensitive {
+		return wholeWordReplace(line, search, replace)
+	}
+
+	return caseInsensitiveWholeWordReplace(line, search, replace)
+}
+
+func caseInsensitiveReplace(line, search, replace string) string {
+	return replaceSpans(line, matchSpans(line, search, true, false), replace)
+}
+
+func wholeWordReplace(line, search, replace string) string {
+	if search == "" {
+		return line
+	}
+
+	var result strings.Builder
+	result.Grow(len(line))
+	remaining := line
+	searchLen := len(search)
+
+	for {
+		idx := strings.Index(remaining, search)
+		if idx == -1 {
+			result.WriteString(remaining)
+			break
+		}
+
+		beforeOk := idx == 0 || !isWordChar(rune(remaining[idx-1]))
+		afterIdx := idx + searchLen
+		afterOk := afterIdx >= len(remaining) || !isWordChar(rune(remaining[afterIdx]))
+
+		if beforeOk && afterOk {
+			result.WriteString(remaining[:idx])
+			result.WriteString(replace)
+			remaining = remaining[afterIdx:]
+		} else {
+			result.WriteString(remaining[:idx+1])
+			remaining = remaining[idx+1:]
+		}
+	}
+
+	return result.String()
+}
+
+func caseInsensitiveWholeWordReplace(line, search, replace string) string {
+	return replaceSpans(line, matchSpans(line, search, true, true), replace)
+}
+
+// replaceSpans replaces the text at each of spans, in order and not
+// overlapping, with replace.
+func replaceSpans(line string, spans []span, replace string) string {
+	if len(spans) == 0 {
+		return line
+	}
+	var result strings.Builder
+	result.Grow(len(line))
+	prev := 0
+	for _, s := range spans {
+		result.WriteString(line[prev:s.start])
+		result.WriteString(replace)
+		prev = s.end
+	}
+	result.WriteString(line[prev:])
+	return result.String()
+}
+
+func countReplacements(line, search string, caseInsensitive, wholeWord bool) int {
+	// Guard against empty string which would cause infinite loop in whole-word mode
+	if search == "" {
+		return 0
+	}
+
+	if caseInsensitive {
+		return len(matchSpans(line, search, true, wholeWord))
+	}
+
+	count := 0
+	lineToCheck := line
+	searchTerm := search
+
+	if !wholeWord {
+		count = strings.Count(lineToCheck, searchTerm)
+		return count
+	}
+
+	startIdx := 0
+	for {
+		idx := strings.Index(lineToCheck[startIdx:], searchTerm)
+		if idx == -1 {
+			break
+		}
+
+		actualIdx := startIdx + idx
+		beforeOk := actualIdx == 0 || !isWordChar(rune(lineToCheck[actualIdx-1]))
+		afterIdx := actualIdx + len(searchTerm)
+		afterOk := afterIdx >= len(lineToCheck) || !isWordChar(rune(lineToCheck[afterIdx]))
+
+		if beforeOk && afterOk {
+			count++
+		}
+
+		startIdx = actualIdx + 1
+	}
+
+	return count
+}
+
+func containsWholeWord(text, word string) bool {
+	// Guard against empty string which would cause infinite loop
+	if word == "" {
+		return false
+	}
+
+	if !strings.Contains(text, word) {
+		return false
+	}
+
+	startIdx := 0
+	for {
+		idx := strings.Index(text[startIdx:], word)
+		if idx == -1 {
+			return false
+		}
+
+		actualIdx := startIdx + idx
+
+		beforeOk := actualIdx == 0 || !isWordChar(rune(text[actualIdx-1]))
+		afterIdx := actualIdx + len(word)
+		afterOk := afterIdx >= len(text) || !isWordChar(rune(text[afterIdx]))
+
+		if beforeOk && afterOk {
+			return true
+		}
+
+		startIdx = actualIdx + 1
+	}
+}
+
+// matchSpans returns the byte ranges in line of the occurrences of search
+// that replaceInLine would replace.
+func matchSpans(line, search string, caseInsensitive, wholeWord bool) []span {
+	if search == "" {
+		return nil
+	}
+
+	var spans []span
+	pos := 0
+	for {
+		start, end := indexMatch(line[pos:], search, caseInsensitive)
+		if start == -1 {
+			return spans
+		}
+		start, end = pos+start, pos+end
+		if wholeWord {
+			beforeOk := start == 0 || !isWordChar(rune(line[start-1]))
+			afterOk := end >= len(line) || !isWordChar(rune(line[end]))
+			if !beforeOk || !afterOk {
+				_, size := utf8.DecodeRuneInString(line[start:])
+				pos = start + size
+				continue
+			}
+		}
+		spans = append(spans, span{start: start, end: end})
+		pos = end
+	}
+}
+
+// newMatch builds a Match for the n bytes at byte offset off in line.
+func newMatch(lineNum int, line string, off, n int) Match {
+	end := off + n
+	if end > len(line) {
+		end = len(line)
+	}
+	return Match{
+		Line:   lineNum,
+		Column: utf8.RuneCountInString(line[:off]) + 1,
+		Match:  line[off:end],
+		Text:   line,
+	}
+}
+
+func isWordChar(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_'
+}
+
+func isMultiline(search, replace string) bool {
+	return strings.Contains(search, "\n") || strings.Contains(replace, "\n")
+}
+
+// UnescapeString converts literal escape sequences (e.g. backslash followed by 'n')
+// into actual control characters. This is needed because JSON decoding and shell
+// argument passing deliver literal two-character sequences, not real newlines/tabs.
+func UnescapeString(s string) string {
+	s = strings.ReplaceAll(s, "\\n", "\n")
+	s = strings.ReplaceAll(s, "\\r", "\r")
+	s = strings.ReplaceAll(s, "\\t", "\t")
+	return s
+}
+
+func countChangedLines(original, modified string) int {
+	origLines := strings.Split(original, "\n")
+	modLines := strings.Split(modified, "\n")
+
+	changed := 0
+	i := 0
+	for i < len(origLines) && i < len(modLines) {
+		if origLines[i] != modLines[i] {
+			changed++
+		}
+		i++
+	}
+	changed += len(origLines) - i
+	changed += len(modLines) - i
+
+	return changed
+}
+
+// replaceContentMultiline performs search/replace on whole-file content, handling all four
+// modes (standard, case-insensitive, whole-word, combined) with exclude and include support.
+// With pattern set, matches of pattern stand in for occurrences of search.
+// Returns the modified content, replacement count, and number of original lines affected.
+func replaceContentMultiline(content, search, replace string, caseInsensitive, wholeWord bool, exclude []string, include *includeFilter, pattern *regexp.Regexp) (string, int, int, []span) {
+	if search == "" {
+		return content, 0, 0, nil
+	}
+
+	var result strings.Builder
+	result.Grow(len(content))
+	replacements := 0
+	affectedLines := make(map[int]bool)
+	var spans []span
+	pos := 0
+
+	for {
+		var matchStart, matchEnd int
+		if pattern != nil {
+			loc := pattern.FindStringIndex(content[pos:])
+			if loc == nil {
+				result.WriteString(content[pos:])
+				break
+			}
+			matchStart, matchEnd = pos+loc[0], pos+loc[1]
+		} else {
+			start, end := indexMatch(content[pos:], search, caseInsensitive)
+			if start == -1 {
+				result.WriteString(content[pos:])
+				break
+			}
+			matchStart, matchEnd = pos+start, pos+end
+		}
+
+		// Check whole-word boundaries
+		if wholeWord {
+			beforeOk := matchStart == 0 || !isWordChar(rune(content[matchStart-1]))
+			afterOk := matchEnd >= len(content) || !isWordChar(rune(content[matchEnd]))
+			if !beforeOk || !afterOk {
+				result.WriteString(content[pos : matchStart+1])
+				pos = matchStart + 1
+				continue
+			}
+		}
+
+		// Check exclude and include patterns on the full lines spanning the match
+		if len(exclude) > 0 || include != nil {
+			excluded := false
+			lineStart := matchStart
+			for lineStart > 0 && content[lineStart-1] != '\n' {
+				lineStart--
+			}
+			lineEnd := matchEnd
+			for lineEnd < len(content) && content[lineEnd] != '\n' {
+				lineEnd++
+			}
+			spanningText := content[lineStart:lineEnd]
+
+			for _, excl := range exclude {
+				if containsMatch(spanningText, excl, caseInsensitive) {
+					excluded = true
+					break
+				}
+			}
+
+			if excluded || !include.match(spanningText) {
+				result.WriteString(content[pos:matchEnd])
+				pos = matchEnd
+				continue
+			}
+		}
+
+		// Track affected lines in original content
+		startLine := strings.Count(content[:matchStart], "\n")
+		matchNewlines := strings.Count(content[matchStart:matchEnd], "\n")
+		for l := startLine; l <= startLine+matchNewlines; l++ {
+			affectedLines[l] = true
+		}
+
+		// Perform replacement
+		result.WriteString(content[pos:matchStart])
+		result.WriteString(replace)
+		pos = matchEnd
+		replacements++
+		spans = append(spans, span{start: matchStart, end: matchEnd, text: replace})
+	}
+
+	return result.String(), replacements, len(affectedLines), spans
+}
+
+// replaceDataMultiline handles replacement when search or replace
+// contains newlines, or whitespace is flexible, performing whole-content
+// replacement on data.
+func replaceDataMultiline(path string, data []byte, config Options) ([]byte, *FileModification, error) {
+	content := string(data)
+	lines := strings.Split(content, "\n")
+	scope, err := config.lineScope(lines)
+	if err != nil {
+		return nil, nil, err
+	}
+	kinds, err := config.tokenKinds(path, content)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Detect line ending style
+	lineEnding := "\n"
+	if strings.Contains(content, "\r\n") {
+		lineEnding = "\r\n"
+	}
+
+	// Normalize search/replace to match file's line endings
+	search := config.Search
+	replace := config.Replace
+	if lineEnding == "\r\n" {
+		// Normalize any existing \r\n to \n first, then convert all \n to \r\n
+		search = strings.ReplaceAll(strings.ReplaceAll(search, "\r\n", "\n"), "\n", "\r\n")
+		replace = strings.ReplaceAll(strings.ReplaceAll(replace, "\r\n", "\n"), "\n", "\r\n")
+	}
+
+	var pattern *regexp.Regexp
+	if config.IgnoreWhitespace {
+		pattern = whitespacePattern(search, config.CaseInsensitive, config.IgnoreLineBreaks)
+	}
+	modified, replacements, linesChanged, spans := replaceContentMultiline(
+		content, search, replace,
+		config.CaseInsensitive, config.WholeWord, config.ExcludeLines, config.include, pattern,
+	)
+
+	// Scoping, context, limits and confirmation each keep a subset of the matches
+	inScope := func(s span) bool {
+		if scope != nil && !spanInScope(scope, content, s.start, s.end-s.start) {
+			return false
+		}
+		if !config.inTokenScope(kinds, s.start, s.end-s.start) {
+			return false
+		}
+		first := strings.Count(content[:s.start], "\n")
+		return config.hasContext(lines, first, first+strings.Count(content[s.start:s.end], "\n"))
+	}
+	replacementFor := func(s span) string { return replace }
+	if config.Reindent {
+		fileUnit, replaceUnit := detectIndent(content), detectIndent(replace)
+		replacementFor = func(s span) string { return reindent(content, s.start, replace, fileUnit, replaceUnit) }
+	}
+	lim := config.limiter()
+	if scope != nil || kinds != nil || config.hasContextConditions() || lim.active() || config.Confirm != nil || config.Reindent {
+		modified, replacements, linesChanged, spans = chooseContent(path, content, spans, replacementFor, inScope, config, lim)
+	}
+	if replacements == 0 {
+		return data, nil, nil
+	}
+
+	// Flexible whitespace makes the matched text worth reporting
+	var matches []Match
+	if config.CollectMatches || config.IgnoreWhitespace {
+		for _, s := range spans {
+			m := contentMatch(content, s.start, s.end-s.start)
+			m.Replacement = s.text
+			matches = append(matches, m)
+		}
+	}
+
+	return []byte(modified), &FileModification{LinesChanged: linesChanged, Replacements: replacements, Matches: matches, Truncated: lim.truncated}, nil
+}
+
+// WriteFile writes data to a file on the OS filesystem atomically using the
+// temp file + rename pattern. Symlinks are followed and the existing file's
+// permissions are kept.
+func WriteFile(path string, data []byte) error {
+	return writeAtomic(OSFS{}, path, data)
+}
+
+// lineOffsets returns the byte offset at which each line of data starts.
+func lineOffsets(data []byte) []int {
+	offsets := []int{0}
+	for i, b := range data {
+		if b == '\n' {
+			offsets = append(offsets, i+1)
+		}
+	}
+	return offsets
+}
+
+// joinLines renders lines as file content, terminating every line (including
+// the last) with lineEnding.
+func joinLines(lines []string, lineEnding string) []byte {
+	size := 0
+	for _, line := range lines {
+		size += len(line) + len(lineEnding)
+	}
+
+	buf := make([]byte, 0, size)
+	for _, line := range lines {
+		buf = append(buf, line...)
+		buf = append(buf, lineEnding...)
+	}
+	return buf
+}
@@ -1,4 +1,4 @@
-package main
+package repfor
 
 import (
 	"os"
@@ -275,7 +275,7 @@ func TestReplaceInFile_BinaryContent(t *testing.T) {
 		t.Fatalf("Failed to create binary file: %v", err)
 	}
 
-	config := Config{
+	config := Options{
 		Search:  "test",
 		Replace: "exam",
 		DryRun:  false,
@@ -299,7 +299,7 @@ func TestReplaceInFile_InvalidUTF8(t *testing.T) {
 		t.Fatalf("Failed to create file: %v", err)
 	}
 
-	config := Config{
+	config := Options{
 		Search:  "test",
 		Replace: "exam",
 		DryRun:  false,
@@ -324,7 +324,7 @@ func TestReplaceInFile_NoTrailingNewline(t *testing.T) {
 	content := "line1\nline2\nline3 with target"
 	filePath := createTestFile(t, tmpDir, "nonewline.txt", content)
 
-	config := Config{
+	config := Options{
 		Search:  "target",
 		Replace: "REPLACED",
 		DryRun:  false,
@@ -353,7 +353,7 @@ func TestReplaceInFile_OnlyNewlines(t *testing.T) {
 	content := "\n\n\n\n\n"
 	filePath := createTestFile(t, tmpDir, "newlines.txt", content)
 
-	config := Config{
+	config := Options{
 		Search:  "test",
 		Replace: "exam",
 		DryRun:  false,
@@ -431,7 +431,7 @@ return result
 `
 	filePath := createTestFile(t, tmpDir, "test.txt", content)
 
-	config := Config{
+	config := Options{
 		Search:       "result",
 		Replace:      "res",
 		ExcludeLines: []string{"dirresult", "tempresult"},
@@ -477,7 +477,7 @@ func TestReplaceInFile_ExcludeWithUnicode(t *testing.T) {
 	content := "test normal\ntest 世界\ntest emoji 👋\n"
 	filePath := createTestFile(t, tmpDir, "unicode.txt", content)
 
-	config := Config{
+	config := Options{
 		Search:       "test",
 		Replace:      "exam",
 		ExcludeLines: []string{"世界"},
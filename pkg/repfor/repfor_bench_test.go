@@ -1,6 +1,7 @@
-package main
+package repfor
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -253,7 +254,7 @@ func BenchmarkReplaceInFile_SmallFile(b *testing.B) {
 	content := strings.Repeat("target line\n", 100)
 	filePath := createTestFileBench(b, tmpDir, "small.txt", content)
 
-	config := Config{
+	config := Options{
 		Search:  "target",
 		Replace: "REPLACED",
 		DryRun:  false,
@@ -272,7 +273,7 @@ func BenchmarkReplaceInFile_MediumFile(b *testing.B) {
 	content := strings.Repeat("target line\n", 10000)
 	filePath := createTestFileBench(b, tmpDir, "medium.txt", content)
 
-	config := Config{
+	config := Options{
 		Search:  "target",
 		Replace: "REPLACED",
 		DryRun:  false,
@@ -295,7 +296,7 @@ func BenchmarkReplaceInFile_LargeFile(b *testing.B) {
 	content := strings.Repeat("target line\n", 100000)
 	filePath := createTestFileBench(b, tmpDir, "large.txt", content)
 
-	config := Config{
+	config := Options{
 		Search:  "target",
 		Replace: "REPLACED",
 		DryRun:  false,
@@ -314,7 +315,7 @@ func BenchmarkReplaceInFile_DryRun(b *testing.B) {
 	content := strings.Repeat("target line\n", 10000)
 	filePath := createTestFileBench(b, tmpDir, "dryrun.txt", content)
 
-	config := Config{
+	config := Options{
 		Search:  "target",
 		Replace: "REPLACED",
 		DryRun:  true,
@@ -333,7 +334,7 @@ func BenchmarkReplaceInFile_WithExclude(b *testing.B) {
 	content := "result = test\ndirResult = test\n" + strings.Repeat("result = value\n", 1000)
 	filePath := createTestFileBench(b, tmpDir, "exclude.txt", content)
 
-	config := Config{
+	config := Options{
 		Search:       "result",
 		Replace:      "res",
 		ExcludeLines: []string{"dirResult"},
@@ -357,7 +358,7 @@ func BenchmarkReplaceInDirectory_SmallDir(b *testing.B) {
 		createTestFileBench(b, tmpDir, fmt.Sprintf("file%03d.txt", i), content)
 	}
 
-	config := Config{
+	config := Options{
 		Search:  "target",
 		Replace: "REPLACED",
 		DryRun:  false,
@@ -365,7 +366,7 @@ func BenchmarkReplaceInDirectory_SmallDir(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, _ = replaceInDirectory(tmpDir, config)
+		_, _ = replaceInDirectory(context.Background(), tmpDir, config)
 	}
 }
 
@@ -382,7 +383,7 @@ func BenchmarkReplaceInDirectory_ManyFiles(b *testing.B) {
 		createTestFileBench(b, tmpDir, fmt.Sprintf("file%03d.txt", i), content)
 	}
 
-	config := Config{
+	config := Options{
 		Search:  "target",
 		Replace: "REPLACED",
 		DryRun:  false,
@@ -390,7 +391,7 @@ func BenchmarkReplaceInDirectory_ManyFiles(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, _ = replaceInDirectory(tmpDir, config)
+		_, _ = replaceInDirectory(context.Background(), tmpDir, config)
 	}
 }
 
@@ -404,7 +405,7 @@ func BenchmarkReplaceInDirectory_WithFilter(b *testing.B) {
 		createTestFileBench(b, tmpDir, fmt.Sprintf("file%03d.go", i), content)
 	}
 
-	config := Config{
+	config := Options{
 		Search:  "target",
 		Replace: "REPLACED",
 		Ext:     ".txt",
@@ -413,7 +414,7 @@ func BenchmarkReplaceInDirectory_WithFilter(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, _ = replaceInDirectory(tmpDir, config)
+		_, _ = replaceInDirectory(context.Background(), tmpDir, config)
 	}
 }
 
@@ -432,7 +433,7 @@ func BenchmarkWriteFile_SmallFile(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_ = writeFileAtomic(filePath, lines, "\n")
+		_ = writeAtomic(OSFS{}, filePath, joinLines(lines, "\n"))
 	}
 }
 
@@ -453,7 +454,7 @@ func BenchmarkWriteFile_LargeFile(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_ = writeFileAtomic(filePath, lines, "\n")
+		_ = writeAtomic(OSFS{}, filePath, joinLines(lines, "\n"))
 	}
 }
 
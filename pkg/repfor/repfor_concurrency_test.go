@@ -1,6 +1,7 @@
-package main
+package repfor
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -27,7 +28,7 @@ func TestReplaceInFile_Concurrent(t *testing.T) {
 		filePaths[i] = createTestFile(t, tmpDir, fmt.Sprintf("file%03d.txt", i), content)
 	}
 
-	config := Config{
+	config := Options{
 		Search:  "target",
 		Replace: "REPLACED",
 		DryRun:  false,
@@ -85,7 +86,7 @@ func TestReplaceInDirectory_ConcurrentWrites(t *testing.T) {
 	numGoroutines := 5
 	results := make(chan *DirectoryResult, numGoroutines)
 
-	config := Config{
+	config := Options{
 		Search:  "target",
 		Replace: "REPLACED",
 		DryRun:  false,
@@ -95,7 +96,7 @@ func TestReplaceInDirectory_ConcurrentWrites(t *testing.T) {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			result, err := replaceInDirectory(tmpDir, config)
+			result, err := replaceInDirectory(context.Background(), tmpDir, config)
 			if err != nil {
 				t.Errorf("replaceInDirectory failed: %v", err)
 				return
@@ -145,7 +146,7 @@ func TestReplaceInDirectories_ParallelDirectories(t *testing.T) {
 		}
 	}
 
-	config := Config{
+	config := Options{
 		Dirs:    dirs,
 		Search:  "target",
 		Replace: "REPLACED",
@@ -153,7 +154,7 @@ func TestReplaceInDirectories_ParallelDirectories(t *testing.T) {
 	}
 
 	// Process directories
-	result, err := replaceInDirectories(config)
+	result, err := replaceInDirectories(context.Background(), config)
 	if err != nil {
 		t.Fatalf("replaceInDirectories failed: %v", err)
 	}
@@ -1,4 +1,4 @@
-package main
+package repfor
 
 import (
 	"math/rand"
@@ -1,8 +1,9 @@
 //go:build stress
 
-package main
+package repfor
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -35,7 +36,7 @@ func TestReplaceInFile_StressTest(t *testing.T) {
 	}
 	filePath := createTestFile(t, tmpDir, "stress.txt", content)
 
-	config := Config{
+	config := Options{
 		Search:  "target",
 		Replace: "REPLACED",
 		DryRun:  false,
@@ -76,14 +77,14 @@ func TestReplaceInDirectory_StressManyFiles(t *testing.T) {
 		createTestFile(t, tmpDir, fmt.Sprintf("file%04d.txt", i), content)
 	}
 
-	config := Config{
+	config := Options{
 		Search:  "target",
 		Replace: "REPLACED",
 		DryRun:  false,
 	}
 
 	start := time.Now()
-	result, err := replaceInDirectory(tmpDir, config)
+	result, err := replaceInDirectory(context.Background(), tmpDir, config)
 	duration := time.Since(start)
 
 	if err != nil {
@@ -119,7 +120,7 @@ func TestReplaceInFile_MemoryStress(t *testing.T) {
 	}
 	filePath := createTestFile(t, tmpDir, "large.txt", content)
 
-	config := Config{
+	config := Options{
 		Search:  "target",
 		Replace: "REPLACED",
 		DryRun:  false,
@@ -149,7 +150,7 @@ func TestNoGoroutineLeaks(t *testing.T) {
 
 	createTestFile(t, tmpDir, "test.txt", "target content\n")
 
-	config := Config{
+	config := Options{
 		Search:  "target",
 		Replace: "REPLACED",
 		DryRun:  false,
@@ -181,7 +182,7 @@ func TestDryRun_ConcurrentSafety(t *testing.T) {
 	originalContent := "target target target\n"
 	filePath := createTestFile(t, tmpDir, "test.txt", originalContent)
 
-	config := Config{
+	config := Options{
 		Search:  "target",
 		Replace: "REPLACED",
 		DryRun:  true,
@@ -224,7 +225,7 @@ func TestReplaceInFile_LongRunning(t *testing.T) {
 	}
 	filePath := createTestFile(t, tmpDir, "huge.txt", content)
 
-	config := Config{
+	config := Options{
 		Search:  "content",
 		Replace: "data",
 		DryRun:  false,
@@ -268,7 +269,7 @@ func TestReplaceInDirectories_ConcurrentDirs(t *testing.T) {
 		}
 	}
 
-	config := Config{
+	config := Options{
 		Dirs:    dirs,
 		Search:  "target",
 		Replace: "REPLACED",
@@ -276,7 +277,7 @@ func TestReplaceInDirectories_ConcurrentDirs(t *testing.T) {
 	}
 
 	start := time.Now()
-	result, err := replaceInDirectories(config)
+	result, err := replaceInDirectories(context.Background(), config)
 	duration := time.Since(start)
 
 	if err != nil {
@@ -313,7 +314,7 @@ func TestReplaceInFile_LargeFile(t *testing.T) {
 	content := strings.Join(lines, "\n") + "\n"
 	filePath := createTestFile(t, tmpDir, "large.txt", content)
 
-	config := Config{
+	config := Options{
 		Search:  "target",
 		Replace: "REPLACED",
 		DryRun:  false,
@@ -342,7 +343,7 @@ func TestReplaceInFile_VeryLongLines(t *testing.T) {
 	content := longLine + "\n"
 	filePath := createTestFile(t, tmpDir, "longlines.txt", content)
 
-	config := Config{
+	config := Options{
 		Search:  "target",
 		Replace: "REPLACED",
 		DryRun:  false,
@@ -380,13 +381,13 @@ func TestReplaceInFile_ManySmallFiles(t *testing.T) {
 		createTestFile(t, tmpDir, fmt.Sprintf("file%04d.txt", i), content)
 	}
 
-	config := Config{
+	config := Options{
 		Search:  "target",
 		Replace: "REPLACED",
 		DryRun:  false,
 	}
 
-	result, err := replaceInDirectory(tmpDir, config)
+	result, err := replaceInDirectory(context.Background(), tmpDir, config)
 	if err != nil {
 		t.Fatalf("replaceInDirectory failed: %v", err)
 	}
@@ -0,0 +1,130 @@
+package repfor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Sandbox restricts which paths repfor may read or write. Roots are stored as
+// absolute, symlink-resolved paths so that containment checks compare the
+// real location of a file, not the name it was reached through.
+//
+// A nil *Sandbox means unrestricted. A Sandbox with no roots allows nothing.
+type Sandbox struct {
+	roots []string
+}
+
+// ErrOutsideRoots is returned when a path resolves outside the allowed roots.
+var ErrOutsideRoots = errors.New("path is outside the allowed roots")
+
+// NewSandbox resolves the given root directories. Roots that do not exist are
+// an error, since they can never contain anything.
+func NewSandbox(roots []string) (*Sandbox, error) {
+	sb := &Sandbox{roots: make([]string, 0, len(roots))}
+	for _, root := range roots {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return nil, fmt.Errorf("invalid root %s: %w", root, err)
+		}
+		resolved, err := filepath.EvalSymlinks(abs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid root %s: %w", root, err)
+		}
+		sb.roots = append(sb.roots, resolved)
+	}
+	return sb, nil
+}
+
+// Check resolves path (following symlinks) and reports an error wrapping
+// ErrOutsideRoots unless it lies within one of the roots.
+func (sb *Sandbox) Check(path string) error {
+	if sb == nil {
+		return nil
+	}
+
+	resolved, err := resolvePath(path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", path, err)
+	}
+
+	for _, root := range sb.roots {
+		if withinRoot(root, resolved) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrOutsideRoots, path)
+}
+
+// Roots returns the resolved root directories.
+func (sb *Sandbox) Roots() []string {
+	if sb == nil {
+		return nil
+	}
+	return sb.roots
+}
+
+// Intersect returns a sandbox allowing only paths permitted by both sb and
+// other. Where one root nests inside another, the narrower one is kept.
+func (sb *Sandbox) Intersect(other *Sandbox) *Sandbox {
+	if sb == nil {
+		return other
+	}
+	if other == nil {
+		return sb
+	}
+
+	result := &Sandbox{}
+	for _, a := range sb.roots {
+		for _, b := range other.roots {
+			switch {
+			case withinRoot(a, b):
+				result.roots = append(result.roots, b)
+			case withinRoot(b, a):
+				result.roots = append(result.roots, a)
+			}
+		}
+	}
+	return result
+}
+
+// resolvePath returns the absolute, symlink-resolved form of path. Paths that
+// do not exist yet are resolved through their nearest existing ancestor so a
+// dangling name cannot be used to step outside a root.
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	var missing []string
+	current := abs
+	for {
+		resolved, err := filepath.EvalSymlinks(current)
+		if err == nil {
+			for i := len(missing) - 1; i >= 0; i-- {
+				resolved = filepath.Join(resolved, missing[i])
+			}
+			return resolved, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return abs, nil
+		}
+		missing = append(missing, filepath.Base(current))
+		current = parent
+	}
+}
+
+func withinRoot(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
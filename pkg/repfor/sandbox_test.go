@@ -0,0 +1,151 @@
+package repfor
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSandbox_Check(t *testing.T) {
+	root := setupTestDir(t)
+	defer cleanupTestDir(t, root)
+	outside := setupTestDir(t)
+	defer cleanupTestDir(t, outside)
+
+	inside := createTestFile(t, root, "in.txt", "x\n")
+	external := createTestFile(t, outside, "out.txt", "x\n")
+	link := filepath.Join(root, "link.txt")
+	if err := os.Symlink(external, link); err != nil {
+		t.Skipf("Symlinks not supported: %v", err)
+	}
+
+	sb, err := NewSandbox([]string{root})
+	if err != nil {
+		t.Fatalf("NewSandbox failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		allowed bool
+	}{
+		{"root itself", root, true},
+		{"file inside", inside, true},
+		{"not yet existing file inside", filepath.Join(root, "new", "file.txt"), true},
+		{"file outside", external, false},
+		{"symlink to outside", link, false},
+		{"dot-dot traversal", filepath.Join(root, "..", filepath.Base(outside), "out.txt"), false},
+		{"filesystem root", "/", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := sb.Check(tt.path)
+			if tt.allowed && err != nil {
+				t.Errorf("Check(%q) = %v, want allowed", tt.path, err)
+			}
+			if !tt.allowed && !errors.Is(err, ErrOutsideRoots) {
+				t.Errorf("Check(%q) = %v, want ErrOutsideRoots", tt.path, err)
+			}
+		})
+	}
+
+	var unrestricted *Sandbox
+	if err := unrestricted.Check("/"); err != nil {
+		t.Errorf("nil sandbox should allow everything, got %v", err)
+	}
+}
+
+func TestSandbox_Intersect(t *testing.T) {
+	root := setupTestDir(t)
+	defer cleanupTestDir(t, root)
+	sub := filepath.Join(root, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatalf("Failed to create subdir: %v", err)
+	}
+	other := setupTestDir(t)
+	defer cleanupTestDir(t, other)
+
+	wide, _ := NewSandbox([]string{root})
+	narrow, _ := NewSandbox([]string{sub})
+	disjoint, _ := NewSandbox([]string{other})
+
+	got := wide.Intersect(narrow)
+	if err := got.Check(filepath.Join(sub, "a.txt")); err != nil {
+		t.Errorf("intersection should allow narrow root: %v", err)
+	}
+	if err := got.Check(filepath.Join(root, "a.txt")); err == nil {
+		t.Error("intersection should reject paths outside the narrow root")
+	}
+
+	if err := wide.Intersect(disjoint).Check(filepath.Join(other, "a.txt")); err == nil {
+		t.Error("intersection of disjoint roots should allow nothing")
+	}
+
+	if wide.Intersect(nil) != wide {
+		t.Error("intersecting with nil should return the receiver")
+	}
+}
+
+func TestReplaceInDirectories_RejectsOutsideRoots(t *testing.T) {
+	root := setupTestDir(t)
+	defer cleanupTestDir(t, root)
+	outside := setupTestDir(t)
+	defer cleanupTestDir(t, outside)
+	outFile := createTestFile(t, outside, "victim.txt", "hello\n")
+
+	sb, _ := NewSandbox([]string{root})
+
+	_, err := replaceInDirectories(context.Background(), Options{
+		Dirs:      []string{"/"},
+		Search:    "hello",
+		Replace:   "bye",
+		Recursive: true,
+		Sandbox:   sb,
+	})
+	if !errors.Is(err, ErrOutsideRoots) {
+		t.Errorf("Expected ErrOutsideRoots for dir '/', got %v", err)
+	}
+
+	_, err = replaceInDirectories(context.Background(), Options{
+		Files:   []string{outFile},
+		Search:  "hello",
+		Replace: "bye",
+		Sandbox: sb,
+	})
+	if !errors.Is(err, ErrOutsideRoots) {
+		t.Errorf("Expected ErrOutsideRoots for file argument, got %v", err)
+	}
+
+	if content := readFileContent(t, outFile); content != "hello\n" {
+		t.Errorf("File outside roots was modified: %q", content)
+	}
+}
+
+func TestReplaceInFile_SymlinkTargetOutsideRoots(t *testing.T) {
+	root := setupTestDir(t)
+	defer cleanupTestDir(t, root)
+	outside := setupTestDir(t)
+	defer cleanupTestDir(t, outside)
+
+	target := createTestFile(t, outside, "target.txt", "hello\nhello\nworld\n")
+	link := filepath.Join(root, "link.txt")
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("Symlinks not supported: %v", err)
+	}
+
+	sb, _ := NewSandbox([]string{root})
+
+	for _, search := range []string{"hello", "hello\nhello"} {
+		_, _, err := replaceInFile(link, Options{Search: search, Replace: "bye", Sandbox: sb})
+		if !errors.Is(err, ErrOutsideRoots) {
+			t.Errorf("search %q: expected ErrOutsideRoots, got %v", search, err)
+		}
+	}
+
+	if content := readFileContent(t, target); content != "hello\nhello\nworld\n" {
+		t.Errorf("Symlink target outside roots was modified: %q", content)
+	}
+}
@@ -0,0 +1,146 @@
+package repfor
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// LineRange selects lines Start through End of a file, 1-based and
+// inclusive. A zero Start means from the first line and a zero End to the
+// last; the zero LineRange selects every line.
+type LineRange struct {
+	Start int
+	End   int
+}
+
+// ParseLineRange parses "120-180", "120-" (to the end), "-180" (from the
+// start) or "120" (one line).
+func ParseLineRange(s string) (LineRange, error) {
+	startText, endText, isRange := strings.Cut(strings.TrimSpace(s), "-")
+	if !isRange {
+		endText = startText
+	}
+	var r LineRange
+	var err error
+	if startText != "" {
+		if r.Start, err = strconv.Atoi(strings.TrimSpace(startText)); err != nil || r.Start < 1 {
+			return LineRange{}, fmt.Errorf("invalid line range %q", s)
+		}
+	}
+	if endText != "" {
+		if r.End, err = strconv.Atoi(strings.TrimSpace(endText)); err != nil || r.End < 1 {
+			return LineRange{}, fmt.Errorf("invalid line range %q", s)
+		}
+	}
+	if r == (LineRange{}) || (r.End != 0 && r.Start > r.End) {
+		return LineRange{}, fmt.Errorf("invalid line range %q", s)
+	}
+	return r, nil
+}
+
+func (r LineRange) String() string {
+	switch {
+	case r == LineRange{}:
+		return ""
+	case r.Start == r.End:
+		return strconv.Itoa(r.Start)
+	case r.End == 0:
+		return fmt.Sprintf("%d-", r.Start)
+	}
+	return fmt.Sprintf("%d-%d", max(r.Start, 1), r.End)
+}
+
+// MarshalText renders r as ParseLineRange reads it.
+func (r LineRange) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText parses text as ParseLineRange does; empty text is the zero
+// LineRange.
+func (r *LineRange) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*r = LineRange{}
+		return nil
+	}
+	parsed, err := ParseLineRange(string(text))
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+// contains reports whether the 1-based line n is in r.
+func (r LineRange) contains(n int) bool {
+	return n >= r.Start && (r.End == 0 || n <= r.End)
+}
+
+// scoped reports whether the replacement is limited to part of each file.
+func (o Options) scoped() bool {
+	return o.LineRange != (LineRange{}) || o.BetweenStart != ""
+}
+
+// validateScope checks that the scoping options fit together.
+func (o Options) validateScope() error {
+	if (o.BetweenStart == "") != (o.BetweenEnd == "") {
+		return errors.New("between needs both a start and an end marker")
+	}
+	if o.Outside && o.BetweenStart == "" {
+		return errors.New("outside needs between markers")
+	}
+	return o.validateTokenScope()
+}
+
+// lineScope returns which of lines (the content of a file, one per element)
+// the replacement may change, or nil when it may change any.
+//
+// A region runs from a line containing BetweenStart to the next line
+// containing BetweenEnd. Only the lines strictly inside regions are in scope,
+// or with Outside only the lines outside them; the marker lines never are. A
+// region that is not closed is an error, since where the author meant it to
+// end is unknown.
+func (o Options) lineScope(lines []string) ([]bool, error) {
+	if !o.scoped() {
+		return nil, nil
+	}
+	in := make([]bool, len(lines))
+	for i := range lines {
+		in[i] = o.LineRange.contains(i + 1)
+	}
+	if o.BetweenStart == "" {
+		return in, nil
+	}
+
+	open := -1 // line of the start marker of the current region
+	for i, line := range lines {
+		switch {
+		case open < 0 && strings.Contains(line, o.BetweenStart):
+			open = i
+			in[i] = false
+		case open >= 0 && strings.Contains(line, o.BetweenEnd):
+			open = -1
+			in[i] = false
+		case (open >= 0) == o.Outside:
+			in[i] = false
+		}
+	}
+	if open >= 0 {
+		return nil, fmt.Errorf("region started by %q on line %d is not closed by %q", o.BetweenStart, open+1, o.BetweenEnd)
+	}
+	return in, nil
+}
+
+// spanInScope reports whether every line touched by the n bytes at offset
+// start of content is in scope.
+func spanInScope(scope []bool, content string, start, n int) bool {
+	first := strings.Count(content[:start], "\n")
+	last := first + strings.Count(content[start:start+n], "\n")
+	for l := first; l <= last; l++ {
+		if l >= len(scope) || !scope[l] {
+			return false
+		}
+	}
+	return true
+}
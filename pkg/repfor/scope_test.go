@@ -0,0 +1,129 @@
+package repfor
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestParseLineRange(t *testing.T) {
+	valid := map[string]LineRange{
+		"120-180": {120, 180},
+		"120-":    {120, 0},
+		"-180":    {0, 180},
+		"7":       {7, 7},
+		" 3 - 4 ": {3, 4},
+	}
+	for s, want := range valid {
+		got, err := ParseLineRange(s)
+		if err != nil || got != want {
+			t.Errorf("ParseLineRange(%q) = %+v, %v; want %+v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "-", "0-5", "9-3", "a-b", "1-2-3"} {
+		if _, err := ParseLineRange(s); err == nil {
+			t.Errorf("ParseLineRange(%q) should fail", s)
+		}
+	}
+	if s := (LineRange{0, 180}).String(); s != "1-180" {
+		t.Errorf("String() = %q", s)
+	}
+}
+
+const scopedContent = "foo 1\n// BEGIN gen\nfoo 3\nfoo 4\n// END gen\nfoo 6\n// BEGIN gen\nfoo 8\n// END gen\n"
+
+func TestScope(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		want string // resulting content
+	}{
+		{"line range", Options{LineRange: LineRange{3, 6}}, "foo 1\n// BEGIN gen\nbar 3\nbar 4\n// END gen\nbar 6\n// BEGIN gen\nfoo 8\n// END gen\n"},
+		{"inside", Options{BetweenStart: "BEGIN gen", BetweenEnd: "END gen"}, "foo 1\n// BEGIN gen\nbar 3\nbar 4\n// END gen\nfoo 6\n// BEGIN gen\nbar 8\n// END gen\n"},
+		{"outside", Options{BetweenStart: "BEGIN gen", BetweenEnd: "END gen", Outside: true}, "bar 1\n// BEGIN gen\nfoo 3\nfoo 4\n// END gen\nbar 6\n// BEGIN gen\nfoo 8\n// END gen\n"},
+		{"range and region", Options{LineRange: LineRange{Start: 4}, BetweenStart: "BEGIN gen", BetweenEnd: "END gen"}, "foo 1\n// BEGIN gen\nfoo 3\nbar 4\n// END gen\nfoo 6\n// BEGIN gen\nbar 8\n// END gen\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := setupTestDir(t)
+			defer cleanupTestDir(t, tmpDir)
+			path := createTestFile(t, tmpDir, "a.go", scopedContent)
+			opts := tt.opts
+			opts.Files = []string{path}
+			opts.Search, opts.Replace = "foo ", "bar "
+
+			if _, err := New(opts).Run(context.Background()); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			if content := readFileContent(t, path); content != tt.want {
+				t.Errorf("content = %q\nwant      %q", content, tt.want)
+			}
+		})
+	}
+}
+
+func TestScope_Multiline(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.go", scopedContent)
+
+	// A match is in scope only if every line it touches is: "6\n// BEGIN"
+	// touches a marker line
+	result, err := New(Options{
+		Files:        []string{path},
+		Search:       "3\nfoo 4",
+		Replace:      "3\nfoo 4b",
+		BetweenStart: "BEGIN gen",
+		BetweenEnd:   "END gen",
+	}).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if result.Directories[0].TotalReplacements != 1 || !strings.Contains(readFileContent(t, path), "foo 4b\n") {
+		t.Errorf("multiline match inside region not replaced: %+v", result.Directories[0])
+	}
+
+	result, err = New(Options{
+		Files:        []string{path},
+		Search:       "6\n// BEGIN",
+		Replace:      "6\n// START",
+		BetweenStart: "BEGIN gen",
+		BetweenEnd:   "END gen",
+		Outside:      true,
+	}).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if result.Directories[0].TotalReplacements != 0 {
+		t.Errorf("match touching a marker line was replaced")
+	}
+}
+
+func TestScope_Errors(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.go", "// BEGIN\nfoo\n")
+
+	result, err := New(Options{Files: []string{path}, Search: "foo", Replace: "bar", BetweenStart: "BEGIN", BetweenEnd: "END"}).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0].Message, "not closed") {
+		t.Errorf("warnings = %+v", result.Warnings)
+	}
+	if content := readFileContent(t, path); content != "// BEGIN\nfoo\n" {
+		t.Errorf("file with unclosed region modified: %q", content)
+	}
+
+	invalid := []Options{
+		{Dirs: []string{tmpDir}, LineRange: LineRange{1, 2}},
+		{Files: []string{path}, BetweenStart: "BEGIN"},
+		{Files: []string{path}, Outside: true},
+	}
+	for _, opts := range invalid {
+		opts.Search, opts.Replace = "foo", "bar"
+		if _, err := New(opts).Run(context.Background()); err == nil {
+			t.Errorf("Expected error for %+v", opts)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package repfor
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Values of Options.Scope
+const (
+	ScopeAll      = "all"      // every match (the default)
+	ScopeCode     = "code"     // only matches outside comments and string literals
+	ScopeComments = "comments" // only matches inside comments
+	ScopeStrings  = "strings"  // only matches inside string literals
+)
+
+// tokenKind classifies a byte of source code.
+type tokenKind uint8
+
+const (
+	tokenCode tokenKind = iota
+	tokenComment
+	tokenString
+)
+
+// language describes the comments and string literals of a language, which
+// is all the tokenizer needs to tell them from code.
+type language struct {
+	lineComments  []string
+	blockComments [][2]string // open and close
+	strings       []stringDelim
+	// hashAfterSpace: "#" starts a comment only at the start of a line or
+	// after whitespace, as in shell ("$#") and YAML ("a#b").
+	hashAfterSpace bool
+}
+
+// stringDelim describes one kind of string literal.
+type stringDelim struct {
+	open, close string
+	escape      byte // escapes the next byte; 0 for none
+	doubled     bool // a doubled close is an escaped close ('it''s')
+	multiline   bool // the literal may span lines
+}
+
+var (
+	doubleQuoted = stringDelim{open: `"`, close: `"`, escape: '\\'}
+	singleQuoted = stringDelim{open: `'`, close: `'`, escape: '\\'}
+	cComments    = [][2]string{{"/*", "*/"}}
+)
+
+// languages maps the names accepted by Options.Language to their syntax.
+var languages = map[string]*language{
+	"go": {
+		lineComments:  []string{"//"},
+		blockComments: cComments,
+		strings:       []stringDelim{doubleQuoted, singleQuoted, {open: "`", close: "`", multiline: true}},
+	},
+	"js": {
+		lineComments:  []string{"//"},
+		blockComments: cComments,
+		strings:       []stringDelim{doubleQuoted, singleQuoted, {open: "`", close: "`", escape: '\\', multiline: true}},
+	},
+	"python": {
+		lineComments: []string{"#"},
+		strings: []stringDelim{
+			{open: `"""`, close: `"""`, escape: '\\', multiline: true},
+			{open: `'''`, close: `'''`, escape: '\\', multiline: true},
+			doubleQuoted, singleQuoted,
+		},
+	},
+	"c": {
+		lineComments:  []string{"//"},
+		blockComments: cComments,
+		strings:       []stringDelim{doubleQuoted, singleQuoted},
+	},
+	"shell": {
+		lineComments:   []string{"#"},
+		strings:        []stringDelim{{open: `"`, close: `"`, escape: '\\', multiline: true}, {open: `'`, close: `'`, multiline: true}},
+		hashAfterSpace: true,
+	},
+	"sql": {
+		lineComments:  []string{"--"},
+		blockComments: cComments,
+		// Double quotes enclose identifiers, which are code
+		strings: []stringDelim{{open: `'`, close: `'`, doubled: true, multiline: true}},
+	},
+	"yaml": {
+		lineComments:   []string{"#"},
+		strings:        []stringDelim{doubleQuoted, {open: `'`, close: `'`, doubled: true}},
+		hashAfterSpace: true,
+	},
+}
+
+// extensionLanguages picks the language of a file from its extension.
+var extensionLanguages = map[string]string{
+	".go":   "go",
+	".js":   "js",
+	".jsx":  "js",
+	".mjs":  "js",
+	".cjs":  "js",
+	".ts":   "js",
+	".tsx":  "js",
+	".mts":  "js",
+	".cts":  "js",
+	".py":   "python",
+	".pyi":  "python",
+	".java": "c",
+	".c":    "c",
+	".h":    "c",
+	".cc":   "c",
+	".cpp":  "c",
+	".cxx":  "c",
+	".hh":   "c",
+	".hpp":  "c",
+	".cs":   "c",
+	".sh":   "shell",
+	".bash": "shell",
+	".zsh":  "shell",
+	".sql":  "sql",
+	".yaml": "yaml",
+	".yml":  "yaml",
+}
+
+// validateTokenScope checks Scope and Language.
+func (o Options) validateTokenScope() error {
+	switch o.Scope {
+	case "", ScopeAll, ScopeCode, ScopeComments, ScopeStrings:
+	default:
+		return fmt.Errorf("invalid scope %q (want %s, %s, %s or %s)", o.Scope, ScopeCode, ScopeComments, ScopeStrings, ScopeAll)
+	}
+	if o.Language != "" && languages[o.Language] == nil {
+		names := make([]string, 0, len(languages))
+		for name := range languages {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown language %q (want one of %s)", o.Language, strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// tokenKinds returns the kind of every byte of content, the content of the
+// file at path, or nil when Scope lets matches touch anything. A file in a
+// language the tokenizer does not know is an error, since which of its
+// matches Scope selects is unknown.
+func (o Options) tokenKinds(path, content string) ([]tokenKind, error) {
+	if o.Scope == "" || o.Scope == ScopeAll {
+		return nil, nil
+	}
+	name := o.Language
+	if name == "" {
+		name = extensionLanguages[strings.ToLower(filepath.Ext(path))]
+	}
+	lang := languages[name]
+	if lang == nil {
+		return nil, fmt.Errorf("scope %q needs a language, and none is known for %q", o.Scope, filepath.Base(path))
+	}
+	return lang.tokenize(content), nil
+}
+
+// inTokenScope reports whether the n bytes at offset start all have the kind
+// Scope selects. kinds nil lets everything through.
+func (o Options) inTokenScope(kinds []tokenKind, start, n int) bool {
+	if kinds == nil {
+		return true
+	}
+	want := tokenCode
+	switch o.Scope {
+	case ScopeComments:
+		want = tokenComment
+	case ScopeStrings:
+		want = tokenString
+	}
+	for _, kind := range kinds[start : start+n] {
+		if kind != want {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenize classifies every byte of content as code, comment or string.
+// Delimiters belong to their comment or string.
+func (l *language) tokenize(content string) []tokenKind {
+	kinds := make([]tokenKind, len(content))
+	for i := 0; i < len(content); {
+		end, kind := l.token(content, i)
+		if end == i {
+			i++
+			continue
+		}
+		for j := i; j < end; j++ {
+			kinds[j] = kind
+		}
+		i = end
+	}
+	return kinds
+}
+
+// token returns the end of the comment or string literal starting at offset
+// i of content and its kind, or i when code is there.
+func (l *language) token(content string, i int) (int, tokenKind) {
+	rest := content[i:]
+	for _, open := range l.lineComments {
+		if !strings.HasPrefix(rest, open) {
+			continue
+		}
+		if open == "#" && l.hashAfterSpace && i > 0 && !strings.ContainsRune(" \t\n", rune(content[i-1])) {
+			continue
+		}
+		if end := strings.IndexByte(rest, '\n'); end >= 0 {
+			return i + end, tokenComment
+		}
+		return len(content), tokenComment
+	}
+	for _, c := range l.blockComments {
+		if !strings.HasPrefix(rest, c[0]) {
+			continue
+		}
+		if end := strings.Index(rest[len(c[0]):], c[1]); end >= 0 {
+			return i + len(c[0]) + end + len(c[1]), tokenComment
+		}
+		return len(content), tokenComment
+	}
+	for _, s := range l.strings {
+		if strings.HasPrefix(rest, s.open) {
+			return s.end(content, i+len(s.open)), tokenString
+		}
+	}
+	return i, tokenCode
+}
+
+// end returns the offset just past the literal whose body starts at offset
+// j of content. An unterminated literal runs to the end of its line, or of
+// content when it may span lines.
+func (s stringDelim) end(content string, j int) int {
+	for j < len(content) {
+		switch {
+		case s.escape != 0 && content[j] == s.escape:
+			j += 2
+		case strings.HasPrefix(content[j:], s.close):
+			j += len(s.close)
+			if !s.doubled || !strings.HasPrefix(content[j:], s.close) {
+				return j
+			}
+			j += len(s.close)
+		case content[j] == '\n' && !s.multiline:
+			return j
+		default:
+			j++
+		}
+	}
+	return len(content)
+}
@@ -0,0 +1,140 @@
+package repfor
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	// Each mask has one character per byte of content: "." for code, "#" for
+	// comments and "s" for strings
+	tests := []struct {
+		lang, content, mask string
+	}{
+		{"go", `x := "a\"b" // c`, `.....ssssss.####`},
+		{"go", "s := `a\nb` /* c\nd */ 'x'", ".....sssss.#########.sss"},
+		{"js", "f(`a${b}`, 'c') // d", "..sssssss..sss..####"},
+		{"python", "x = '''a\n#b''' # c \"d\"", "....ssssssssss.#######"},
+		{"c", `/* a */ p("\\") // b`, `#######...ssss..####`},
+		{"shell", `echo $# "a # b" # c`, `........sssssss.###`},
+		{"shell", "echo 'a\\' b", ".....ssss.."},
+		{"sql", `SELECT "id" FROM t WHERE a = 'it''s' -- c`, `.............................sssssss.####`},
+		{"yaml", "url: http://x#y # c\nk: 'a''b'", "................###\n...ssssss"},
+		{"go", `x := "unterminated` + "\nfoo", ".....sssssssssssss\n..."},
+	}
+	for _, tt := range tests {
+		kinds := languages[tt.lang].tokenize(tt.content)
+		var b strings.Builder
+		for i, kind := range kinds {
+			switch {
+			case tt.content[i] == '\n' && kind == tokenCode:
+				b.WriteByte('\n')
+			case kind == tokenComment:
+				b.WriteByte('#')
+			case kind == tokenString:
+				b.WriteByte('s')
+			default:
+				b.WriteByte('.')
+			}
+		}
+		if b.String() != tt.mask {
+			t.Errorf("%s %q:\n got %q\nwant %q", tt.lang, tt.content, b.String(), tt.mask)
+		}
+	}
+}
+
+const tokenContent = "// Rename user here\nfunc user() string {\n\treturn \"user\" + user2\n}\n"
+
+func TestTokenScope(t *testing.T) {
+	tests := []struct {
+		scope string
+		want  string
+	}{
+		{ScopeCode, "// Rename user here\nfunc account() string {\n\treturn \"user\" + user2\n}\n"},
+		{ScopeComments, "// Rename account here\nfunc user() string {\n\treturn \"user\" + user2\n}\n"},
+		{ScopeStrings, "// Rename user here\nfunc user() string {\n\treturn \"account\" + user2\n}\n"},
+		{ScopeAll, "// Rename account here\nfunc account() string {\n\treturn \"account\" + user2\n}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scope, func(t *testing.T) {
+			tmpDir := setupTestDir(t)
+			defer cleanupTestDir(t, tmpDir)
+			path := createTestFile(t, tmpDir, "a.go", tokenContent)
+
+			opts := Options{Files: []string{path}, Search: "user", Replace: "account", WholeWord: true, Scope: tt.scope}
+			if _, err := New(opts).Run(context.Background()); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			if got := readFileContent(t, path); got != tt.want {
+				t.Errorf("content = %q\nwant      %q", got, tt.want)
+			}
+		})
+	}
+
+	// Token kinds index the original bytes, which lowering "İ" would shift
+	t.Run("case-insensitive non-ASCII", func(t *testing.T) {
+		tmpDir := setupTestDir(t)
+		defer cleanupTestDir(t, tmpDir)
+		path := createTestFile(t, tmpDir, "a.go", "İİ x // İ X\n")
+
+		opts := Options{Files: []string{path}, Search: "x", Replace: "y", CaseInsensitive: true, Scope: ScopeCode}
+		if _, err := New(opts).Run(context.Background()); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if got := readFileContent(t, path); got != "İİ y // İ X\n" {
+			t.Errorf("content = %q", got)
+		}
+	})
+}
+
+func TestTokenScope_Multiline(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.py", "'''a\nb'''\na\nb\n")
+
+	opts := Options{Files: []string{path}, Search: "a\nb", Replace: "c", Scope: ScopeCode}
+	if _, err := New(opts).Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got := readFileContent(t, path); got != "'''a\nb'''\nc\n" {
+		t.Errorf("content = %q", got)
+	}
+}
+
+func TestTokenScope_Languages(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "notes.txt", "x # x\n")
+
+	// Without a tokenizer the file is skipped with a warning
+	result, err := New(Options{Files: []string{path}, Search: "x", Replace: "y", Scope: ScopeCode}).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0].Message, "needs a language") {
+		t.Errorf("warnings = %+v", result.Warnings)
+	}
+
+	if _, err := New(Options{Files: []string{path}, Search: "x", Replace: "y", Scope: ScopeCode, Language: "shell"}).Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got := readFileContent(t, path); got != "y # x\n" {
+		t.Errorf("content = %q", got)
+	}
+
+	var out strings.Builder
+	if _, err := New(Options{Search: "x", Replace: "y", Scope: ScopeComments, Language: "sql"}).Filter(strings.NewReader("x -- x\n"), &out); err != nil {
+		t.Fatalf("Filter failed: %v", err)
+	}
+	if out.String() != "x -- y\n" {
+		t.Errorf("output = %q", out.String())
+	}
+
+	for _, opts := range []Options{{Scope: "docs"}, {Scope: ScopeCode, Language: "cobol"}} {
+		opts.Search = "x"
+		if _, err := New(opts).Run(context.Background()); err == nil {
+			t.Errorf("Run(%+v) should fail", opts)
+		}
+	}
+}
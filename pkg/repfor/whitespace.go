@@ -0,0 +1,59 @@
+package repfor
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// wholeContent reports whether the replacement works on the content of a
+// file as a whole rather than line by line: when a match can span lines.
+func (o Options) wholeContent() bool {
+	return isMultiline(o.Search, o.Replace) || o.IgnoreWhitespace
+}
+
+// validateWhitespace checks the whitespace options.
+func (o Options) validateWhitespace() error {
+	if o.IgnoreLineBreaks && !o.IgnoreWhitespace {
+		return errors.New("ignore line breaks needs ignore whitespace")
+	}
+	return nil
+}
+
+// whitespacePattern compiles search into a regular expression matching it
+// with flexible whitespace. A run of spaces and tabs matches any run of
+// them; a run holding line breaks matches as many line breaks, each with
+// any spaces and tabs around it. With lineBreaks any run of whitespace
+// matches any other.
+func whitespacePattern(search string, caseInsensitive, lineBreaks bool) *regexp.Regexp {
+	var b strings.Builder
+	if caseInsensitive {
+		b.WriteString("(?i)")
+	}
+	for len(search) > 0 {
+		n := len(search) - len(strings.TrimLeft(search, " \t\r\n"))
+		if n == 0 {
+			n = strings.IndexAny(search, " \t\r\n")
+			if n < 0 {
+				n = len(search)
+			}
+			b.WriteString(regexp.QuoteMeta(search[:n]))
+			search = search[n:]
+			continue
+		}
+		breaks := strings.Count(search[:n], "\n")
+		switch {
+		case lineBreaks:
+			b.WriteString(`\s+`)
+		case breaks == 0:
+			b.WriteString(`[ \t]+`)
+		default:
+			b.WriteString(`[ \t]*`)
+			for range breaks {
+				b.WriteString(`\r?\n[ \t]*`)
+			}
+		}
+		search = search[n:]
+	}
+	return regexp.MustCompile(b.String())
+}
@@ -0,0 +1,90 @@
+package repfor
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestWhitespacePattern(t *testing.T) {
+	tests := []struct {
+		search     string
+		lineBreaks bool
+		matches    []string
+		misses     []string
+	}{
+		{"foo(a, b)", false, []string{"foo(a,  b)", "foo(a,\tb)"}, []string{"foo(a,b)", "foo(a,\nb)"}},
+		{"if x {\n    y()\n}", false, []string{"if x {\n\ty()\n}", "if  x {\r\n\t\ty()\r\n  }", "if x {  \ny()\n}"}, []string{"if x {\n\ny()\n}", "if x { y() }"}},
+		{"a b\nc", true, []string{"a\nb c", "a \n\n b\tc"}, []string{"ab c"}},
+		{"x.*y", false, []string{"x.*y"}, []string{"xzzy"}},
+	}
+	for _, tt := range tests {
+		re := whitespacePattern(tt.search, false, tt.lineBreaks)
+		for _, s := range tt.matches {
+			if re.FindString(s) != s {
+				t.Errorf("%q should match %q", tt.search, s)
+			}
+		}
+		for _, s := range tt.misses {
+			if re.MatchString(s) {
+				t.Errorf("%q should not match %q", tt.search, s)
+			}
+		}
+	}
+}
+
+func TestIgnoreWhitespace(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.go", "func f() {\n\tif err != nil {\n\t\treturn err\n\t}\n}\n")
+
+	// Copied from a file indented with spaces
+	opts := Options{
+		Files:            []string{path},
+		Search:           "if err != nil {\n    return err\n}",
+		Replace:          "if err != nil {\n\t\treturn fmt.Errorf(\"f: %w\", err)\n\t}",
+		IgnoreWhitespace: true,
+	}
+	result, err := New(opts).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got, want := readFileContent(t, path), "func f() {\n\tif err != nil {\n\t\treturn fmt.Errorf(\"f: %w\", err)\n\t}\n}\n"; got != want {
+		t.Errorf("content = %q\nwant      %q", got, want)
+	}
+	// The matched span is reported even without CollectMatches
+	m := result.Directories[0].Files[0].Matches
+	if len(m) != 1 || m[0].Line != 2 || m[0].Column != 2 || m[0].Match != "if err != nil {\n\t\treturn err\n\t}" {
+		t.Errorf("matches = %+v", m)
+	}
+}
+
+func TestIgnoreWhitespace_SingleLine(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.txt", "x = foo(a,   b)\ny = foo(a,\tb) // keep\n")
+
+	opts := Options{Files: []string{path}, Search: "foo(a, b)", Replace: "bar(a, b)", IgnoreWhitespace: true, ExcludeLines: []string{"keep"}}
+	if _, err := New(opts).Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got := readFileContent(t, path); got != "x = bar(a, b)\ny = foo(a,\tb) // keep\n" {
+		t.Errorf("content = %q", got)
+	}
+}
+
+func TestIgnoreWhitespace_LineBreaks(t *testing.T) {
+	var out strings.Builder
+	opts := Options{Search: "the quick brown fox", Replace: "a fox", IgnoreWhitespace: true, IgnoreLineBreaks: true}
+	if _, err := New(opts).Filter(strings.NewReader("# the quick\n# brown fox\nthe quick\nbrown fox"), &out); err != nil {
+		t.Fatalf("Filter failed: %v", err)
+	}
+	// "#" is not whitespace; the unterminated last line stays unterminated
+	if out.String() != "# the quick\n# brown fox\na fox" {
+		t.Errorf("output = %q", out.String())
+	}
+
+	if _, err := New(Options{Search: "x", IgnoreLineBreaks: true}).Run(context.Background()); err == nil {
+		t.Error("ignore line breaks without ignore whitespace should fail")
+	}
+}
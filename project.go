@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hegner123/repfor/pkg/repfor"
+)
+
+// projectConfigName is the per-project configuration file, discovered by
+// walking up from the directory repfor operates on.
+const projectConfigName = ".repfor.json"
+
+type ProjectConfig struct {
+	// Defaults apply to every run under the project; a selected profile is
+	// layered on top of them.
+	Defaults OptionDefaults            `json:"defaults,omitempty"`
+	Profiles map[string]OptionDefaults `json:"profiles,omitempty"`
+
+	// Prompts are team-specific MCP prompt templates, listed alongside the
+	// built-in recipes. A project prompt with a built-in's name replaces it.
+	Prompts []PromptTemplate `json:"prompts,omitempty"`
+
+	path string // file the config was loaded from
+}
+
+// OptionDefaults are option values a project supplies when a call does not
+// set them itself. Nil (or empty) fields leave the option alone.
+type OptionDefaults struct {
+	Ext             *string  `json:"ext,omitempty"`
+	ExcludeFiles    []string `json:"exclude_files,omitempty"`
+	ExcludeLines    []string `json:"exclude_lines,omitempty"`
+	Recursive       *bool    `json:"recursive,omitempty"`
+	CaseInsensitive *bool    `json:"case_insensitive,omitempty"`
+	WholeWord       *bool    `json:"whole_word,omitempty"`
+}
+
+// apply sets every option of config that d provides and explicit does not
+// report as set by the caller. Options are named as in the MCP tool arguments.
+func (d OptionDefaults) apply(config *Config, explicit func(option string) bool) {
+	if d.Ext != nil && !explicit("ext") {
+		config.Ext = *d.Ext
+	}
+	if d.ExcludeFiles != nil && !explicit("exclude_files") {
+		config.ExcludeFiles = d.ExcludeFiles
+	}
+	if d.ExcludeLines != nil && !explicit("exclude_lines") {
+		config.ExcludeLines = d.ExcludeLines
+	}
+	if d.Recursive != nil && !explicit("recursive") {
+		config.Recursive = *d.Recursive
+	}
+	if d.CaseInsensitive != nil && !explicit("case_insensitive") {
+		config.CaseInsensitive = *d.CaseInsensitive
+	}
+	if d.WholeWord != nil && !explicit("whole_word") {
+		config.WholeWord = *d.WholeWord
+	}
+}
+
+// findProjectConfig walks up from dir looking for .repfor.json and returns its
+// path, or "" when none exists up to the filesystem root. The walk stops
+// where it leaves sandbox, so a config outside the allowed roots is never
+// read.
+func findProjectConfig(dir string, sandbox *repfor.Sandbox) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		candidate := filepath.Join(abs, projectConfigName)
+		if sandbox.Check(candidate) != nil {
+			return "", nil
+		}
+		info, err := os.Stat(candidate)
+		if err == nil && info.Mode().IsRegular() {
+			return candidate, nil
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			return "", nil
+		}
+		abs = parent
+	}
+}
+
+func loadProjectConfig(path string) (*ProjectConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var pc ProjectConfig
+	if err := json.Unmarshal(data, &pc); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	pc.path = path
+
+	for i, p := range pc.Prompts {
+		if p.Name == "" {
+			return nil, fmt.Errorf("invalid %s: prompt %d has no name", path, i)
+		}
+		if p.Template == "" {
+			return nil, fmt.Errorf("invalid %s: prompt %q has no template", path, p.Name)
+		}
+	}
+	for name := range pc.Profiles {
+		if name == "" {
+			return nil, fmt.Errorf("invalid %s: profile with empty name", path)
+		}
+	}
+	return &pc, nil
+}
+
+// discoverProjectConfig finds and loads the project config governing dir
+// within sandbox. It returns nil without error when there is none.
+func discoverProjectConfig(dir string, sandbox *repfor.Sandbox) (*ProjectConfig, error) {
+	path, err := findProjectConfig(dir, sandbox)
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return nil, nil
+	}
+	return loadProjectConfig(path)
+}
+
+// applyProjectDefaults fills in the options of config from the project config
+// governing its target. From lowest to highest precedence the layers are:
+// built-in defaults, the config's "defaults", the profile named by
+// config.Profile, and finally the options the caller set explicitly.
+func applyProjectDefaults(config *Config, explicit func(option string) bool) error {
+	pc, err := discoverProjectConfig(projectDir(*config), config.Sandbox)
+	if err != nil {
+		return err
+	}
+	if pc == nil {
+		if config.Profile != "" {
+			return fmt.Errorf("profile %q requested but no %s was found", config.Profile, projectConfigName)
+		}
+		return nil
+	}
+
+	pc.Defaults.apply(config, explicit)
+	if config.Profile != "" {
+		profile, ok := pc.Profiles[config.Profile]
+		if !ok {
+			names := make([]string, 0, len(pc.Profiles))
+			for name := range pc.Profiles {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("unknown profile %q in %s (available: %s)", config.Profile, pc.path, strings.Join(names, ", "))
+		}
+		profile.apply(config, explicit)
+	}
+	return nil
+}
+
+// projectDir is the directory whose project config governs a run: that of the
+// first file in file mode, else the first directory.
+func projectDir(config Config) string {
+	if len(config.Files) > 0 {
+		return filepath.Dir(config.Files[0])
+	}
+	if len(config.Dirs) > 0 {
+		return config.Dirs[0]
+	}
+	return "."
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hegner123/repfor/pkg/repfor"
+)
+
+const testProjectDefaults = `{
+  "defaults": {"ext": ".go", "exclude_lines": ["// keep"], "recursive": true},
+  "profiles": {
+    "docs": {"ext": ".md", "whole_word": true}
+  }
+}`
+
+func TestApplyProjectDefaults_Precedence(t *testing.T) {
+	root := setupTestDir(t)
+	defer cleanupTestDir(t, root)
+	createTestFile(t, root, projectConfigName, testProjectDefaults)
+
+	none := func(string) bool { return false }
+
+	tests := []struct {
+		name      string
+		profile   string
+		explicit  func(string) bool
+		preset    Config
+		ext       string
+		wholeWord bool
+		recursive bool
+	}{
+		{name: "defaults only", explicit: none, ext: ".go", recursive: true},
+		{name: "profile over defaults", profile: "docs", explicit: none, ext: ".md", wholeWord: true, recursive: true},
+		{
+			name:      "explicit over profile",
+			profile:   "docs",
+			explicit:  func(o string) bool { return o == "ext" || o == "recursive" },
+			preset:    Config{Options: repfor.Options{Ext: ".txt"}},
+			ext:       ".txt",
+			wholeWord: true,
+			recursive: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.preset
+			config.Dirs = []string{root}
+			config.Profile = tt.profile
+			if err := applyProjectDefaults(&config, tt.explicit); err != nil {
+				t.Fatalf("applyProjectDefaults failed: %v", err)
+			}
+			if config.Ext != tt.ext || config.WholeWord != tt.wholeWord || config.Recursive != tt.recursive {
+				t.Errorf("got ext=%q whole_word=%v recursive=%v", config.Ext, config.WholeWord, config.Recursive)
+			}
+			if len(config.ExcludeLines) != 1 || config.ExcludeLines[0] != "// keep" {
+				t.Errorf("exclude_lines from defaults not applied: %v", config.ExcludeLines)
+			}
+		})
+	}
+}
+
+func TestApplyProjectDefaults_ProfileErrors(t *testing.T) {
+	root := setupTestDir(t)
+	defer cleanupTestDir(t, root)
+	none := func(string) bool { return false }
+
+	config := Config{Options: repfor.Options{Dirs: []string{root}}, Profile: "go"}
+	if err := applyProjectDefaults(&config, none); err == nil {
+		t.Error("Expected error for profile without a project config")
+	}
+
+	createTestFile(t, root, projectConfigName, testProjectDefaults)
+	err := applyProjectDefaults(&config, none)
+	if err == nil || !strings.Contains(err.Error(), "available: docs") {
+		t.Errorf("Expected unknown profile error listing profiles, got %v", err)
+	}
+}
+
+func TestToolsCall_ProjectProfile(t *testing.T) {
+	root := setupTestDir(t)
+	defer cleanupTestDir(t, root)
+	createTestFile(t, root, projectConfigName, testProjectDefaults)
+	goFile := createTestFile(t, root, "a.go", "foo()\nfoo() // keep\n")
+	mdFile := createTestFile(t, root, "a.md", "foo and foobar\n")
+
+	var buf bytes.Buffer
+	sess := newStdioSession(&buf, serverOptions{})
+
+	resp := handleRequest(context.Background(), sess, toolsCallRequest(t, map[string]any{"dir": root, "search": "foo", "replace": "bar"}))
+	if resp.Error != nil {
+		t.Fatalf("tools/call failed: %s", resp.Error.Message)
+	}
+	if got := readFileContent(t, goFile); got != "bar()\nfoo() // keep\n" {
+		t.Errorf("defaults not applied to .go file: %q", got)
+	}
+	if got := readFileContent(t, mdFile); got != "foo and foobar\n" {
+		t.Errorf(".md file should be filtered out by default ext: %q", got)
+	}
+
+	resp = handleRequest(context.Background(), sess, toolsCallRequest(t, map[string]any{"dir": root, "search": "foo", "replace": "bar", "profile": "docs"}))
+	if resp.Error != nil {
+		t.Fatalf("tools/call with profile failed: %s", resp.Error.Message)
+	}
+	if got := readFileContent(t, mdFile); got != "bar and foobar\n" {
+		t.Errorf("docs profile (whole_word) not applied: %q", got)
+	}
+
+	resp = handleRequest(context.Background(), sess, toolsCallRequest(t, map[string]any{"dir": root, "search": "foo", "replace": "bar", "profile": "nope"}))
+	if resp.Error == nil {
+		t.Error("Expected error for unknown profile")
+	}
+}
+
+func TestCLI_ProfileFlag(t *testing.T) {
+	t.Setenv(stateDirEnv, t.TempDir())
+	root := setupTestDir(t)
+	defer cleanupTestDir(t, root)
+	createTestFile(t, root, projectConfigName, testProjectDefaults)
+	createTestFile(t, root, "a.md", "foo foobar\n")
+
+	code, stdout, stderr := runCLI(t, "search", "--dir", root, "--search", "foo", "--profile", "docs")
+	if code != ExitSuccess {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "Found 1 match in 1 file") {
+		t.Errorf("profile not applied: %s", stdout)
+	}
+
+	// An explicit flag beats the profile
+	code, stdout, _ = runCLI(t, "search", "--dir", root, "--search", "foo", "--profile", "docs", "--whole-word=false")
+	if code != ExitSuccess || !strings.Contains(stdout, "Found 2 matches") {
+		t.Errorf("explicit --whole-word=false should override profile: %s", stdout)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+)
+
+// MCP prompts: parameterized recipes that walk an agent through the usual
+// search -> dry-run diff -> apply loop with the repfor tool. Teams can add
+// their own under "prompts" in .repfor.json.
+
+// PromptTemplate defines one prompt. Template is rendered into a single user
+// message, with {{name}} replaced by the argument of that name.
+type PromptTemplate struct {
+	Name        string              `json:"name"`
+	Description string              `json:"description,omitempty"`
+	Arguments   []PromptArgumentDef `json:"arguments,omitempty"`
+	Template    string              `json:"template"`
+}
+
+type PromptArgumentDef struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+	Default     string `json:"default,omitempty"` // used when an optional argument is omitted
+}
+
+// MCP prompt wire types
+
+type Prompt struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description,omitempty"`
+	Arguments   []PromptArgument `json:"arguments,omitempty"`
+}
+
+type PromptArgument struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+}
+
+type PromptsListResult struct {
+	Prompts []Prompt `json:"prompts"`
+}
+
+type PromptGetParams struct {
+	Name      string            `json:"name"`
+	Arguments map[string]string `json:"arguments,omitempty"`
+}
+
+type PromptMessage struct {
+	Role    string      `json:"role"`
+	Content ContentItem `json:"content"`
+}
+
+type PromptGetResult struct {
+	Description string          `json:"description,omitempty"`
+	Messages    []PromptMessage `json:"messages"`
+}
+
+// The closing steps shared by every built-in recipe.
+const reviewAndApplySteps = `
+3. Read the resource repfor://runs/latest and review every per-file diff. If anything was matched that should not change, refine the call (exclude_lines, exclude_files, ext, whole_word) and dry-run again.
+4. When the diff is exactly what you want, repeat the same call with dry_run: false.
+5. Read repfor://runs/latest once more to confirm the applied run matches the reviewed dry run, then report the summary.`
+
+var builtinPrompts = []PromptTemplate{
+	{
+		Name:        "rename-go-symbol",
+		Description: "Safely rename a Go identifier across a module",
+		Arguments: []PromptArgumentDef{
+			{Name: "old_name", Description: "Current identifier", Required: true},
+			{Name: "new_name", Description: "New identifier", Required: true},
+			{Name: "dir", Description: "Module or package directory", Default: "."},
+		},
+		Template: `Rename the Go identifier {{old_name}} to {{new_name}} under {{dir}} using the repfor tool.
+
+1. Search first: call repfor with dir: "{{dir}}", search: "{{old_name}}", replace: "{{new_name}}", ext: ".go", whole_word: true, recursive: true, dry_run: true. Whole-word matching keeps identifiers such as {{old_name}}s or my{{old_name}} intact.
+2. If {{new_name}} already appears in the tree, stop and report the collision instead of continuing.` + reviewAndApplySteps + `
+6. Run "go build ./..." and "go vet ./..." to confirm the rename compiles; exported names may also be referenced from other modules.`,
+	},
+	{
+		Name:        "migrate-import-path",
+		Description: "Move Go imports from one module or package path to another",
+		Arguments: []PromptArgumentDef{
+			{Name: "old_path", Description: "Import path being replaced, e.g. github.com/old/pkg", Required: true},
+			{Name: "new_path", Description: "Replacement import path", Required: true},
+			{Name: "dir", Description: "Repository directory", Default: "."},
+		},
+		Template: `Migrate imports of {{old_path}} to {{new_path}} under {{dir}} using the repfor tool.
+
+1. Search first, as two separate dry runs with dir: "{{dir}}", ext: ".go", recursive: true, dry_run: true: one with search: "\"{{old_path}}\"", replace: "\"{{new_path}}\"" for the path itself, and one with search: "\"{{old_path}}/", replace: "\"{{new_path}}/" for its subpackages. Do not search for "\"{{old_path}}" alone: it also matches longer paths that merely share a prefix, such as "{{old_path}}extra".
+2. Repeat the dry run for go.mod with file: "{{dir}}/go.mod", search: "{{old_path}}", replace: "{{new_path}}", whole_word: false, and drop any match that belongs to a longer path.` + reviewAndApplySteps + `
+6. Run "go mod tidy" and "go build ./..." to confirm the new path resolves.`,
+	},
+	{
+		Name:        "bump-version",
+		Description: "Bump a version string across manifests and docs",
+		Arguments: []PromptArgumentDef{
+			{Name: "old_version", Description: "Current version, e.g. 1.4.2", Required: true},
+			{Name: "new_version", Description: "New version, e.g. 1.5.0", Required: true},
+			{Name: "dir", Description: "Repository directory", Default: "."},
+		},
+		Template: `Bump the version {{old_version}} to {{new_version}} under {{dir}} using the repfor tool.
+
+1. Search first: call repfor with dir: "{{dir}}", search: "{{old_version}}", replace: "{{new_version}}", recursive: true, dry_run: true, exclude_files: ["lock", ".sum"]. Lock files and checksums pin dependency versions and must not change. Leave whole_word off so tags like v{{old_version}} still match, and watch for longer versions such as {{old_version}}0 in the diff.
+2. Version strings are not unique: the same number may belong to a dependency. For every match in a manifest (package.json, Cargo.toml, pyproject.toml, Chart.yaml, go.mod, VERSION) check it is this project's own version; otherwise narrow the call with file: [...] or exclude_lines.` + reviewAndApplySteps + `
+6. Mention any remaining occurrences of {{old_version}} (e.g. in a CHANGELOG) that were intentionally left unchanged.`,
+	},
+}
+
+var placeholderPattern = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// renderPrompt validates args against the template and substitutes them.
+func renderPrompt(p PromptTemplate, args map[string]string) (string, error) {
+	values := make(map[string]string, len(p.Arguments))
+	for _, def := range p.Arguments {
+		value, ok := args[def.Name]
+		if !ok || value == "" {
+			if def.Required {
+				return "", fmt.Errorf("missing required argument %q", def.Name)
+			}
+			value = def.Default
+		}
+		values[def.Name] = value
+	}
+
+	text := placeholderPattern.ReplaceAllStringFunc(p.Template, func(m string) string {
+		name := placeholderPattern.FindStringSubmatch(m)[1]
+		if value, ok := values[name]; ok {
+			return value
+		}
+		return m
+	})
+	return text, nil
+}
+
+// prompts returns the built-in prompts merged with those from the project
+// configs of the session's workspace, sorted by name.
+func (s *session) prompts() []PromptTemplate {
+	byName := make(map[string]PromptTemplate, len(builtinPrompts))
+	for _, p := range builtinPrompts {
+		byName[p.Name] = p
+	}
+
+	sandbox, err := s.sandbox()
+	if err != nil {
+		sandbox = s.serverRoots // client roots still to come
+	}
+	seen := make(map[string]bool)
+	for _, dir := range s.workspaceDirs() {
+		pc, err := discoverProjectConfig(dir, sandbox)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			continue
+		}
+		if pc == nil || seen[pc.path] {
+			continue
+		}
+		seen[pc.path] = true
+		for _, p := range pc.Prompts {
+			byName[p.Name] = p
+		}
+	}
+
+	list := make([]PromptTemplate, 0, len(byName))
+	for _, p := range byName {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// workspaceDirs returns the directories the session works in: the client's
+// roots when known, else the --root directories, else the working directory.
+func (s *session) workspaceDirs() []string {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	if roots := s.clientRoots.Roots(); len(roots) > 0 {
+		return roots
+	}
+	if roots := s.serverRoots.Roots(); len(roots) > 0 {
+		return roots
+	}
+	return []string{"."}
+}
+
+func handlePromptsList(sess *session, req JSONRPCRequest) *JSONRPCResponse {
+	templates := sess.prompts()
+	result := PromptsListResult{Prompts: make([]Prompt, 0, len(templates))}
+	for _, t := range templates {
+		p := Prompt{Name: t.Name, Description: t.Description}
+		for _, a := range t.Arguments {
+			p.Arguments = append(p.Arguments, PromptArgument{Name: a.Name, Description: a.Description, Required: a.Required})
+		}
+		result.Prompts = append(result.Prompts, p)
+	}
+	return newResponse(req.ID, result)
+}
+
+func handlePromptsGet(sess *session, req JSONRPCRequest) *JSONRPCResponse {
+	var params PromptGetParams
+	if err := json.Unmarshal(req.Params, &params); err != nil || params.Name == "" {
+		return newError(req.ID, -32602, "Invalid params")
+	}
+
+	for _, t := range sess.prompts() {
+		if t.Name != params.Name {
+			continue
+		}
+		text, err := renderPrompt(t, params.Arguments)
+		if err != nil {
+			return newError(req.ID, -32602, err.Error())
+		}
+		return newResponse(req.ID, PromptGetResult{
+			Description: t.Description,
+			Messages: []PromptMessage{
+				{Role: "user", Content: ContentItem{Type: "text", Text: text}},
+			},
+		})
+	}
+
+	return newError(req.ID, -32602, "Unknown prompt: "+params.Name)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hegner123/repfor/pkg/repfor"
+)
+
+func getPrompt(t *testing.T, sess *session, name string, args map[string]string) *JSONRPCResponse {
+	t.Helper()
+	params, _ := json.Marshal(PromptGetParams{Name: name, Arguments: args})
+	return handleRequest(context.Background(), sess, JSONRPCRequest{JSONRPC: "2.0", ID: float64(1), Method: "prompts/get", Params: params})
+}
+
+func TestPrompts_ListBuiltins(t *testing.T) {
+	var buf bytes.Buffer
+	sess := newStdioSession(&buf, serverOptions{})
+
+	resp := handleRequest(context.Background(), sess, JSONRPCRequest{JSONRPC: "2.0", ID: float64(1), Method: "prompts/list"})
+	prompts := resp.Result.(PromptsListResult).Prompts
+
+	names := make(map[string]bool)
+	for _, p := range prompts {
+		names[p.Name] = true
+	}
+	for _, want := range []string{"rename-go-symbol", "migrate-import-path", "bump-version"} {
+		if !names[want] {
+			t.Errorf("prompts/list missing %q", want)
+		}
+	}
+}
+
+func TestPrompts_GetRendersArguments(t *testing.T) {
+	var buf bytes.Buffer
+	sess := newStdioSession(&buf, serverOptions{})
+
+	resp := getPrompt(t, sess, "rename-go-symbol", map[string]string{"old_name": "FooBar", "new_name": "BazQux"})
+	if resp.Error != nil {
+		t.Fatalf("prompts/get failed: %s", resp.Error.Message)
+	}
+	msgs := resp.Result.(PromptGetResult).Messages
+	if len(msgs) != 1 || msgs[0].Role != "user" {
+		t.Fatalf("Expected one user message, got %+v", msgs)
+	}
+	text := msgs[0].Content.Text
+	for _, want := range []string{`search: "FooBar"`, `replace: "BazQux"`, `dir: "."`, "dry_run: true", "repfor://runs/latest"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("rendered prompt missing %q:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "{{") {
+		t.Errorf("rendered prompt has unreplaced placeholders:\n%s", text)
+	}
+}
+
+func TestPrompts_GetErrors(t *testing.T) {
+	var buf bytes.Buffer
+	sess := newStdioSession(&buf, serverOptions{})
+
+	if resp := getPrompt(t, sess, "bump-version", map[string]string{"old_version": "1.0.0"}); resp.Error == nil {
+		t.Error("Expected error for missing required argument")
+	}
+	if resp := getPrompt(t, sess, "no-such-prompt", nil); resp.Error == nil {
+		t.Error("Expected error for unknown prompt")
+	}
+}
+
+func TestRenderPrompt_UnknownPlaceholderKept(t *testing.T) {
+	p := PromptTemplate{
+		Name:      "x",
+		Arguments: []PromptArgumentDef{{Name: "a", Default: "A"}},
+		Template:  "{{a}} {{ a }} {{b}}",
+	}
+	got, err := renderPrompt(p, nil)
+	if err != nil {
+		t.Fatalf("renderPrompt failed: %v", err)
+	}
+	if got != "A A {{b}}" {
+		t.Errorf("renderPrompt = %q, want %q", got, "A A {{b}}")
+	}
+}
+
+func TestPrompts_ProjectConfig(t *testing.T) {
+	root := setupTestDir(t)
+	defer cleanupTestDir(t, root)
+	createTestFile(t, root, projectConfigName, `{
+  "prompts": [
+    {
+      "name": "rename-handler",
+      "description": "Rename an HTTP handler",
+      "arguments": [{"name": "old", "required": true}, {"name": "new", "required": true}],
+      "template": "Rename handler {{old}} to {{new}}"
+    },
+    {
+      "name": "bump-version",
+      "template": "Team-specific bump"
+    }
+  ]
+}`)
+
+	sb, _ := repfor.NewSandbox([]string{root})
+	var buf bytes.Buffer
+	sess := newStdioSession(&buf, serverOptions{roots: sb})
+
+	resp := getPrompt(t, sess, "rename-handler", map[string]string{"old": "getUser", "new": "fetchUser"})
+	if resp.Error != nil {
+		t.Fatalf("project prompt failed: %s", resp.Error.Message)
+	}
+	if text := resp.Result.(PromptGetResult).Messages[0].Content.Text; text != "Rename handler getUser to fetchUser" {
+		t.Errorf("project prompt rendered %q", text)
+	}
+
+	resp = getPrompt(t, sess, "bump-version", nil)
+	if resp.Error != nil || resp.Result.(PromptGetResult).Messages[0].Content.Text != "Team-specific bump" {
+		t.Errorf("project prompt should override built-in, got %+v", resp)
+	}
+}
+
+func TestFindProjectConfig_WalksUp(t *testing.T) {
+	root := setupTestDir(t)
+	defer cleanupTestDir(t, root)
+	configPath := createTestFile(t, root, projectConfigName, `{}`)
+	nested := filepath.Join(root, "a", "b")
+	if err := os.MkdirAll(nested, 0755); err != nil {
+		t.Fatalf("Failed to create dirs: %v", err)
+	}
+
+	got, err := findProjectConfig(nested, nil)
+	if err != nil {
+		t.Fatalf("findProjectConfig failed: %v", err)
+	}
+	if got != configPath {
+		t.Errorf("findProjectConfig = %q, want %q", got, configPath)
+	}
+
+	// The walk stops at the sandbox root
+	sandbox, err := repfor.NewSandbox([]string{filepath.Join(root, "a")})
+	if err != nil {
+		t.Fatalf("NewSandbox failed: %v", err)
+	}
+	if got, err := findProjectConfig(nested, sandbox); err != nil || got != "" {
+		t.Errorf("findProjectConfig in sandbox = %q, %v; want none", got, err)
+	}
+}
+
+func TestLoadProjectConfig_Invalid(t *testing.T) {
+	root := setupTestDir(t)
+	defer cleanupTestDir(t, root)
+
+	for name, content := range map[string]string{
+		"syntax":      `{"prompts": [`,
+		"no name":     `{"prompts": [{"template": "x"}]}`,
+		"no template": `{"prompts": [{"name": "x"}]}`,
+	} {
+		path := createTestFile(t, root, projectConfigName, content)
+		if _, err := loadProjectConfig(path); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hegner123/repfor/pkg/repfor"
+)
+
+// MCP roots (client capability). After initialization the server asks the
+// client for its workspace roots with roots/list and asks again whenever the
+// client sends notifications/roots/list_changed.
+
+// rootsTimeout bounds how long tool calls wait for the first roots/list
+// answer before falling back to the --root directories.
+const rootsTimeout = 10 * time.Second
+
+type Root struct {
+	URI  string `json:"uri"`
+	Name string `json:"name,omitempty"`
+}
+
+type RootsListResult struct {
+	Roots []Root `json:"roots"`
+}
+
+// requestRoots sends a roots/list request to the client and records the
+// answer on the session when it arrives.
+func (s *session) requestRoots() {
+	s.state.Lock()
+	if s.rootsAsked.IsZero() {
+		s.rootsAsked = time.Now()
+	}
+	s.state.Unlock()
+
+	s.request("roots/list", nil, func(msg JSONRPCRequest) {
+		if msg.Error != nil {
+			s.rootsUnavailable("roots/list failed: " + msg.Error.Message)
+			return
+		}
+
+		var result RootsListResult
+		if err := json.Unmarshal(msg.Result, &result); err != nil {
+			s.rootsUnavailable(fmt.Sprintf("invalid roots/list result: %v", err))
+			return
+		}
+
+		// An empty list leaves clientRoots nil: the client imposes no restriction.
+		// Roots that cannot be resolved are dropped, never widened.
+		var clientRoots *repfor.Sandbox
+		if len(result.Roots) > 0 {
+			var valid []string
+			for _, root := range result.Roots {
+				sb, err := rootSandbox(root.URI)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: ignoring root %s: %v\n", root.URI, err)
+					continue
+				}
+				valid = append(valid, sb.Roots()...)
+			}
+			var err error
+			if clientRoots, err = repfor.NewSandbox(valid); err != nil {
+				// A root vanished since it was resolved: allow nothing rather than everything
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+				clientRoots, _ = repfor.NewSandbox(nil)
+			}
+		}
+
+		s.state.Lock()
+		s.clientRoots = clientRoots
+		s.rootsReceived = true
+		s.state.Unlock()
+	})
+}
+
+// rootsUnavailable settles the session's roots when the client cannot report
+// them, so tool calls stop waiting for an answer that will not come. Roots
+// already received stay in force; otherwise only the --root directories
+// restrict the session.
+func (s *session) rootsUnavailable(reason string) {
+	s.state.Lock()
+	defer s.state.Unlock()
+	s.rootsFallback(reason)
+}
+
+// rootsFallback is rootsUnavailable for a caller holding s.state.
+func (s *session) rootsFallback(reason string) {
+	if s.rootsReceived {
+		fmt.Fprintf(os.Stderr, "Warning: %s; keeping the previous roots\n", reason)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %s; using the --root directories only\n", reason)
+	s.rootsReceived = true
+}
+
+func rootSandbox(uri string) (*repfor.Sandbox, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "file" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	return repfor.NewSandbox([]string{filepath.FromSlash(u.Path)})
+}
+
+// sandbox returns the effective restriction for tool calls in this session:
+// the server's --root flags intersected with the roots the client reported.
+// A client that has not answered roots/list within rootsTimeout is treated as
+// having failed to; its roots still apply if the answer arrives later.
+func (s *session) sandbox() (*repfor.Sandbox, error) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	if s.supportsRoots && !s.rootsReceived && !s.rootsAsked.IsZero() && time.Since(s.rootsAsked) > rootsTimeout {
+		s.rootsFallback("no roots/list response after " + rootsTimeout.String())
+	}
+	if s.supportsRoots && !s.rootsReceived {
+		return nil, errors.New("workspace roots not yet received from client, retry shortly")
+	}
+	return s.serverRoots.Intersect(s.clientRoots), nil
+}
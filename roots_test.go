@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hegner123/repfor/pkg/repfor"
+)
+
+// rpcLines decodes each newline-delimited message written to a stdio session
+func rpcLines(t *testing.T, buf *bytes.Buffer) []JSONRPCRequest {
+	t.Helper()
+	var msgs []JSONRPCRequest
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var msg JSONRPCRequest
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			t.Fatalf("Invalid message %q: %v", line, err)
+		}
+		msgs = append(msgs, msg)
+	}
+	buf.Reset()
+	return msgs
+}
+
+func toolsCallRequest(t *testing.T, args map[string]any) JSONRPCRequest {
+	t.Helper()
+	params, err := json.Marshal(map[string]any{"name": "repfor", "arguments": args})
+	if err != nil {
+		t.Fatalf("Failed to marshal params: %v", err)
+	}
+	return JSONRPCRequest{JSONRPC: "2.0", ID: float64(10), Method: "tools/call", Params: params}
+}
+
+func TestSession_RootsNegotiation(t *testing.T) {
+	root := setupTestDir(t)
+	defer cleanupTestDir(t, root)
+	outside := setupTestDir(t)
+	defer cleanupTestDir(t, outside)
+	createTestFile(t, root, "a.txt", "hello\n")
+	createTestFile(t, outside, "b.txt", "hello\n")
+
+	var buf bytes.Buffer
+	sess := newStdioSession(&buf, serverOptions{})
+
+	handleRequest(context.Background(), sess, JSONRPCRequest{
+		JSONRPC: "2.0", ID: float64(1), Method: "initialize",
+		Params: json.RawMessage(`{"protocolVersion":"2025-03-26","capabilities":{"roots":{"listChanged":true}}}`),
+	})
+	handleRequest(context.Background(), sess, JSONRPCRequest{JSONRPC: "2.0", Method: "notifications/initialized"})
+
+	var rootsReq *JSONRPCRequest
+	for _, msg := range rpcLines(t, &buf) {
+		if msg.Method == "roots/list" {
+			rootsReq = &msg
+		}
+	}
+	if rootsReq == nil {
+		t.Fatal("Expected server to send roots/list after initialization")
+	}
+
+	// Tool calls are refused until the client has answered
+	resp := handleRequest(context.Background(), sess, toolsCallRequest(t, map[string]any{"dir": root, "search": "hello", "replace": "bye"}))
+	if resp.Error == nil {
+		t.Fatal("Expected tool call to fail before roots are known")
+	}
+
+	rootURI := (&url.URL{Scheme: "file", Path: filepath.ToSlash(root)}).String()
+	handleRequest(context.Background(), sess, JSONRPCRequest{
+		JSONRPC: "2.0", ID: rootsReq.ID,
+		Result: json.RawMessage(`{"roots":[{"uri":"` + rootURI + `","name":"ws"}]}`),
+	})
+
+	resp = handleRequest(context.Background(), sess, toolsCallRequest(t, map[string]any{"dir": outside, "search": "hello", "replace": "bye"}))
+	if resp.Error == nil || !strings.Contains(resp.Error.Message, "outside the allowed roots") {
+		t.Errorf("Expected rejection outside client roots, got %+v", resp)
+	}
+
+	resp = handleRequest(context.Background(), sess, toolsCallRequest(t, map[string]any{"dir": root, "search": "hello", "replace": "bye"}))
+	if resp.Error != nil {
+		t.Errorf("Expected call inside roots to succeed, got %v", resp.Error.Message)
+	}
+	if content := readFileContent(t, filepath.Join(root, "a.txt")); content != "bye\n" {
+		t.Errorf("content = %q, want %q", content, "bye\n")
+	}
+
+	// A list_changed notification triggers a fresh roots/list request
+	rpcLines(t, &buf)
+	handleRequest(context.Background(), sess, JSONRPCRequest{JSONRPC: "2.0", Method: "notifications/roots/list_changed"})
+	msgs := rpcLines(t, &buf)
+	if len(msgs) != 1 || msgs[0].Method != "roots/list" || msgs[0].ID == rootsReq.ID {
+		t.Errorf("Expected a new roots/list request, got %+v", msgs)
+	}
+}
+
+func TestSession_ServerRootsWithoutClientRoots(t *testing.T) {
+	root := setupTestDir(t)
+	defer cleanupTestDir(t, root)
+	outside := setupTestDir(t)
+	defer cleanupTestDir(t, outside)
+
+	sb, _ := repfor.NewSandbox([]string{root})
+	var buf bytes.Buffer
+	sess := newStdioSession(&buf, serverOptions{roots: sb})
+
+	handleRequest(context.Background(), sess, JSONRPCRequest{JSONRPC: "2.0", ID: float64(1), Method: "initialize"})
+	handleRequest(context.Background(), sess, JSONRPCRequest{JSONRPC: "2.0", Method: "notifications/initialized"})
+	for _, msg := range rpcLines(t, &buf) {
+		if msg.Method == "roots/list" {
+			t.Error("Server should not request roots from a client without the capability")
+		}
+	}
+
+	resp := handleRequest(context.Background(), sess, toolsCallRequest(t, map[string]any{"dir": outside, "search": "a", "replace": "b"}))
+	if resp.Error == nil {
+		t.Error("Expected --root restriction to apply without client roots")
+	}
+}
+
+func TestSession_RootsListError(t *testing.T) {
+	root := setupTestDir(t)
+	defer cleanupTestDir(t, root)
+	outside := setupTestDir(t)
+	defer cleanupTestDir(t, outside)
+	createTestFile(t, root, "a.txt", "hello\n")
+
+	sb, _ := repfor.NewSandbox([]string{root})
+	var buf bytes.Buffer
+	sess := newStdioSession(&buf, serverOptions{roots: sb})
+	handleRequest(context.Background(), sess, JSONRPCRequest{
+		JSONRPC: "2.0", ID: float64(1), Method: "initialize",
+		Params: json.RawMessage(`{"protocolVersion":"2025-03-26","capabilities":{"roots":{}}}`),
+	})
+	handleRequest(context.Background(), sess, JSONRPCRequest{JSONRPC: "2.0", Method: "notifications/initialized"})
+	var rootsID any
+	for _, msg := range rpcLines(t, &buf) {
+		if msg.Method == "roots/list" {
+			rootsID = msg.ID
+		}
+	}
+
+	// A failed roots/list falls back to the --root directories
+	handleRequest(context.Background(), sess, JSONRPCRequest{JSONRPC: "2.0", ID: rootsID, Error: &Error{Code: -32601, Message: "Method not found"}})
+	resp := handleRequest(context.Background(), sess, toolsCallRequest(t, map[string]any{"dir": root, "search": "hello", "replace": "bye"}))
+	if resp.Error != nil {
+		t.Errorf("Expected call inside --root to succeed, got %v", resp.Error.Message)
+	}
+	resp = handleRequest(context.Background(), sess, toolsCallRequest(t, map[string]any{"dir": outside, "search": "hello", "replace": "bye"}))
+	if resp.Error == nil || !strings.Contains(resp.Error.Message, "outside the allowed roots") {
+		t.Errorf("Expected rejection outside --root, got %+v", resp)
+	}
+}
+
+func TestSession_RootsTimeout(t *testing.T) {
+	root := setupTestDir(t)
+	defer cleanupTestDir(t, root)
+	outside := setupTestDir(t)
+	defer cleanupTestDir(t, outside)
+	createTestFile(t, root, "a.txt", "hello\n")
+
+	sb, _ := repfor.NewSandbox([]string{root})
+	var buf bytes.Buffer
+	sess := newStdioSession(&buf, serverOptions{roots: sb})
+	handleRequest(context.Background(), sess, JSONRPCRequest{
+		JSONRPC: "2.0", ID: float64(1), Method: "initialize",
+		Params: json.RawMessage(`{"protocolVersion":"2025-03-26","capabilities":{"roots":{}}}`),
+	})
+	handleRequest(context.Background(), sess, JSONRPCRequest{JSONRPC: "2.0", Method: "notifications/initialized"})
+
+	call := toolsCallRequest(t, map[string]any{"dir": root, "search": "hello", "replace": "bye"})
+	if resp := handleRequest(context.Background(), sess, call); resp.Error == nil {
+		t.Fatal("Expected calls to wait for roots/list")
+	}
+
+	// A client that never answers falls back to the --root directories
+	sess.state.Lock()
+	sess.rootsAsked = time.Now().Add(-2 * rootsTimeout)
+	sess.state.Unlock()
+	if resp := handleRequest(context.Background(), sess, call); resp.Error != nil {
+		t.Errorf("Expected call inside --root to succeed after the timeout, got %v", resp.Error.Message)
+	}
+	resp := handleRequest(context.Background(), sess, toolsCallRequest(t, map[string]any{"dir": outside, "search": "hello", "replace": "bye"}))
+	if resp.Error == nil || !strings.Contains(resp.Error.Message, "outside the allowed roots") {
+		t.Errorf("Expected rejection outside --root, got %+v", resp)
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hegner123/repfor/pkg/repfor"
+)
+
+// Recent runs exposed as MCP resources.
+//
+// Every tools/call is recorded in the session's run history with its
+// arguments, per-file diffs and outcome. Clients can list and read them as
+// repfor://runs/<id> resources, and subscribe to repfor://runs (or
+// repfor://runs/latest) to be notified whenever a new run completes. Clients
+// that have listed the resources are also told when the list changes.
+
+const (
+	runsURI          = "repfor://runs"
+	latestRunURI     = "repfor://runs/latest"
+	runURIPrefix     = "repfor://runs/"
+	defaultRunsLimit = 20
+)
+
+// Run statuses
+const (
+	RunCompleted = "completed"
+	RunFailed    = "failed"
+)
+
+type Run struct {
+	ID          string              `json:"id"`
+	URI         string              `json:"uri"`
+	StartedAt   time.Time           `json:"started_at"`
+	FinishedAt  time.Time           `json:"finished_at"`
+	Tool        string              `json:"tool"` // the MCP tool called
+	Status      string              `json:"status"`
+	Error       string              `json:"error,omitempty"`
+	Config      RunConfig           `json:"config"`
+	Result      *repfor.Result      `json:"result,omitempty"`
+	PatchResult *repfor.PatchResult `json:"patch_result,omitempty"` // apply_patch calls, which have no Result
+	Files       []RunFile           `json:"files"`
+}
+
+// RunConfig describes what a run did: its options, as they marshal to JSON,
+// and the profile they were drawn from.
+type RunConfig struct {
+	repfor.Options
+	Profile string `json:"profile,omitempty"`
+	Patch   string `json:"patch,omitempty"` // the diff given to apply_patch
+}
+
+type RunFile struct {
+	Path string `json:"path"`
+	Diff string `json:"diff"`
+}
+
+// MarshalJSON leaves out line_range when no range was set, which omitempty
+// cannot do for a struct field.
+func (c RunConfig) MarshalJSON() ([]byte, error) {
+	type plain RunConfig
+	var lineRange *repfor.LineRange
+	if c.LineRange != (repfor.LineRange{}) {
+		lineRange = &c.LineRange
+	}
+	return json.Marshal(struct {
+		plain
+		LineRange *repfor.LineRange `json:"line_range,omitempty"`
+	}{plain(c), lineRange})
+}
+
+func runConfigOf(config Config) RunConfig {
+	return RunConfig{Options: config.Options, Profile: config.Profile}
+}
+
+// runHistory keeps the most recent runs of a session, oldest first.
+type runHistory struct {
+	limit int
+
+	mu     sync.Mutex
+	nextID int
+	runs   []*Run
+}
+
+func newRunHistory(limit int) *runHistory {
+	if limit <= 0 {
+		limit = defaultRunsLimit
+	}
+	return &runHistory{limit: limit}
+}
+
+// start creates a run of tool for config and wires config.OnChange to
+// collect the per-file diffs. The run is not visible until add is called.
+func (h *runHistory) start(tool string, config *Config) *Run {
+	h.mu.Lock()
+	h.nextID++
+	id := strconv.Itoa(h.nextID)
+	h.mu.Unlock()
+
+	run := &Run{
+		ID:        id,
+		URI:       runURIPrefix + id,
+		StartedAt: time.Now().UTC(),
+		Tool:      tool,
+		Config:    runConfigOf(*config),
+		Files:     make([]RunFile, 0),
+	}
+
+	prev := config.OnChange
+	config.OnChange = func(change repfor.FileChange) {
+		run.Files = append(run.Files, RunFile{
+			Path: change.Path,
+			Diff: repfor.UnifiedDiff(change.Path, change.Path, change.Before, change.After),
+		})
+		if prev != nil {
+			prev(change)
+		}
+	}
+	return run
+}
+
+// add records a finished run, evicting the oldest beyond the limit.
+func (h *runHistory) add(run *Run) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.runs = append(h.runs, run)
+	if len(h.runs) > h.limit {
+		h.runs = h.runs[len(h.runs)-h.limit:]
+	}
+}
+
+func (h *runHistory) list() []*Run {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]*Run(nil), h.runs...)
+}
+
+// get returns the run with the given id, or "latest" for the newest run.
+func (h *runHistory) get(id string) *Run {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if id == "latest" && len(h.runs) > 0 {
+		return h.runs[len(h.runs)-1]
+	}
+	for _, run := range h.runs {
+		if run.ID == id {
+			return run
+		}
+	}
+	return nil
+}
+
+// finishRun records the outcome of a tool call and notifies subscribers, and
+// clients that have listed the resources.
+func (s *session) finishRun(run *Run, result *repfor.Result, err error) {
+	run.FinishedAt = time.Now().UTC()
+	run.Result = result
+	run.Status = RunCompleted
+	if err != nil {
+		run.Status = RunFailed
+		run.Error = err.Error()
+	}
+	s.runs.add(run)
+
+	s.state.Lock()
+	listed := s.listedResources
+	s.state.Unlock()
+	if listed {
+		s.notify(JSONRPCNotification{JSONRPC: "2.0", Method: "notifications/resources/list_changed"})
+	}
+	for _, uri := range []string{runsURI, latestRunURI} {
+		if s.isSubscribed(uri) {
+			s.send(JSONRPCNotification{
+				JSONRPC: "2.0",
+				Method:  "notifications/resources/updated",
+				Params:  ResourceURIParams{URI: uri},
+			})
+		}
+	}
+}
+
+func (s *session) isSubscribed(uri string) bool {
+	s.state.Lock()
+	defer s.state.Unlock()
+	return s.subscriptions[uri]
+}
+
+// MCP resource types
+
+type JSONRPCNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
+type Resource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType,omitempty"`
+}
+
+type ResourcesListResult struct {
+	Resources []Resource `json:"resources"`
+}
+
+type ResourceURIParams struct {
+	URI string `json:"uri"`
+}
+
+type ResourceContents struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type ResourcesReadResult struct {
+	Contents []ResourceContents `json:"contents"`
+}
+
+// errResourceNotFound is the MCP error code for unknown resource URIs.
+const errResourceNotFound = -32002
+
+func handleResourcesList(sess *session, req JSONRPCRequest) *JSONRPCResponse {
+	sess.state.Lock()
+	sess.listedResources = true
+	sess.state.Unlock()
+
+	runs := sess.runs.list()
+	resources := make([]Resource, 0, len(runs)+1)
+	resources = append(resources, Resource{
+		URI:         runsURI,
+		Name:        "Recent repfor runs",
+		Description: "Index of the most recent runs in this session, newest first",
+		MimeType:    "application/json",
+	})
+
+	for i := len(runs) - 1; i >= 0; i-- {
+		run := runs[i]
+		resources = append(resources, Resource{
+			URI:         run.URI,
+			Name:        runName(run),
+			Description: runDescription(run),
+			MimeType:    "application/json",
+		})
+	}
+
+	return newResponse(req.ID, ResourcesListResult{Resources: resources})
+}
+
+func runName(run *Run) string {
+	if run.Tool == applyPatchTool.Name {
+		return fmt.Sprintf("Run %s: apply_patch in %s", run.ID, strings.Join(run.Config.Dirs, ", "))
+	}
+	return fmt.Sprintf("Run %s: %q -> %q", run.ID, run.Config.Search, run.Config.Replace)
+}
+
+func runDescription(run *Run) string {
+	switch {
+	case run.Status == RunFailed:
+		return "Failed: " + run.Error
+	case run.Result != nil:
+		return run.Result.Summary
+	case run.PatchResult != nil:
+		return run.PatchResult.Summary
+	}
+	return run.Status
+}
+
+func handleResourcesRead(sess *session, req JSONRPCRequest) *JSONRPCResponse {
+	var params ResourceURIParams
+	if err := json.Unmarshal(req.Params, &params); err != nil || params.URI == "" {
+		return newError(req.ID, -32602, "Invalid params")
+	}
+
+	var payload any
+	if params.URI == runsURI {
+		runs := sess.runs.list()
+		index := make([]*Run, 0, len(runs))
+		for i := len(runs) - 1; i >= 0; i-- {
+			index = append(index, runs[i])
+		}
+		payload = index
+	} else {
+		id, ok := strings.CutPrefix(params.URI, runURIPrefix)
+		run := sess.runs.get(id)
+		if !ok || run == nil {
+			return newError(req.ID, errResourceNotFound, "Resource not found: "+params.URI)
+		}
+		payload = run
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return newError(req.ID, -32603, "Failed to marshal resource")
+	}
+
+	return newResponse(req.ID, ResourcesReadResult{
+		Contents: []ResourceContents{{URI: params.URI, MimeType: "application/json", Text: string(data)}},
+	})
+}
+
+func handleResourcesSubscribe(sess *session, req JSONRPCRequest, subscribe bool) *JSONRPCResponse {
+	var params ResourceURIParams
+	if err := json.Unmarshal(req.Params, &params); err != nil || params.URI == "" {
+		return newError(req.ID, -32602, "Invalid params")
+	}
+	// A finished run never changes, so only the index and the latest run
+	// can be subscribed to
+	if params.URI != runsURI && params.URI != latestRunURI {
+		return newError(req.ID, errResourceNotFound, "Resource not found: "+params.URI)
+	}
+
+	sess.state.Lock()
+	if subscribe {
+		sess.subscriptions[params.URI] = true
+	} else {
+		delete(sess.subscriptions, params.URI)
+	}
+	sess.state.Unlock()
+
+	return newResponse(req.ID, struct{}{})
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hegner123/repfor/pkg/repfor"
+)
+
+func readResource(t *testing.T, sess *session, uri string) *JSONRPCResponse {
+	t.Helper()
+	params, _ := json.Marshal(ResourceURIParams{URI: uri})
+	return handleRequest(context.Background(), sess, JSONRPCRequest{JSONRPC: "2.0", ID: float64(20), Method: "resources/read", Params: params})
+}
+
+func decodeRun(t *testing.T, resp *JSONRPCResponse) Run {
+	t.Helper()
+	if resp.Error != nil {
+		t.Fatalf("resources/read failed: %s", resp.Error.Message)
+	}
+	result := resp.Result.(ResourcesReadResult)
+	var run Run
+	if err := json.Unmarshal([]byte(result.Contents[0].Text), &run); err != nil {
+		t.Fatalf("Invalid run JSON: %v", err)
+	}
+	return run
+}
+
+func TestResources_RunRecordedWithDiff(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.txt", "keep\nold value\n")
+
+	var buf bytes.Buffer
+	sess := newStdioSession(&buf, serverOptions{})
+
+	resp := handleRequest(context.Background(), sess, toolsCallRequest(t, map[string]any{"file": path, "search": "old", "replace": "new"}))
+	if resp.Error != nil {
+		t.Fatalf("tools/call failed: %s", resp.Error.Message)
+	}
+
+	list := handleRequest(context.Background(), sess, JSONRPCRequest{JSONRPC: "2.0", ID: float64(2), Method: "resources/list"})
+	resources := list.Result.(ResourcesListResult).Resources
+	if len(resources) != 2 || resources[0].URI != runsURI || resources[1].URI != "repfor://runs/1" {
+		t.Fatalf("Unexpected resources: %+v", resources)
+	}
+
+	run := decodeRun(t, readResource(t, sess, "repfor://runs/1"))
+	if run.Status != RunCompleted {
+		t.Errorf("status = %q, want %q", run.Status, RunCompleted)
+	}
+	if run.Config.Search != "old" || run.Config.Replace != "new" {
+		t.Errorf("config not recorded: %+v", run.Config)
+	}
+	if len(run.Files) != 1 {
+		t.Fatalf("Expected 1 file, got %d", len(run.Files))
+	}
+	if !strings.Contains(run.Files[0].Diff, "-old value\n+new value\n") {
+		t.Errorf("diff missing change:\n%s", run.Files[0].Diff)
+	}
+
+	latest := decodeRun(t, readResource(t, sess, latestRunURI))
+	if latest.ID != "1" {
+		t.Errorf("latest run id = %q, want 1", latest.ID)
+	}
+
+	// Options left unset are not recorded
+	text := readResource(t, sess, "repfor://runs/1").Result.(ResourcesReadResult).Contents[0].Text
+	if strings.Contains(text, "line_range") {
+		t.Errorf("unset line range recorded: %s", text)
+	}
+}
+
+func TestResources_ListChangedAfterList(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.txt", "x\n")
+
+	var buf bytes.Buffer
+	sess := newStdioSession(&buf, serverOptions{})
+	listChanged := func() bool {
+		for _, msg := range rpcLines(t, &buf) {
+			if msg.Method == "notifications/resources/list_changed" {
+				return true
+			}
+		}
+		return false
+	}
+
+	handleRequest(context.Background(), sess, toolsCallRequest(t, map[string]any{"file": path, "search": "x", "replace": "y"}))
+	if listChanged() {
+		t.Error("Unexpected list_changed before the client listed resources")
+	}
+
+	handleRequest(context.Background(), sess, JSONRPCRequest{JSONRPC: "2.0", ID: float64(1), Method: "resources/list"})
+	handleRequest(context.Background(), sess, toolsCallRequest(t, map[string]any{"file": path, "search": "y", "replace": "x"}))
+	if !listChanged() {
+		t.Error("Expected list_changed after the client listed resources")
+	}
+}
+
+func TestResources_DryRunAndFailureRecorded(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.txt", "hello\n")
+
+	var buf bytes.Buffer
+	sess := newStdioSession(&buf, serverOptions{})
+
+	handleRequest(context.Background(), sess, toolsCallRequest(t, map[string]any{"file": path, "search": "hello", "replace": "bye", "dry_run": true}))
+	handleRequest(context.Background(), sess, toolsCallRequest(t, map[string]any{"dir": tmpDir + "/missing", "search": "hello", "replace": "bye"}))
+
+	dry := decodeRun(t, readResource(t, sess, "repfor://runs/1"))
+	if !dry.Config.DryRun || len(dry.Files) != 1 || dry.Files[0].Diff == "" {
+		t.Errorf("dry run should still record a diff: %+v", dry)
+	}
+	if content := readFileContent(t, path); content != "hello\n" {
+		t.Errorf("dry run modified file: %q", content)
+	}
+
+	failed := decodeRun(t, readResource(t, sess, "repfor://runs/2"))
+	if failed.Status != RunFailed || failed.Error == "" {
+		t.Errorf("Expected failed run with error, got %+v", failed)
+	}
+}
+
+func TestResources_HistoryLimit(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.txt", "x\n")
+
+	var buf bytes.Buffer
+	sess := newStdioSession(&buf, serverOptions{historySize: 2})
+
+	for i := 0; i < 3; i++ {
+		handleRequest(context.Background(), sess, toolsCallRequest(t, map[string]any{"file": path, "search": "x", "replace": "x", "dry_run": true}))
+	}
+
+	if resp := readResource(t, sess, "repfor://runs/1"); resp.Error == nil || resp.Error.Code != errResourceNotFound {
+		t.Errorf("Expected evicted run to be not found, got %+v", resp)
+	}
+	if resp := readResource(t, sess, "repfor://runs/3"); resp.Error != nil {
+		t.Errorf("Expected newest run to be readable: %s", resp.Error.Message)
+	}
+
+	index := readResource(t, sess, runsURI).Result.(ResourcesReadResult)
+	var runs []Run
+	if err := json.Unmarshal([]byte(index.Contents[0].Text), &runs); err != nil {
+		t.Fatalf("Invalid index JSON: %v", err)
+	}
+	if len(runs) != 2 || runs[0].ID != "3" {
+		t.Errorf("index should list 2 runs newest first, got %d (first %q)", len(runs), runs[0].ID)
+	}
+}
+
+func TestResources_SubscribeNotifies(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	path := createTestFile(t, tmpDir, "a.txt", "x\n")
+
+	var buf bytes.Buffer
+	sess := newStdioSession(&buf, serverOptions{})
+
+	params, _ := json.Marshal(ResourceURIParams{URI: runsURI})
+	resp := handleRequest(context.Background(), sess, JSONRPCRequest{JSONRPC: "2.0", ID: float64(1), Method: "resources/subscribe", Params: params})
+	if resp.Error != nil {
+		t.Fatalf("subscribe failed: %s", resp.Error.Message)
+	}
+
+	handleRequest(context.Background(), sess, toolsCallRequest(t, map[string]any{"file": path, "search": "x", "replace": "y"}))
+
+	updated := false
+	for _, msg := range rpcLines(t, &buf) {
+		if msg.Method == "notifications/resources/updated" && strings.Contains(string(msg.Params), runsURI) {
+			updated = true
+		}
+	}
+	if !updated {
+		t.Error("Expected notifications/resources/updated after run completed")
+	}
+
+	handleRequest(context.Background(), sess, JSONRPCRequest{JSONRPC: "2.0", ID: float64(2), Method: "resources/unsubscribe", Params: params})
+	handleRequest(context.Background(), sess, toolsCallRequest(t, map[string]any{"file": path, "search": "y", "replace": "x"}))
+	for _, msg := range rpcLines(t, &buf) {
+		if msg.Method == "notifications/resources/updated" {
+			t.Error("Unexpected update notification after unsubscribe")
+		}
+	}
+}
+
+func TestResources_ReadUnknown(t *testing.T) {
+	var buf bytes.Buffer
+	sess := newStdioSession(&buf, serverOptions{})
+
+	for _, uri := range []string{"repfor://runs/99", "file:///etc/passwd"} {
+		resp := readResource(t, sess, uri)
+		if resp.Error == nil || resp.Error.Code != errResourceNotFound {
+			t.Errorf("read %s: expected resource not found, got %+v", uri, resp)
+		}
+	}
+
+	// Only the index and the latest run change, so only they can be subscribed to
+	for _, uri := range []string{"repfor://runs/1", "repfor://runsx", "file:///etc/passwd"} {
+		params, _ := json.Marshal(ResourceURIParams{URI: uri})
+		resp := handleRequest(context.Background(), sess, JSONRPCRequest{JSONRPC: "2.0", ID: float64(1), Method: "resources/subscribe", Params: params})
+		if resp.Error == nil || resp.Error.Code != errResourceNotFound {
+			t.Errorf("subscribe %s: expected resource not found, got %+v", uri, resp)
+		}
+	}
+}
+
+func TestRunConfig_RoundTrip(t *testing.T) {
+	config := Config{Profile: "go"}
+	config.Files = []string{"a.go"}
+	config.Search, config.Replace = "old", "new"
+	config.LineRange = repfor.LineRange{Start: 3, End: 9}
+	config.BetweenStart, config.BetweenEnd = "BEGIN", "END"
+	config.Scope = repfor.ScopeCode
+	config.IgnoreWhitespace = true
+	config.MaxReplacements = 4
+	config.OnChange = func(repfor.FileChange) {}
+
+	data, err := json.Marshal(runConfigOf(config))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if !strings.Contains(string(data), `"line_range":"3-9"`) {
+		t.Errorf("line range not recorded as text: %s", data)
+	}
+	var got RunConfig
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	want := runConfigOf(config)
+	want.OnChange = nil
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip = %+v, want %+v", got, want)
+	}
+}
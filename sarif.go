@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/hegner123/repfor/pkg/repfor"
+)
+
+// SARIF 2.1.0 output, for code scanning UIs and CI annotations. Only the
+// parts of the format repfor has data for are modeled.
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifSrcRoot = "%SRCROOT%" // base of relative artifact URIs: the working directory
+)
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool        sarifTool         `json:"tool"`
+	ColumnKind  string            `json:"columnKind"`
+	Invocations []sarifInvocation `json:"invocations"`
+	Results     []sarifResult     `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifInvocation struct {
+	ExecutionSuccessful bool                `json:"executionSuccessful"`
+	Notifications       []sarifNotification `json:"toolExecutionNotifications,omitempty"`
+}
+
+type sarifNotification struct {
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	RuleIndex int             `json:"ruleIndex"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+	Fixes     []sarifFix      `json:"fixes,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn"`
+	EndLine     int `json:"endLine"`
+	EndColumn   int `json:"endColumn"`
+}
+
+type sarifFix struct {
+	Description     sarifMessage          `json:"description"`
+	ArtifactChanges []sarifArtifactChange `json:"artifactChanges"`
+}
+
+type sarifArtifactChange struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Replacements     []sarifReplacement    `json:"replacements"`
+}
+
+type sarifReplacement struct {
+	DeletedRegion   sarifRegion  `json:"deletedRegion"`
+	InsertedContent sarifMessage `json:"insertedContent"`
+}
+
+// writeResultSARIF writes result as a SARIF log with one rule for the search
+// pattern and one result per match. With replaced set every result also
+// carries a fix putting the match's Replacement in its place. The result must
+// hold the matches (Options.CollectMatches).
+func writeResultSARIF(w io.Writer, result *repfor.Result, search string, replaced bool) error {
+	rule := sarifRule{
+		ID:               sarifRuleID(search),
+		ShortDescription: sarifMessage{Text: fmt.Sprintf("Occurrence of %q", search)},
+	}
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "repfor",
+			InformationURI: "https://github.com/hegner123/repfor",
+			Rules:          []sarifRule{rule},
+		}},
+		// Match columns count characters, not UTF-16 units
+		ColumnKind:  "unicodeCodePoints",
+		Invocations: []sarifInvocation{{ExecutionSuccessful: true}},
+		Results:     []sarifResult{},
+	}
+
+	for _, dir := range result.Directories {
+		for _, f := range dir.Files {
+			path := f.Path
+			if dir.Dir != "(files)" && !filepath.IsAbs(path) {
+				path = filepath.Join(dir.Dir, path)
+			}
+			artifact := sarifArtifact(path)
+
+			for _, m := range f.Matches {
+				region := sarifMatchRegion(m)
+				res := sarifResult{
+					RuleID:  rule.ID,
+					Level:   "warning",
+					Message: sarifMessage{Text: fmt.Sprintf("Found %q", m.Match)},
+					Locations: []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{
+						ArtifactLocation: artifact,
+						Region:           &region,
+					}}},
+				}
+				if replaced {
+					res.Fixes = []sarifFix{{
+						Description: sarifMessage{Text: fmt.Sprintf("Replace %q with %q", m.Match, m.Replacement)},
+						ArtifactChanges: []sarifArtifactChange{{
+							ArtifactLocation: artifact,
+							Replacements:     []sarifReplacement{{DeletedRegion: region, InsertedContent: sarifMessage{Text: m.Replacement}}},
+						}},
+					}}
+				}
+				run.Results = append(run.Results, res)
+			}
+		}
+	}
+
+	for _, warning := range result.Warnings {
+		run.Invocations[0].Notifications = append(run.Invocations[0].Notifications, sarifNotification{
+			Level:   "warning",
+			Message: sarifMessage{Text: warning.Message},
+			Locations: []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifact(warning.Path),
+			}}},
+		})
+	}
+
+	output, err := json.Marshal(sarifLog{Version: sarifVersion, Schema: sarifSchema, Runs: []sarifRun{run}})
+	if err != nil {
+		return fmt.Errorf("marshaling SARIF: %w", err)
+	}
+	_, err = fmt.Fprintln(w, string(output))
+	return err
+}
+
+// sarifRuleID derives a readable rule ID from a search pattern: its letters
+// and digits, lowercased, with every other run of characters turned into "-".
+func sarifRuleID(search string) string {
+	var b strings.Builder
+	for _, r := range search {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(unicode.ToLower(r))
+		case b.Len() > 0 && !strings.HasSuffix(b.String(), "-"):
+			b.WriteByte('-')
+		}
+	}
+	id := strings.TrimSuffix(b.String(), "-")
+	if id == "" {
+		id = "pattern"
+	}
+	return "repfor/" + id
+}
+
+// sarifArtifact locates path: relative to the working directory when it is
+// inside it, otherwise as an absolute file URI.
+func sarifArtifact(path string) sarifArtifactLocation {
+	rel := displayPath(path)
+	if filepath.IsAbs(rel) {
+		return sarifArtifactLocation{URI: (&url.URL{Scheme: "file", Path: filepath.ToSlash(rel)}).String()}
+	}
+	return sarifArtifactLocation{URI: (&url.URL{Path: rel}).String(), URIBaseID: sarifSrcRoot}
+}
+
+// sarifMatchRegion returns the region m covers. The end column is exclusive,
+// and a multiline match ends on a later line.
+func sarifMatchRegion(m repfor.Match) sarifRegion {
+	match := strings.ReplaceAll(m.Match, "\r\n", "\n")
+	region := sarifRegion{
+		StartLine:   m.Line,
+		StartColumn: m.Column,
+		EndLine:     m.Line,
+		EndColumn:   m.Column + utf8.RuneCountInString(match),
+	}
+	if n := strings.Count(match, "\n"); n > 0 {
+		region.EndLine += n
+		region.EndColumn = utf8.RuneCountInString(match[strings.LastIndex(match, "\n")+1:]) + 1
+	}
+	return region
+}
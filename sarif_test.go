@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hegner123/repfor/pkg/repfor"
+)
+
+func TestSarifRuleID(t *testing.T) {
+	tests := map[string]string{
+		"oldClient.Do":  "repfor/oldclient-do",
+		"  foo  bar ":   "repfor/foo-bar",
+		"Größe":         "repfor/größe",
+		"->":            "repfor/pattern",
+		"TODO(gopher):": "repfor/todo-gopher",
+	}
+	for search, want := range tests {
+		if got := sarifRuleID(search); got != want {
+			t.Errorf("sarifRuleID(%q) = %q, want %q", search, got, want)
+		}
+	}
+}
+
+func TestSarifMatchRegion(t *testing.T) {
+	got := sarifMatchRegion(repfor.Match{Line: 3, Column: 2, Match: "é foo", Text: "xé foo"})
+	if want := (sarifRegion{StartLine: 3, StartColumn: 2, EndLine: 3, EndColumn: 7}); got != want {
+		t.Errorf("single line region = %+v, want %+v", got, want)
+	}
+	got = sarifMatchRegion(repfor.Match{Line: 3, Column: 5, Match: "a {\r\n\tbc", Text: "if a {"})
+	if want := (sarifRegion{StartLine: 3, StartColumn: 5, EndLine: 4, EndColumn: 4}); got != want {
+		t.Errorf("multiline region = %+v, want %+v", got, want)
+	}
+}
+
+func TestCLI_OutputSARIF(t *testing.T) {
+	t.Setenv(stateDirEnv, t.TempDir())
+	tmpDir := setupTestDir(t)
+	defer cleanupTestDir(t, tmpDir)
+	createTestFile(t, tmpDir, "a.go", "x := oldClient.Do(req)\ny := oldClient.Do(req)\n")
+
+	var log sarifLog
+	code, stdout, _ := runCLI(t, "replace", "--dir", tmpDir, "--search", "oldClient.Do", "--replace", "newClient.Do", "--dry-run", "--output", "sarif")
+	if code != ExitSuccess {
+		t.Fatalf("exit code %d", code)
+	}
+	if err := json.Unmarshal([]byte(stdout), &log); err != nil {
+		t.Fatalf("invalid SARIF: %v\n%s", err, stdout)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("unexpected log: %+v", log)
+	}
+	run := log.Runs[0]
+	if rules := run.Tool.Driver.Rules; len(rules) != 1 || rules[0].ID != "repfor/oldclient-do" {
+		t.Errorf("rules = %+v", rules)
+	}
+	if len(run.Results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(run.Results))
+	}
+
+	res := run.Results[1]
+	loc := res.Locations[0].PhysicalLocation
+	if !strings.HasPrefix(loc.ArtifactLocation.URI, "file://") || !strings.HasSuffix(loc.ArtifactLocation.URI, "/"+filepath.Base(tmpDir)+"/a.go") {
+		t.Errorf("artifact URI = %q", loc.ArtifactLocation.URI)
+	}
+	if want := (sarifRegion{StartLine: 2, StartColumn: 6, EndLine: 2, EndColumn: 18}); *loc.Region != want {
+		t.Errorf("region = %+v, want %+v", *loc.Region, want)
+	}
+	if len(res.Fixes) != 1 {
+		t.Fatalf("expected a fix, got %+v", res.Fixes)
+	}
+	repl := res.Fixes[0].ArtifactChanges[0].Replacements[0]
+	if repl.DeletedRegion != *loc.Region || repl.InsertedContent.Text != "newClient.Do" {
+		t.Errorf("fix replacement = %+v", repl)
+	}
+
+	// Search results have no fix
+	_, stdout, _ = runCLI(t, "search", "--dir", tmpDir, "--search", "oldClient.Do", "--output", "sarif")
+	log = sarifLog{}
+	if err := json.Unmarshal([]byte(stdout), &log); err != nil {
+		t.Fatalf("invalid SARIF: %v", err)
+	}
+	if results := log.Runs[0].Results; len(results) != 2 || results[0].Fixes != nil {
+		t.Errorf("search results = %+v", results)
+	}
+
+	// No matches is an empty result list, not null
+	_, stdout, _ = runCLI(t, "search", "--dir", tmpDir, "--search", "absent", "--output", "sarif")
+	if !strings.Contains(stdout, `"results":[]`) {
+		t.Errorf("expected empty results: %s", stdout)
+	}
+}